@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+type (
+	APIKeyReqBody struct {
+		Name   string   `json:"name"`
+		Role   string   `json:"role"`
+		Scopes []string `json:"scopes"`
+	}
+
+	APIKeyInfo struct {
+		ID         uint       `json:"id"`
+		Name       string     `json:"name"`
+		Prefix     string     `json:"prefix"`
+		Role       string     `json:"role"`
+		Scopes     []string   `json:"scopes"`
+		CreatedBy  string     `json:"created_by"`
+		CreatedAt  time.Time  `json:"created_at"`
+		LastUsedAt *time.Time `json:"last_used_at"`
+		RevokedAt  *time.Time `json:"revoked_at"`
+	}
+
+	// CreatedAPIKey is only returned once, the key itself is not stored
+	CreatedAPIKey struct {
+		APIKeyInfo
+		Key string `json:"key"`
+	}
+)
+
+const (
+	ScopeProductsRead   = "products:read"
+	ScopeCartsRead      = "carts:read"
+	ScopeCartsWrite     = "carts:write"
+	ScopeWishlistsRead  = "wishlists:read"
+	ScopeWishlistsWrite = "wishlists:write"
+	ScopeOrdersRead     = "orders:read"
+	ScopeOrdersWrite    = "orders:write"
+
+	// APIKeyHeader carries the API key of service clients instead of a bearer token
+	APIKeyHeader       = "X-API-Key"
+	apiKeyPrefix       = "mm"
+	apiKeySubject      = "apikey:"
+	apiKeyTouchTimeout = time.Minute
+)
+
+var validScopes = map[string]bool{
+	ScopeProductsRead:   true,
+	ScopeCartsRead:      true,
+	ScopeCartsWrite:     true,
+	ScopeWishlistsRead:  true,
+	ScopeWishlistsWrite: true,
+	ScopeOrdersRead:     true,
+	ScopeOrdersWrite:    true,
+}
+
+// ErrInvalidAPIKey is returned for unknown, malformed or revoked API keys
+var ErrInvalidAPIKey = errors.New("Invalid API key")
+
+func (a *authHandler) CreateAPIKey(r *http.Request) (*CreatedAPIKey, error) {
+	principal, err := PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var reqData APIKeyReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	reqData.Name = strings.TrimSpace(reqData.Name)
+	if reqData.Name == "" || len(reqData.Name) > 100 {
+		return nil, errors.New("API key name must be 1 to 100 characters long")
+	}
+
+	if reqData.Role == "" {
+		reqData.Role = RoleCustomer
+	}
+
+	if reqData.Role != RoleCustomer && reqData.Role != RoleStaff {
+		return nil, fmt.Errorf("API keys cannot have role:%v", reqData.Role)
+	}
+
+	if len(reqData.Scopes) == 0 {
+		return nil, errors.New("API keys need at least one scope")
+	}
+
+	for _, scope := range reqData.Scopes {
+		if !validScopes[scope] {
+			return nil, fmt.Errorf("Unknown scope:%v", scope)
+		}
+	}
+
+	prefix, key, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+
+	apiKey := &entities.APIKey{
+		Name:      reqData.Name,
+		Prefix:    prefix,
+		KeyHash:   hashSecret(key),
+		Role:      reqData.Role,
+		Scopes:    strings.Join(reqData.Scopes, ","),
+		CreatedBy: principal.Subject,
+	}
+
+	if err := a.dbManager.CreateAPIKey(apiKey); err != nil {
+		return nil, err
+	}
+
+	return &CreatedAPIKey{
+		APIKeyInfo: populateAPIKeyInfo(apiKey),
+		Key:        key,
+	}, nil
+}
+
+func (a *authHandler) GetAllAPIKeys() *[]APIKeyInfo {
+	apiKeys := []APIKeyInfo{}
+
+	for _, apiKey := range *a.dbManager.GetAPIKeys() {
+		apiKeys = append(apiKeys, populateAPIKeyInfo(&apiKey))
+	}
+
+	return &apiKeys
+}
+
+func (a *authHandler) RevokeAPIKey(keyID string) (string, error) {
+	id, err := strconv.ParseUint(keyID, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse keyID:%v", keyID)
+	}
+
+	if err := a.dbManager.RevokeAPIKey(uint(id)); err != nil {
+		return "", err
+	}
+
+	return "Successfully revoked API key", nil
+}
+
+func (a *authHandler) validateAPIKey(key string) (*Principal, error) {
+	parts := strings.Split(key, ".")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], apiKeyPrefix+"_") {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := a.dbManager.GetAPIKeyByPrefix(parts[0])
+	if err != nil || apiKey.RevokedAt != nil {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if subtle.ConstantTimeCompare([]byte(apiKey.KeyHash), []byte(hashSecret(key))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchTimeout {
+		a.dbManager.TouchAPIKey(apiKey.ID, now)
+	}
+
+	return &Principal{
+		Subject: apiKeySubject + strconv.FormatUint(uint64(apiKey.ID), 10),
+		Role:    apiKey.Role,
+		Scopes:  strings.Split(apiKey.Scopes, ","),
+		APIKey:  true,
+	}, nil
+}
+
+func populateAPIKeyInfo(apiKey *entities.APIKey) APIKeyInfo {
+	return APIKeyInfo{
+		ID:         apiKey.ID,
+		Name:       apiKey.Name,
+		Prefix:     apiKey.Prefix,
+		Role:       apiKey.Role,
+		Scopes:     strings.Split(apiKey.Scopes, ","),
+		CreatedBy:  apiKey.CreatedBy,
+		CreatedAt:  apiKey.CreatedAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+	}
+}
+
+// newAPIKey returns the lookup prefix and the full "mm_<prefix>.<secret>" key
+func newAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+
+	prefix := apiKeyPrefix + "_" + hex.EncodeToString(prefixBytes)
+
+	return prefix, prefix + "." + base64.RawURLEncoding.EncodeToString(secretBytes), nil
+}
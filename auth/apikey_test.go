@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+type fakeAPIKeyDB struct {
+	db.Manager
+	apiKeys map[string]*entities.APIKey
+}
+
+func (f *fakeAPIKeyDB) CreateAPIKey(apiKey *entities.APIKey) error {
+	apiKey.ID = uint(len(f.apiKeys) + 1)
+	f.apiKeys[apiKey.Prefix] = apiKey
+	return nil
+}
+
+func (f *fakeAPIKeyDB) GetAPIKeyByPrefix(prefix string) (*entities.APIKey, error) {
+	apiKey, ok := f.apiKeys[prefix]
+	if !ok {
+		return nil, errors.New("API key does not exist")
+	}
+	return apiKey, nil
+}
+
+func (f *fakeAPIKeyDB) TouchAPIKey(id uint, usedAt time.Time) {}
+
+func Test_authHandler_validateAPIKey(t *testing.T) {
+	fakeDB := &fakeAPIKeyDB{apiKeys: make(map[string]*entities.APIKey)}
+	a := &authHandler{dbManager: fakeDB, keySet: NewHMACKeySet("notSoSecret")}
+
+	r := httptest.NewRequest("POST", "/api/apikeys", bytes.NewBufferString(`{"name":"POS terminal 1","role":"store-staff","scopes":["products:read","carts:write"]}`))
+	r = r.WithContext(WithPrincipal(r.Context(), &Principal{Subject: "1", Role: RoleAdmin}))
+	created, err := a.CreateAPIKey(r)
+	if err != nil {
+		t.Fatalf("CreateAPIKey() error = %v", err)
+	}
+
+	if stored := fakeDB.apiKeys[created.Prefix]; stored.KeyHash == created.Key || stored.KeyHash == "" {
+		t.Fatalf("CreateAPIKey() stored key hash = %v", stored.KeyHash)
+	}
+
+	revoked := &entities.APIKey{Prefix: "mm_000000000000", Role: RoleCustomer, Scopes: ScopeCartsRead, KeyHash: hashSecret("mm_000000000000.secret")}
+	fakeDB.CreateAPIKey(revoked)
+	revokedAt := time.Now()
+	revoked.RevokedAt = &revokedAt
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{
+			name: "Valid key",
+			key:  created.Key,
+		},
+		{
+			name:    "Wrong secret",
+			key:     created.Prefix + ".not-the-secret",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown prefix",
+			key:     "mm_ffffffffffff.secret",
+			wantErr: true,
+		},
+		{
+			name:    "Malformed key",
+			key:     "not-an-api-key",
+			wantErr: true,
+		},
+		{
+			name:    "Revoked key",
+			key:     "mm_000000000000.secret",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/products", nil)
+			r.Header.Set(APIKeyHeader, tt.key)
+
+			r, err := a.ValidateRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			principal, err := PrincipalFromRequest(r)
+			if err != nil {
+				t.Fatalf("PrincipalFromRequest() error = %v", err)
+			}
+			if principal.Role != RoleStaff || !principal.APIKey {
+				t.Errorf("ValidateRequest() principal = %+v", principal)
+			}
+			if !principal.HasScope(ScopeProductsRead) || !principal.HasScope(ScopeCartsWrite) || principal.HasScope(ScopeCartsRead) {
+				t.Errorf("ValidateRequest() scopes = %v", principal.Scopes)
+			}
+		})
+	}
+}
+
+func TestPrincipal_HasScope(t *testing.T) {
+	userPrincipal := &Principal{Role: RoleCustomer}
+	if !userPrincipal.HasScope(ScopeCartsWrite) {
+		t.Errorf("HasScope() user tokens must not be restricted by scopes")
+	}
+
+	apiKeyPrincipal := &Principal{Role: RoleCustomer, APIKey: true}
+	if apiKeyPrincipal.HasScope(ScopeCartsWrite) {
+		t.Errorf("HasScope() API key without scopes must not have any scope")
+	}
+}
@@ -1,86 +1,315 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
 	"github.com/emanpicar/minimart-api/settings"
-	"github.com/gorilla/context"
+	"github.com/emanpicar/minimart-api/throttle"
+	"golang.org/x/crypto/bcrypt"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
 type (
 	Manager interface {
-		Authenticate(body io.ReadCloser) (string, error)
-		ValidateRequest(r *http.Request) error
+		Authenticate(r *http.Request) (*TokenPair, error)
+		Refresh(body io.ReadCloser) (*TokenPair, error)
+		Logout(r *http.Request) (string, error)
+		ValidateRequest(r *http.Request) (*http.Request, error)
+		JWKS() *JSONWebKeySet
+		CreateAPIKey(r *http.Request) (*CreatedAPIKey, error)
+		GetAllAPIKeys() *[]APIKeyInfo
+		RevokeAPIKey(keyID string) (string, error)
 	}
 
-	authHandler struct{}
+	authHandler struct {
+		dbManager    db.Manager
+		keySet       *KeySet
+		userThrottle throttle.Manager
+		ipThrottle   throttle.Manager
+		dummyHash    []byte
+	}
 
 	User struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
 	}
+
+	// Claims are the token claims issued by Authenticate, the subject holds the credential ID
+	Claims struct {
+		jwt.StandardClaims
+		Role string `json:"role"`
+	}
 )
 
-func NewManager() Manager {
-	return &authHandler{}
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "store-staff"
+	RoleAdmin    = "admin"
+	// RoleGuest is the role of anonymous cart sessions, no token is ever issued for it
+	RoleGuest = "guest"
+)
+
+// ErrInvalidCredentials is returned when the username or password does not match a stored credential
+var ErrInvalidCredentials = errors.New("Invalid username or password")
+
+// LockedError is returned when logins are refused after too many failed attempts
+type LockedError struct {
+	RetryAfter time.Duration
 }
 
-func (a *authHandler) Authenticate(body io.ReadCloser) (string, error) {
-	var user User
-	if err := json.NewDecoder(body).Decode(&user); err != nil {
-		return "", err
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("Too many failed login attempts, retry after %v seconds", e.RetryAfterSeconds())
+}
+
+// RetryAfterSeconds rounds up so clients never retry before the lockout ends
+func (e *LockedError) RetryAfterSeconds() int {
+	return int((e.RetryAfter + time.Second - 1) / time.Second)
+}
+
+// NewManager uses throttleStore to track failed logins per username and per client IP
+func NewManager(dbManager db.Manager, keySet *KeySet, throttleStore throttle.Store) Manager {
+	// dummyHash is compared against when the username is unknown so that
+	// both failure paths take roughly the same time
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("minimart-dummy-password"), bcrypt.DefaultCost)
+
+	throttleConfig := throttle.Config{
+		MaxAttempts: settings.GetLoginMaxAttempts(),
+		BaseDelay:   settings.GetLoginBackoffBase(),
+		MaxDelay:    settings.GetLoginBackoffMax(),
+		ResetAfter:  settings.GetLoginBackoffMax(),
 	}
+	ipThrottleConfig := throttleConfig
+	ipThrottleConfig.MaxAttempts = settings.GetLoginMaxAttemptsPerIP()
 
-	// TODO validate user and pass in DB
-	// code here <--
+	return &authHandler{
+		dbManager:    dbManager,
+		keySet:       keySet,
+		userThrottle: throttle.NewManager(throttleStore, throttleConfig),
+		ipThrottle:   throttle.NewManager(throttleStore, ipThrottleConfig),
+		dummyHash:    dummyHash,
+	}
+}
+
+// IsValidRole reports whether role is one of the known roles
+func IsValidRole(role string) bool {
+	switch role {
+	case RoleCustomer, RoleStaff, RoleAdmin:
+		return true
+	}
+
+	return false
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"username": user.Username,
-		"password": user.Password,
-	})
+// NormalizeUsername returns the form usernames are stored, looked up and throttled by,
+// usernames are unique regardless of case
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
 
-	tokenString, err := token.SignedString([]byte(settings.GetTokenSecret()))
+// HashPassword returns the bcrypt hash of password for storing in a credential
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return "", err
 	}
 
-	return tokenString, nil
+	return string(hash), nil
 }
 
-func (a *authHandler) ValidateRequest(r *http.Request) error {
-	authorizationHeader := r.Header.Get("authorization")
-	if authorizationHeader == "" {
-		return errors.New("An authorization header is required")
+// CheckPassword reports whether password matches the stored bcrypt hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (a *authHandler) Authenticate(r *http.Request) (*TokenPair, error) {
+	var user User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		return nil, err
 	}
 
-	bearerToken := strings.Split(authorizationHeader, " ")
-	if len(bearerToken) != 2 {
-		return errors.New("Cannot parse authorization header")
+	user.Username = NormalizeUsername(user.Username)
+	userKey := "login:user:" + user.Username
+	ipKey := "login:ip:" + getClientIP(r)
+
+	// Every attempt is counted as failed before the password is checked so parallel
+	// attempts can not get past the lockout, a successful login takes it back
+	if wait := a.userThrottle.Reserve(userKey); wait > 0 {
+		return nil, &LockedError{RetryAfter: wait}
 	}
 
-	token, err := jwt.Parse(bearerToken[1], func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Cannot parse authorization header")
+	if wait := a.ipThrottle.Reserve(ipKey); wait > 0 {
+		a.userThrottle.Release(userKey)
+		return nil, &LockedError{RetryAfter: wait}
+	}
+
+	credential, err := a.validateCredential(user)
+	if err != nil {
+		return nil, err
+	}
+
+	a.userThrottle.Reset(userKey)
+	a.ipThrottle.Release(ipKey)
+
+	familyID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.issueTokenPair(credential, familyID)
+}
+
+// ValidateRequest returns r with the authenticated Principal on its context
+func (a *authHandler) ValidateRequest(r *http.Request) (*http.Request, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		principal, err := a.validateAPIKey(key)
+		if err != nil {
+			return nil, err
 		}
-		return []byte(settings.GetTokenSecret()), nil
-	})
 
+		return r.WithContext(WithPrincipal(r.Context(), principal)), nil
+	}
+
+	bearerToken, err := getBearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(bearerToken, &Claims{}, a.keySet.verificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Invalid authorization token")
+	}
+
+	if err := a.verifyClaims(claims); err != nil {
+		return nil, err
+	}
+
+	if a.dbManager.IsTokenRevoked(claims.Id) {
+		return nil, errors.New("Authorization token has been revoked")
+	}
+
+	return r.WithContext(WithPrincipal(r.Context(), newPrincipal(claims))), nil
+}
+
+func (a *authHandler) JWKS() *JSONWebKeySet {
+	return a.keySet.JWKS()
+}
+
+func (a *authHandler) validateCredential(user User) (*entities.Credential, error) {
+	credential, err := a.dbManager.GetCredentialByUsername(user.Username)
 	if err != nil {
-		return err
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(user.Password))
+		return nil, ErrInvalidCredentials
 	}
 
-	if !token.Valid {
+	if !CheckPassword(credential.PasswordHash, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	return credential, nil
+}
+
+func (a *authHandler) signAccessToken(credential *entities.Credential) (string, error) {
+	claims, err := a.newClaims(credential)
+	if err != nil {
+		return "", err
+	}
+
+	return a.keySet.sign(claims)
+}
+
+func (a *authHandler) newClaims(credential *entities.Credential) (*Claims, error) {
+	tokenID, err := newTokenID()
+	if err != nil {
+		return nil, err
+	}
+
+	role := credential.Role
+	if !IsValidRole(role) {
+		role = RoleCustomer
+	}
+
+	now := time.Now()
+
+	return &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   formatCredentialID(credential.ID),
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(settings.GetTokenTTL()).Unix(),
+			Issuer:    settings.GetTokenIssuer(),
+			Audience:  settings.GetTokenAudience(),
+			Id:        tokenID,
+		},
+		Role: role,
+	}, nil
+}
+
+func (a *authHandler) verifyClaims(claims *Claims) error {
+	if claims.Subject == "" || claims.Id == "" || claims.ExpiresAt == 0 || !IsValidRole(claims.Role) {
 		return errors.New("Invalid authorization token")
 	}
 
-	context.Set(r, "tokenClaims", token.Claims)
+	if !claims.VerifyIssuer(settings.GetTokenIssuer(), true) {
+		return errors.New("Invalid authorization token issuer")
+	}
+
+	if !claims.VerifyAudience(settings.GetTokenAudience(), true) {
+		return errors.New("Invalid authorization token audience")
+	}
 
 	return nil
 }
+
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+func getBearerToken(r *http.Request) (string, error) {
+	authorizationHeader := r.Header.Get("authorization")
+	if authorizationHeader == "" {
+		return "", errors.New("An authorization header is required")
+	}
+
+	bearerToken := strings.Split(authorizationHeader, " ")
+	if len(bearerToken) != 2 {
+		return "", errors.New("Cannot parse authorization header")
+	}
+
+	return bearerToken[1], nil
+}
+
+func getClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func formatCredentialID(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
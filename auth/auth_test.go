@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/throttle"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type fakeCredentialDB struct {
+	db.Manager
+	credentials map[string]*entities.Credential
+}
+
+func (f *fakeCredentialDB) GetCredentialByUsername(username string) (*entities.Credential, error) {
+	credential, ok := f.credentials[username]
+	if !ok {
+		return nil, errors.New("Credential does not exist")
+	}
+	return credential, nil
+}
+
+func (f *fakeCredentialDB) CreateRefreshToken(refreshToken *entities.RefreshToken) error {
+	return nil
+}
+
+func (f *fakeCredentialDB) IsTokenRevoked(jti string) bool {
+	return false
+}
+
+func Test_authHandler_Authenticate(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("minimart123"), bcrypt.MinCost)
+	credential := &entities.Credential{Username: "juan", PasswordHash: string(hash), Role: RoleCustomer}
+	credential.ID = 7
+	fakeDB := &fakeCredentialDB{credentials: map[string]*entities.Credential{"juan": credential}}
+
+	config := throttle.Config{MaxAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}
+	a := &authHandler{
+		dbManager:    fakeDB,
+		keySet:       NewHMACKeySet("notSoSecret"),
+		userThrottle: throttle.NewManager(throttle.NewMemoryStore(), config),
+		ipThrottle:   throttle.NewManager(throttle.NewMemoryStore(), config),
+		dummyHash:    hash,
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  error
+	}{
+		{name: "Password matches the bcrypt hash", username: "juan", password: "minimart123"},
+		{name: "Username in another case", username: " Juan", password: "minimart123"},
+		{name: "Wrong password", username: "juan", password: "minimart124", wantErr: ErrInvalidCredentials},
+		{name: "Password hash is not a password", username: "juan", password: string(hash), wantErr: ErrInvalidCredentials},
+		{name: "Unknown username", username: "pedro", password: "minimart123", wantErr: ErrInvalidCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/authenticate", bytes.NewBufferString(`{"username":"`+tt.username+`","password":"`+tt.password+`"}`))
+
+			got, err := a.Authenticate(r)
+			if err != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			r = httptest.NewRequest("GET", "/api/carts", nil)
+			r.Header.Set("Authorization", "Bearer "+got.AccessToken)
+			r, err = a.ValidateRequest(r)
+			if err != nil {
+				t.Fatalf("ValidateRequest() error = %v", err)
+			}
+
+			if principal, _ := PrincipalFromRequest(r); principal.Subject != "7" {
+				t.Errorf("Authenticate() token subject = %v, want the credential id", principal.Subject)
+			}
+		})
+	}
+}
+
+func Test_authHandler_Authenticate_lockout(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("minimart123"), bcrypt.MinCost)
+	fakeDB := &fakeCredentialDB{credentials: map[string]*entities.Credential{
+		"juan": {Username: "juan", PasswordHash: string(hash), Role: RoleCustomer},
+	}}
+
+	store := throttle.NewMemoryStore()
+	config := throttle.Config{MaxAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}
+	a := &authHandler{
+		dbManager:    fakeDB,
+		keySet:       NewHMACKeySet("notSoSecret"),
+		userThrottle: throttle.NewManager(store, config),
+		ipThrottle:   throttle.NewManager(store, throttle.Config{MaxAttempts: 4, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}),
+		dummyHash:    hash,
+	}
+
+	authenticate := func(username, password, remoteAddr string) error {
+		r := httptest.NewRequest("POST", "/api/authenticate", bytes.NewBufferString(`{"username":"`+username+`","password":"`+password+`"}`))
+		r.RemoteAddr = remoteAddr
+		_, err := a.Authenticate(r)
+		return err
+	}
+
+	tests := []struct {
+		name       string
+		username   string
+		password   string
+		remoteAddr string
+		wantErr    error
+		wantLocked bool
+	}{
+		{name: "Correct password", username: "juan", password: "minimart123", remoteAddr: "10.0.0.1:5000"},
+		{name: "First failure", username: "juan", password: "wrong", remoteAddr: "10.0.0.1:5000", wantErr: ErrInvalidCredentials},
+		{name: "Second failure", username: "juan", password: "wrong", remoteAddr: "10.0.0.2:5000", wantErr: ErrInvalidCredentials},
+		{name: "Username is locked even with the correct password", username: "juan", password: "minimart123", remoteAddr: "10.0.0.3:5000", wantLocked: true},
+		{name: "Unknown username counts against the address", username: "pedro", password: "wrong", remoteAddr: "10.0.0.9:5000", wantErr: ErrInvalidCredentials},
+		{name: "Another unknown username", username: "maria", password: "wrong", remoteAddr: "10.0.0.9:5000", wantErr: ErrInvalidCredentials},
+		{name: "Third failure from the address", username: "jose", password: "wrong", remoteAddr: "10.0.0.9:5000", wantErr: ErrInvalidCredentials},
+		{name: "Fourth failure from the address", username: "ana", password: "wrong", remoteAddr: "10.0.0.9:5000", wantErr: ErrInvalidCredentials},
+		{name: "Address is locked", username: "luis", password: "wrong", remoteAddr: "10.0.0.9:6000", wantLocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := authenticate(tt.username, tt.password, tt.remoteAddr)
+
+			var lockedErr *LockedError
+			if tt.wantLocked {
+				if !errors.As(err, &lockedErr) || lockedErr.RetryAfterSeconds() != 60 {
+					t.Errorf("Authenticate() error = %v, want lockout of 60 seconds", err)
+				}
+				return
+			}
+
+			if err != tt.wantErr {
+				t.Errorf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authHandler_Authenticate_parallel(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("minimart123"), bcrypt.MinCost)
+	fakeDB := &fakeCredentialDB{credentials: map[string]*entities.Credential{
+		"juan": {Username: "juan", PasswordHash: string(hash), Role: RoleCustomer},
+	}}
+
+	store := throttle.NewMemoryStore()
+	a := &authHandler{
+		dbManager:    fakeDB,
+		keySet:       NewHMACKeySet("notSoSecret"),
+		userThrottle: throttle.NewManager(store, throttle.Config{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}),
+		ipThrottle:   throttle.NewManager(store, throttle.Config{MaxAttempts: 100, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour}),
+		dummyHash:    hash,
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 30)
+	for i := 0; i < 30; i++ {
+		// The username counter is shared regardless of case
+		username := "juan"
+		if i%2 == 0 {
+			username = "JUAN"
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.Authenticate(httptest.NewRequest("POST", "/api/authenticate", bytes.NewBufferString(`{"username":"`+username+`","password":"wrong"}`)))
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	guesses := 0
+	for err := range errs {
+		if err == ErrInvalidCredentials {
+			guesses++
+		}
+	}
+
+	if guesses != 3 {
+		t.Errorf("Authenticate() checked %v parallel guesses, want 3", guesses)
+	}
+}
+
+func Test_authHandler_ValidateRequest(t *testing.T) {
+	a := &authHandler{dbManager: &fakeCredentialDB{}, keySet: NewHMACKeySet("notSoSecret")}
+	credential := &entities.Credential{Role: RoleStaff}
+	credential.ID = 7
+
+	sign := func(keySet *KeySet, change func(claims *Claims)) string {
+		claims, err := a.newClaims(credential)
+		if err != nil {
+			t.Fatalf("newClaims() error = %v", err)
+		}
+		change(claims)
+
+		token, err := keySet.sign(claims)
+		if err != nil {
+			t.Fatalf("sign() error = %v", err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantRole string
+		wantErr  bool
+	}{
+		{
+			name:     "Issued token",
+			token:    sign(a.keySet, func(claims *Claims) {}),
+			wantRole: RoleStaff,
+		},
+		{
+			name:    "Wrong issuer",
+			token:   sign(a.keySet, func(claims *Claims) { claims.Issuer = "https://evil.example.com" }),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong audience",
+			token:   sign(a.keySet, func(claims *Claims) { claims.Audience = "account" }),
+			wantErr: true,
+		},
+		{
+			name:    "Expired token",
+			token:   sign(a.keySet, func(claims *Claims) { claims.ExpiresAt = time.Now().Add(-time.Minute).Unix() }),
+			wantErr: true,
+		},
+		{
+			name:    "Token without expiry",
+			token:   sign(a.keySet, func(claims *Claims) { claims.ExpiresAt = 0 }),
+			wantErr: true,
+		},
+		{
+			name:    "Token not valid yet",
+			token:   sign(a.keySet, func(claims *Claims) { claims.NotBefore = time.Now().Add(time.Minute).Unix() }),
+			wantErr: true,
+		},
+		{
+			name:    "Token without jti",
+			token:   sign(a.keySet, func(claims *Claims) { claims.Id = "" }),
+			wantErr: true,
+		},
+		{
+			name:    "Token without subject",
+			token:   sign(a.keySet, func(claims *Claims) { claims.Subject = "" }),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown role",
+			token:   sign(a.keySet, func(claims *Claims) { claims.Role = "root" }),
+			wantErr: true,
+		},
+		{
+			name:    "Signed with another secret",
+			token:   sign(NewHMACKeySet("otherSecret"), func(claims *Claims) {}),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/carts", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			r, err := a.ValidateRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			principal, err := PrincipalFromRequest(r)
+			if err != nil {
+				t.Fatalf("PrincipalFromRequest() error = %v", err)
+			}
+
+			if principal.Subject != "7" || principal.Role != tt.wantRole {
+				t.Errorf("ValidateRequest() principal = %+v, want subject 7 with role %v", principal, tt.wantRole)
+			}
+		})
+	}
+}
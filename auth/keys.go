@@ -0,0 +1,229 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// KeySet signs the tokens issued by this API and verifies them. An asymmetric
+// key set keeps the public keys of previous signing keys so tokens issued
+// before a rotation stay valid until they expire.
+type KeySet struct {
+	signingMethod    jwt.SigningMethod
+	signingKey       interface{}
+	signingKeyID     string
+	verificationKeys map[string]crypto.PublicKey
+}
+
+// NewHMACKeySet signs and verifies tokens with a shared HS256 secret
+func NewHMACKeySet(secret string) *KeySet {
+	return &KeySet{
+		signingMethod: jwt.SigningMethodHS256,
+		signingKey:    []byte(secret),
+	}
+}
+
+// LoadKeySet reads a RSA or Ed25519 private key from signingKeyFile and the
+// comma separated public key files of verificationKeyFiles. Each verification
+// entry is a path or "kid=path", when no kid is given it is derived from the key.
+func LoadKeySet(signingKeyFile, signingKeyID, verificationKeyFiles string) (*KeySet, error) {
+	bytesData, err := ioutil.ReadFile(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read token signing key due to: %v", err)
+	}
+
+	signingKey, err := parsePrivateKey(bytesData)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{verificationKeys: make(map[string]crypto.PublicKey)}
+
+	var publicKey crypto.PublicKey
+	switch key := signingKey.(type) {
+	case *rsa.PrivateKey:
+		keySet.signingMethod = jwt.SigningMethodRS256
+		publicKey = &key.PublicKey
+	case ed25519.PrivateKey:
+		keySet.signingMethod = SigningMethodEd25519
+		publicKey = key.Public()
+	default:
+		return nil, errors.New("Token signing key must be a RSA or Ed25519 key")
+	}
+
+	keySet.signingKey = signingKey
+	keySet.signingKeyID = signingKeyID
+	if keySet.signingKeyID == "" {
+		if keySet.signingKeyID, err = deriveKeyID(publicKey); err != nil {
+			return nil, err
+		}
+	}
+	keySet.verificationKeys[keySet.signingKeyID] = publicKey
+
+	for _, entry := range strings.Split(verificationKeyFiles, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+
+		if err := keySet.addVerificationKey(entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return keySet, nil
+}
+
+// JWKS returns the public verification keys, it is empty for a HMAC key set
+func (k *KeySet) JWKS() *JSONWebKeySet {
+	keySet := &JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	for kid, publicKey := range k.verificationKeys {
+		jwk, err := NewJSONWebKey(kid, publicKey)
+		if err != nil {
+			continue
+		}
+
+		keySet.Keys = append(keySet.Keys, *jwk)
+	}
+
+	sort.Slice(keySet.Keys, func(i, j int) bool {
+		return keySet.Keys[i].Kid < keySet.Keys[j].Kid
+	})
+
+	return keySet
+}
+
+func (k *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signingMethod, claims)
+	if k.signingKeyID != "" {
+		token.Header["kid"] = k.signingKeyID
+	}
+
+	return token.SignedString(k.signingKey)
+}
+
+// verificationKey is the jwt.Keyfunc for tokens issued by this API
+func (k *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	if k.verificationKeys == nil {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Cannot parse authorization header")
+		}
+		return k.signingKey, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	publicKey, ok := k.verificationKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key:%v", kid)
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if token.Method == jwt.SigningMethodRS256 {
+			return key, nil
+		}
+	case ed25519.PublicKey:
+		if token.Method == SigningMethodEd25519 {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Signing method:%v does not match key:%v", token.Header["alg"], kid)
+}
+
+func (k *KeySet) addVerificationKey(entry string) error {
+	var kid string
+	path := entry
+	if parts := strings.SplitN(entry, "=", 2); len(parts) == 2 {
+		kid, path = parts[0], parts[1]
+	}
+
+	bytesData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Unable to read token verification key due to: %v", err)
+	}
+
+	publicKey, err := parsePublicKey(bytesData)
+	if err != nil {
+		return err
+	}
+
+	if kid == "" {
+		if kid, err = deriveKeyID(publicKey); err != nil {
+			return err
+		}
+	}
+
+	k.verificationKeys[kid] = publicKey
+
+	return nil
+}
+
+func parsePrivateKey(bytesData []byte) (interface{}, error) {
+	block, _ := pem.Decode(bytesData)
+	if block == nil {
+		return nil, errors.New("Token signing key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+
+	return nil, fmt.Errorf("Unsupported token signing key type:%v", block.Type)
+}
+
+func parsePublicKey(bytesData []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(bytesData)
+	if block == nil {
+		return nil, errors.New("Token verification key is not PEM encoded")
+	}
+
+	var publicKey crypto.PublicKey
+	var err error
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported token verification key type:%v", block.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	}
+
+	return nil, errors.New("Token verification key must be a RSA or Ed25519 key")
+}
+
+// deriveKeyID hashes the DER encoded public key so the same key always gets the same kid
+func deriveKeyID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(der)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:12]), nil
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func testClaims() *Claims {
+	now := time.Now()
+
+	return &Claims{
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "1",
+			ExpiresAt: now.Add(time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+			Id:        "jti",
+		},
+		Role: RoleCustomer,
+	}
+}
+
+func TestKeySet_rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldRSAKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPublicDER, _ := x509.MarshalPKIXPublicKey(&oldRSAKey.PublicKey)
+
+	oldPrivatePath := writePEM(t, dir, "old.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(oldRSAKey))
+	oldPublicPath := writePEM(t, dir, "old.pub.pem", "PUBLIC KEY", oldPublicDER)
+
+	_, newEdKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPrivateDER, _ := x509.MarshalPKCS8PrivateKey(newEdKey)
+	newPrivatePath := writePEM(t, dir, "new.pem", "PRIVATE KEY", newPrivateDER)
+
+	oldKeySet, err := LoadKeySet(oldPrivatePath, "", "")
+	if err != nil {
+		t.Fatalf("LoadKeySet() old error = %v", err)
+	}
+
+	newKeySet, err := LoadKeySet(newPrivatePath, "2019-12", oldPublicPath)
+	if err != nil {
+		t.Fatalf("LoadKeySet() new error = %v", err)
+	}
+
+	oldToken, err := oldKeySet.sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newToken, err := newKeySet.sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hmacToken, err := NewHMACKeySet("notSoSecret").sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keySet  *KeySet
+		token   string
+		wantErr bool
+	}{
+		{
+			name:   "RS256 token verified by its own key set",
+			keySet: oldKeySet,
+			token:  oldToken,
+		},
+		{
+			name:   "EdDSA token verified by its own key set",
+			keySet: newKeySet,
+			token:  newToken,
+		},
+		{
+			name:   "Token of rotated out key is still accepted",
+			keySet: newKeySet,
+			token:  oldToken,
+		},
+		{
+			name:    "Token of a newer key is unknown to the old key set",
+			keySet:  oldKeySet,
+			token:   newToken,
+			wantErr: true,
+		},
+		{
+			name:    "HMAC token is rejected by an asymmetric key set",
+			keySet:  newKeySet,
+			token:   hmacToken,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := jwt.ParseWithClaims(tt.token, &Claims{}, tt.keySet.verificationKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseWithClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	jwks := newKeySet.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS() has %v keys, want 2", len(jwks.Keys))
+	}
+
+	for kid, publicKey := range jwks.PublicKeys() {
+		if _, ok := newKeySet.verificationKeys[kid]; !ok {
+			t.Errorf("JWKS() has unknown kid:%v", kid)
+		}
+		if _, err := NewJSONWebKey(kid, publicKey); err != nil {
+			t.Errorf("JWKS() key %v does not round trip: %v", kid, err)
+		}
+	}
+
+	if _, ok := jwks.PublicKeys()["2019-12"]; !ok {
+		t.Errorf("JWKS() is missing the configured signing kid")
+	}
+}
+
+func TestKeySet_HMAC(t *testing.T) {
+	keySet := NewHMACKeySet("notSoSecret")
+
+	token, err := keySet.sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := jwt.ParseWithClaims(token, &Claims{}, keySet.verificationKey); err != nil {
+		t.Errorf("ParseWithClaims() error = %v", err)
+	}
+
+	if _, err := jwt.ParseWithClaims(token, &Claims{}, NewHMACKeySet("otherSecret").verificationKey); err == nil {
+		t.Errorf("ParseWithClaims() accepted a token signed with another secret")
+	}
+
+	if keys := keySet.JWKS().Keys; len(keys) != 0 {
+		t.Errorf("JWKS() = %v, want no keys", keys)
+	}
+}
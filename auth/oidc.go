@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emanpicar/minimart-api/logger"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type (
+	// OIDCConfig configures validation of tokens issued by an OpenID Connect provider such as Keycloak
+	OIDCConfig struct {
+		// IssuerURL must match the iss claim, it is also used to discover the JWKS when JWKSFile is empty
+		IssuerURL string
+		// JWKSFile is a local JWKS document used instead of fetching one from the issuer
+		JWKSFile string
+		// Audience must be in the aud claim or be the authorized party (azp)
+		Audience string
+		// RoleMapping maps provider realm roles to our roles, unmapped realm roles are ignored
+		RoleMapping map[string]string
+	}
+
+	oidcHandler struct {
+		config     OIDCConfig
+		httpClient *http.Client
+
+		mu        sync.RWMutex
+		keys      map[string]crypto.PublicKey
+		fetchedAt time.Time
+	}
+
+	oidcDiscovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+)
+
+const (
+	// jwksMaxAge is how long fetched keys are trusted before fetching them again
+	jwksMaxAge = time.Hour
+	// jwksMinRefresh limits refetching when tokens with unknown kid are presented
+	jwksMinRefresh = time.Minute
+)
+
+// ErrNotSupported is returned by operations the identity provider is responsible for
+var ErrNotSupported = errors.New("Not supported when tokens are issued by the identity provider")
+
+// rolePriority orders roles so the most privileged mapped realm role wins
+var rolePriority = map[string]int{RoleCustomer: 1, RoleStaff: 2, RoleAdmin: 3}
+
+func NewOIDCManager(config OIDCConfig) Manager {
+	if config.RoleMapping == nil {
+		config.RoleMapping = map[string]string{
+			RoleCustomer: RoleCustomer,
+			RoleStaff:    RoleStaff,
+			RoleAdmin:    RoleAdmin,
+		}
+	}
+
+	return &oidcHandler{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ParseRoleMapping parses "realmRole=role" pairs separated by commas
+func ParseRoleMapping(value string) (map[string]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !IsValidRole(parts[1]) {
+			return nil, fmt.Errorf("Invalid role mapping:%v", pair)
+		}
+
+		mapping[parts[0]] = parts[1]
+	}
+
+	return mapping, nil
+}
+
+func (o *oidcHandler) Authenticate(r *http.Request) (*TokenPair, error) {
+	return nil, ErrNotSupported
+}
+
+func (o *oidcHandler) Refresh(body io.ReadCloser) (*TokenPair, error) {
+	return nil, ErrNotSupported
+}
+
+func (o *oidcHandler) Logout(r *http.Request) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (o *oidcHandler) CreateAPIKey(r *http.Request) (*CreatedAPIKey, error) {
+	return nil, ErrNotSupported
+}
+
+func (o *oidcHandler) GetAllAPIKeys() *[]APIKeyInfo {
+	return &[]APIKeyInfo{}
+}
+
+func (o *oidcHandler) RevokeAPIKey(keyID string) (string, error) {
+	return "", ErrNotSupported
+}
+
+// JWKS is empty since this API does not sign tokens in this mode
+func (o *oidcHandler) JWKS() *JSONWebKeySet {
+	return &JSONWebKeySet{Keys: []JSONWebKey{}}
+}
+
+func (o *oidcHandler) ValidateRequest(r *http.Request) (*http.Request, error) {
+	bearerToken, err := getBearerToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse(bearerToken, o.getVerificationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("Invalid authorization token")
+	}
+
+	claims, err := o.verifyClaims(mapClaims)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.WithContext(WithPrincipal(r.Context(), newPrincipal(claims))), nil
+}
+
+func (o *oidcHandler) getVerificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	publicKey, err := o.getPublicKey(kid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch token.Method.(type) {
+	case *jwt.SigningMethodRSA:
+		if rsaKey, ok := publicKey.(*rsa.PublicKey); ok {
+			return rsaKey, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if ecdsaKey, ok := publicKey.(*ecdsa.PublicKey); ok {
+			return ecdsaKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Signing method:%v does not match key:%v", token.Header["alg"], kid)
+}
+
+func (o *oidcHandler) getPublicKey(kid string) (crypto.PublicKey, error) {
+	o.mu.RLock()
+	publicKey, ok := o.keys[kid]
+	fetchedAt := o.fetchedAt
+	o.mu.RUnlock()
+
+	if ok && time.Since(fetchedAt) < jwksMaxAge {
+		return publicKey, nil
+	}
+
+	if !ok && !fetchedAt.IsZero() && time.Since(fetchedAt) < jwksMinRefresh {
+		return nil, fmt.Errorf("Unknown signing key:%v", kid)
+	}
+
+	if err := o.loadKeys(); err != nil {
+		if ok {
+			logger.Log.Warnf("Using cached signing key:%v after refresh failed due to: %v", kid, err)
+			return publicKey, nil
+		}
+		return nil, err
+	}
+
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+
+	if publicKey, ok = o.keys[kid]; !ok {
+		return nil, fmt.Errorf("Unknown signing key:%v", kid)
+	}
+
+	return publicKey, nil
+}
+
+func (o *oidcHandler) loadKeys() error {
+	keySet, err := o.readKeySet()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+
+	o.keys = keySet.PublicKeys()
+
+	return nil
+}
+
+func (o *oidcHandler) readKeySet() (*JSONWebKeySet, error) {
+	var keySet JSONWebKeySet
+
+	if o.config.JWKSFile != "" {
+		bytesData, err := ioutil.ReadFile(o.config.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read JWKS file due to: %v", err)
+		}
+
+		if err := json.Unmarshal(bytesData, &keySet); err != nil {
+			return nil, fmt.Errorf("Unable to parse JWKS file due to: %v", err)
+		}
+
+		return &keySet, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := o.getJSON(strings.TrimSuffix(o.config.IssuerURL, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, err
+	}
+
+	if discovery.JWKSURI == "" {
+		return nil, errors.New("OpenID configuration does not contain a jwks_uri")
+	}
+
+	if err := o.getJSON(discovery.JWKSURI, &keySet); err != nil {
+		return nil, err
+	}
+
+	return &keySet, nil
+}
+
+func (o *oidcHandler) getJSON(url string, target interface{}) error {
+	response, err := o.httpClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("Unable to fetch %v due to: %v", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unable to fetch %v, status:%v", url, response.StatusCode)
+	}
+
+	if err := json.NewDecoder(response.Body).Decode(target); err != nil {
+		return fmt.Errorf("Unable to parse %v due to: %v", url, err)
+	}
+
+	return nil
+}
+
+func (o *oidcHandler) verifyClaims(mapClaims jwt.MapClaims) (*Claims, error) {
+	if !mapClaims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, errors.New("Authorization token is expired")
+	}
+
+	if !mapClaims.VerifyIssuer(o.config.IssuerURL, true) {
+		return nil, errors.New("Invalid authorization token issuer")
+	}
+
+	if !o.verifyAudience(mapClaims) {
+		return nil, errors.New("Invalid authorization token audience")
+	}
+
+	subject, _ := mapClaims["sub"].(string)
+	if subject == "" {
+		return nil, errors.New("Invalid authorization token")
+	}
+
+	claims := &Claims{Role: o.mapRole(mapClaims)}
+	claims.Subject = subject
+	claims.Issuer = o.config.IssuerURL
+	claims.Audience = o.config.Audience
+	claims.Id, _ = mapClaims["jti"].(string)
+	if exp, ok := mapClaims["exp"].(float64); ok {
+		claims.ExpiresAt = int64(exp)
+	}
+
+	return claims, nil
+}
+
+// verifyAudience accepts aud as a string or an array, Keycloak access tokens
+// often only name the client in azp
+func (o *oidcHandler) verifyAudience(mapClaims jwt.MapClaims) bool {
+	if azp, _ := mapClaims["azp"].(string); azp == o.config.Audience {
+		return true
+	}
+
+	switch aud := mapClaims["aud"].(type) {
+	case string:
+		return aud == o.config.Audience
+	case []interface{}:
+		for _, value := range aud {
+			if value == o.config.Audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (o *oidcHandler) mapRole(mapClaims jwt.MapClaims) string {
+	role := RoleCustomer
+
+	realmAccess, _ := mapClaims["realm_access"].(map[string]interface{})
+	realmRoles, _ := realmAccess["roles"].([]interface{})
+
+	for _, realmRole := range realmRoles {
+		name, _ := realmRole.(string)
+		if mapped, ok := o.config.RoleMapping[name]; ok && rolePriority[mapped] > rolePriority[role] {
+			role = mapped
+		}
+	}
+
+	return role
+}
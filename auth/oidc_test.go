@@ -0,0 +1,303 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+type oidcStub struct {
+	server     *httptest.Server
+	rsaKey     *rsa.PrivateKey
+	ecdsaKey   *ecdsa.PrivateKey
+	keySet     JSONWebKeySet
+	jwksServed int
+}
+
+func newOIDCStub(t *testing.T) *oidcStub {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stub := &oidcStub{rsaKey: rsaKey, ecdsaKey: ecdsaKey}
+	stub.keySet = JSONWebKeySet{Keys: []JSONWebKey{
+		{
+			Kty: "RSA",
+			Kid: "rsa-key",
+			Use: "sig",
+			N:   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			Kty: "EC",
+			Kid: "ec-key",
+			Use: "sig",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(ecdsaKey.X.Bytes()),
+			Y:   base64.RawURLEncoding.EncodeToString(ecdsaKey.Y.Bytes()),
+		},
+	}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/realms/minimart/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{JWKSURI: stub.server.URL + "/realms/minimart/protocol/openid-connect/certs"})
+	})
+	mux.HandleFunc("/realms/minimart/protocol/openid-connect/certs", func(w http.ResponseWriter, r *http.Request) {
+		stub.jwksServed++
+		json.NewEncoder(w).Encode(stub.keySet)
+	})
+	stub.server = httptest.NewServer(mux)
+
+	return stub
+}
+
+func (s *oidcStub) issuer() string {
+	return s.server.URL + "/realms/minimart"
+}
+
+func (s *oidcStub) claims() jwt.MapClaims {
+	now := time.Now()
+
+	return jwt.MapClaims{
+		"sub":          "6f1c1c0e-6a3b-4b8e-9b43-5a5c1f3e2d11",
+		"iss":          s.issuer(),
+		"aud":          []interface{}{"account", "minimart-api"},
+		"azp":          "minimart-web",
+		"exp":          now.Add(5 * time.Minute).Unix(),
+		"iat":          now.Unix(),
+		"jti":          "3c8b0e6e",
+		"realm_access": map[string]interface{}{"roles": []interface{}{"offline_access", "minimart-staff"}},
+	}
+}
+
+func (s *oidcStub) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	var key interface{}
+	switch method.(type) {
+	case *jwt.SigningMethodRSA:
+		key = s.rsaKey
+	case *jwt.SigningMethodECDSA:
+		key = s.ecdsaKey
+	case *jwt.SigningMethodHMAC:
+		key = []byte("notSoSecret")
+	}
+
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokenString
+}
+
+func Test_oidcHandler_ValidateRequest(t *testing.T) {
+	stub := newOIDCStub(t)
+	defer stub.server.Close()
+
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := stub.claims()
+		claims[name] = value
+		return claims
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		wantRole string
+		wantErr  bool
+	}{
+		{
+			name:     "RS256 token with mapped realm role",
+			token:    stub.sign(t, jwt.SigningMethodRS256, "rsa-key", stub.claims()),
+			wantRole: RoleStaff,
+		},
+		{
+			name:     "ES256 token without mapped realm role",
+			token:    stub.sign(t, jwt.SigningMethodES256, "ec-key", withClaim("realm_access", map[string]interface{}{"roles": []interface{}{"offline_access"}})),
+			wantRole: RoleCustomer,
+		},
+		{
+			name:     "Audience given as authorized party",
+			token:    stub.sign(t, jwt.SigningMethodRS256, "rsa-key", withClaim("azp", "minimart-api")),
+			wantRole: RoleStaff,
+		},
+		{
+			name:    "Wrong audience",
+			token:   stub.sign(t, jwt.SigningMethodRS256, "rsa-key", withClaim("aud", "account")),
+			wantErr: true,
+		},
+		{
+			name:    "Wrong issuer",
+			token:   stub.sign(t, jwt.SigningMethodRS256, "rsa-key", withClaim("iss", "https://evil.example.com/realms/minimart")),
+			wantErr: true,
+		},
+		{
+			name:    "Expired token",
+			token:   stub.sign(t, jwt.SigningMethodRS256, "rsa-key", withClaim("exp", time.Now().Add(-time.Minute).Unix())),
+			wantErr: true,
+		},
+		{
+			name:    "Unknown kid",
+			token:   stub.sign(t, jwt.SigningMethodRS256, "rotated-away", stub.claims()),
+			wantErr: true,
+		},
+		{
+			name:    "Algorithm does not match key type",
+			token:   stub.sign(t, jwt.SigningMethodES256, "rsa-key", stub.claims()),
+			wantErr: true,
+		},
+		{
+			name:    "HMAC tokens are rejected",
+			token:   stub.sign(t, jwt.SigningMethodHS256, "rsa-key", stub.claims()),
+			wantErr: true,
+		},
+	}
+
+	o := NewOIDCManager(OIDCConfig{
+		IssuerURL:   stub.issuer(),
+		Audience:    "minimart-api",
+		RoleMapping: map[string]string{"minimart-staff": RoleStaff, "minimart-admin": RoleAdmin},
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/carts", nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+
+			r, err := o.ValidateRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRequest() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+
+			principal, err := PrincipalFromRequest(r)
+			if err != nil {
+				t.Fatalf("PrincipalFromRequest() error = %v", err)
+			}
+			if principal.Role != tt.wantRole {
+				t.Errorf("ValidateRequest() role = %v, want %v", principal.Role, tt.wantRole)
+			}
+			if principal.Subject != "6f1c1c0e-6a3b-4b8e-9b43-5a5c1f3e2d11" {
+				t.Errorf("ValidateRequest() subject = %v", principal.Subject)
+			}
+		})
+	}
+
+	if stub.jwksServed != 1 {
+		t.Errorf("JWKS fetched %v times, want 1", stub.jwksServed)
+	}
+}
+
+func Test_oidcHandler_ValidateRequest_JWKSFile(t *testing.T) {
+	stub := newOIDCStub(t)
+	stub.server.Close()
+
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jwksFile := filepath.Join(dir, "jwks.json")
+	bytesData, _ := json.Marshal(stub.keySet)
+	if err := ioutil.WriteFile(jwksFile, bytesData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOIDCManager(OIDCConfig{
+		IssuerURL: stub.issuer(),
+		JWKSFile:  jwksFile,
+		Audience:  "minimart-api",
+	})
+
+	claims := stub.claims()
+	claims["realm_access"] = map[string]interface{}{"roles": []interface{}{"admin"}}
+
+	r := httptest.NewRequest("GET", "/api/carts", nil)
+	r.Header.Set("Authorization", "Bearer "+stub.sign(t, jwt.SigningMethodES256, "ec-key", claims))
+
+	r, err = o.ValidateRequest(r)
+	if err != nil {
+		t.Fatalf("ValidateRequest() error = %v", err)
+	}
+
+	principal, err := PrincipalFromRequest(r)
+	if err != nil {
+		t.Fatalf("PrincipalFromRequest() error = %v", err)
+	}
+
+	if principal.Role != RoleAdmin {
+		t.Errorf("ValidateRequest() role = %v, want %v", principal.Role, RoleAdmin)
+	}
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "Empty uses default mapping",
+			value: "",
+			want:  nil,
+		},
+		{
+			name:  "Pairs",
+			value: "minimart-admin=admin, minimart-staff=store-staff",
+			want:  map[string]string{"minimart-admin": RoleAdmin, "minimart-staff": RoleStaff},
+		},
+		{
+			name:    "Unknown role",
+			value:   "minimart-admin=superuser",
+			wantErr: true,
+		},
+		{
+			name:    "Missing separator",
+			value:   "minimart-admin",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRoleMapping(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRoleMapping() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("ParseRoleMapping() = %v, want %v", got, tt.want)
+			}
+			for realmRole, role := range tt.want {
+				if got[realmRole] != role {
+					t.Errorf("ParseRoleMapping() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
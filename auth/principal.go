@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Principal is the authenticated caller of a request, it is stored on the
+// request context by ValidateRequest
+type Principal struct {
+	// Subject is the credential ID, the identity provider subject or "apikey:<id>"
+	Subject string
+	Role    string
+	// TokenID and ExpiresAt identify the access token, they are empty for API keys
+	TokenID   string
+	ExpiresAt time.Time
+	// Scopes and APIKey are only set when the request was authenticated by an API key
+	Scopes []string
+	APIKey bool
+}
+
+type principalContextKey struct{}
+
+// ErrNoPrincipal is returned when a request did not pass through ValidateRequest
+var ErrNoPrincipal = errors.New("Request is not authenticated")
+
+// WithPrincipal returns a copy of ctx carrying principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by WithPrincipal
+func PrincipalFromContext(ctx context.Context) (*Principal, error) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	if !ok || principal == nil {
+		return nil, ErrNoPrincipal
+	}
+
+	return principal, nil
+}
+
+// PrincipalFromRequest returns the principal of an authenticated request
+func PrincipalFromRequest(r *http.Request) (*Principal, error) {
+	return PrincipalFromContext(r.Context())
+}
+
+// HasRole reports whether the principal has one of roles
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasScope reports whether the principal may use scope. Scopes only restrict
+// API keys, user tokens are limited by their role instead.
+func (p *Principal) HasScope(scope string) bool {
+	if !p.APIKey {
+		return true
+	}
+
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newPrincipal(claims *Claims) *Principal {
+	principal := &Principal{
+		Subject: claims.Subject,
+		Role:    claims.Role,
+		TokenID: claims.Id,
+	}
+
+	if claims.ExpiresAt != 0 {
+		principal.ExpiresAt = time.Unix(claims.ExpiresAt, 0)
+	}
+
+	return principal
+}
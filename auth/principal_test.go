@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPrincipalFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/api/carts", nil)
+
+	if _, err := PrincipalFromRequest(r); !errors.Is(err, ErrNoPrincipal) {
+		t.Errorf("PrincipalFromRequest() error = %v, want %v", err, ErrNoPrincipal)
+	}
+
+	want := &Principal{Subject: "1", Role: RoleStaff}
+	r = r.WithContext(WithPrincipal(r.Context(), want))
+
+	got, err := PrincipalFromRequest(r)
+	if err != nil {
+		t.Fatalf("PrincipalFromRequest() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("PrincipalFromRequest() = %+v, want %+v", got, want)
+	}
+	if !got.HasRole(RoleAdmin, RoleStaff) || got.HasRole(RoleAdmin) {
+		t.Errorf("HasRole() does not match role %v", got.Role)
+	}
+}
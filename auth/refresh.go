@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/settings"
+)
+
+type (
+	TokenPair struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		// Subject is the subject of the access token
+		Subject string `json:"-"`
+	}
+
+	RefreshReqBody struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+)
+
+// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or already used
+var ErrInvalidRefreshToken = errors.New("Invalid refresh token")
+
+// Refresh exchanges a refresh token for a new token pair. Refresh tokens are
+// single use, presenting one that was already rotated revokes its whole family
+// since it means the token was copied by someone else.
+func (a *authHandler) Refresh(body io.ReadCloser) (*TokenPair, error) {
+	var reqData RefreshReqBody
+	if err := json.NewDecoder(body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := a.dbManager.GetRefreshTokenByHash(hashSecret(reqData.RefreshToken))
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if refreshToken.RevokedAt != nil {
+		logger.Log.Warnf("Reuse of refresh token family:%v detected, revoking family", refreshToken.FamilyID)
+		a.dbManager.RevokeRefreshTokenFamily(refreshToken.FamilyID)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if time.Now().After(refreshToken.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	revoked, err := a.dbManager.RevokeRefreshToken(refreshToken.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !revoked {
+		// Another request rotated this token first
+		a.dbManager.RevokeRefreshTokenFamily(refreshToken.FamilyID)
+		return nil, ErrInvalidRefreshToken
+	}
+
+	credential, err := a.dbManager.GetCredentialByID(refreshToken.CredentialID)
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return a.issueTokenPair(credential, refreshToken.FamilyID)
+}
+
+// Logout revokes the access token of the request and, when given, the refresh token family in the body
+func (a *authHandler) Logout(r *http.Request) (string, error) {
+	principal, err := PrincipalFromRequest(r)
+	if err != nil {
+		return "", err
+	}
+
+	if principal.APIKey {
+		return "", errors.New("API keys are revoked through /api/apikeys")
+	}
+
+	var reqData RefreshReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if err := a.dbManager.RevokeToken(principal.TokenID, principal.ExpiresAt); err != nil {
+		return "", err
+	}
+
+	if reqData.RefreshToken != "" {
+		refreshToken, err := a.dbManager.GetRefreshTokenByHash(hashSecret(reqData.RefreshToken))
+		if err != nil || principal.Subject != formatCredentialID(refreshToken.CredentialID) {
+			return "", ErrInvalidRefreshToken
+		}
+
+		if err := a.dbManager.RevokeRefreshTokenFamily(refreshToken.FamilyID); err != nil {
+			return "", err
+		}
+	}
+
+	return "Successfully logged out", nil
+}
+
+func (a *authHandler) issueTokenPair(credential *entities.Credential, familyID string) (*TokenPair, error) {
+	accessToken, err := a.signAccessToken(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.dbManager.CreateRefreshToken(&entities.RefreshToken{
+		TokenHash:    hashSecret(refreshToken),
+		FamilyID:     familyID,
+		CredentialID: credential.ID,
+		ExpiresAt:    time.Now().Add(settings.GetRefreshTokenTTL()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(settings.GetTokenTTL().Seconds()),
+		Subject:      formatCredentialID(credential.ID),
+	}, nil
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret hashes refresh tokens and API keys for storage, a fast unsalted sha256 is
+// enough since both are 32 random bytes that can not be guessed like a password
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+type fakeRefreshDB struct {
+	db.Manager
+	credential    *entities.Credential
+	refreshTokens []entities.RefreshToken
+	revokedTokens map[string]bool
+}
+
+func (f *fakeRefreshDB) GetCredentialByID(id uint) (*entities.Credential, error) {
+	if id != f.credential.ID {
+		return nil, errors.New("Credential does not exist")
+	}
+	return f.credential, nil
+}
+
+func (f *fakeRefreshDB) CreateRefreshToken(refreshToken *entities.RefreshToken) error {
+	refreshToken.ID = uint(len(f.refreshTokens) + 1)
+	f.refreshTokens = append(f.refreshTokens, *refreshToken)
+	return nil
+}
+
+func (f *fakeRefreshDB) GetRefreshTokenByHash(tokenHash string) (*entities.RefreshToken, error) {
+	for _, refreshToken := range f.refreshTokens {
+		if refreshToken.TokenHash == tokenHash {
+			return &refreshToken, nil
+		}
+	}
+	return nil, errors.New("Refresh token does not exist")
+}
+
+func (f *fakeRefreshDB) RevokeRefreshToken(id uint) (bool, error) {
+	refreshToken := &f.refreshTokens[id-1]
+	if refreshToken.RevokedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	refreshToken.RevokedAt = &now
+	return true, nil
+}
+
+func (f *fakeRefreshDB) RevokeRefreshTokenFamily(familyID string) error {
+	now := time.Now()
+	for i := range f.refreshTokens {
+		if f.refreshTokens[i].FamilyID == familyID && f.refreshTokens[i].RevokedAt == nil {
+			f.refreshTokens[i].RevokedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeRefreshDB) RevokeToken(jti string, expiresAt time.Time) error {
+	f.revokedTokens[jti] = true
+	return nil
+}
+
+func (f *fakeRefreshDB) IsTokenRevoked(jti string) bool {
+	return f.revokedTokens[jti]
+}
+
+func newRefreshHandler() (*authHandler, *fakeRefreshDB) {
+	credential := &entities.Credential{Username: "juan", Role: RoleCustomer}
+	credential.ID = 1
+	fakeDB := &fakeRefreshDB{credential: credential, revokedTokens: make(map[string]bool)}
+
+	return &authHandler{dbManager: fakeDB, keySet: NewHMACKeySet("notSoSecret")}, fakeDB
+}
+
+func refresh(a *authHandler, refreshToken string) (*TokenPair, error) {
+	return a.Refresh(httptest.NewRequest("POST", "/api/token/refresh", bytes.NewBufferString(`{"refresh_token":"`+refreshToken+`"}`)).Body)
+}
+
+func Test_authHandler_Refresh(t *testing.T) {
+	a, fakeDB := newRefreshHandler()
+
+	first, err := a.issueTokenPair(fakeDB.credential, "family-1")
+	if err != nil {
+		t.Fatalf("issueTokenPair() error = %v", err)
+	}
+	other, err := a.issueTokenPair(fakeDB.credential, "family-2")
+	if err != nil {
+		t.Fatalf("issueTokenPair() error = %v", err)
+	}
+
+	second, err := refresh(a, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Errorf("Refresh() = %+v, want a rotated pair", second)
+	}
+
+	tests := []struct {
+		name         string
+		refreshToken string
+		wantErr      error
+	}{
+		{
+			name:         "Unknown token",
+			refreshToken: "unknown",
+			wantErr:      ErrInvalidRefreshToken,
+		},
+		{
+			name:         "Reuse of a rotated token",
+			refreshToken: first.RefreshToken,
+			wantErr:      ErrInvalidRefreshToken,
+		},
+		{
+			name:         "Rotated token of the reused family is revoked",
+			refreshToken: second.RefreshToken,
+			wantErr:      ErrInvalidRefreshToken,
+		},
+		{
+			name:         "Other logins keep their tokens",
+			refreshToken: other.RefreshToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := refresh(a, tt.refreshToken); err != tt.wantErr {
+				t.Errorf("Refresh() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_authHandler_Logout(t *testing.T) {
+	a, fakeDB := newRefreshHandler()
+
+	pair, err := a.issueTokenPair(fakeDB.credential, "family-1")
+	if err != nil {
+		t.Fatalf("issueTokenPair() error = %v", err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/logout", bytes.NewBufferString(`{"refresh_token":"`+pair.RefreshToken+`"}`))
+	r.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	r, err = a.ValidateRequest(r)
+	if err != nil {
+		t.Fatalf("ValidateRequest() error = %v", err)
+	}
+
+	if _, err := a.Logout(r); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+
+	r = httptest.NewRequest("GET", "/api/carts", nil)
+	r.Header.Set("Authorization", "Bearer "+pair.AccessToken)
+	if _, err := a.ValidateRequest(r); err == nil {
+		t.Errorf("ValidateRequest() of the logged out access token succeeded")
+	}
+
+	if _, err := refresh(a, pair.RefreshToken); err != ErrInvalidRefreshToken {
+		t.Errorf("Refresh() of the logged out refresh token error = %v, want %v", err, ErrInvalidRefreshToken)
+	}
+}
@@ -0,0 +1,129 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+const (
+	OperationAdd    = "add"
+	OperationSet    = "set"
+	OperationRemove = "remove"
+
+	maxBatchOperations = 100
+)
+
+type (
+	// CartOperation changes one product of the cart: add increases its quantity, set
+	// replaces it and remove takes it out of the cart. add and set put a product that
+	// is not in the cart in it, remove of a product that is not in the cart does nothing.
+	CartOperation struct {
+		Op string `json:"op"`
+		CartReqBody
+	}
+
+	CartBatchReqBody struct {
+		Operations []CartOperation `json:"operations"`
+	}
+)
+
+// BatchUpdateCart applies every operation of the request or none of them, the
+// quantities the operations leave in the cart are validated like a single change
+func (c *cartHandler) BatchUpdateCart(r *http.Request) (string, error) {
+	var reqData CartBatchReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return "", err
+	}
+
+	if len(reqData.Operations) == 0 {
+		return "", errors.New("At least one operation is required")
+	}
+
+	if len(reqData.Operations) > maxBatchOperations {
+		return "", fmt.Errorf("At most %v operations can be applied at once", maxBatchOperations)
+	}
+
+	userID, err := c.getUserIDInContext(r)
+	if err != nil {
+		return "", err
+	}
+
+	products := make(map[uint]*entities.ProductCollection)
+	quantities := make([]int, len(reqData.Operations))
+	for i, operation := range reqData.Operations {
+		quantities[i], err = c.prepareOperation(operation, products)
+		if err != nil {
+			return "", fmt.Errorf("Operation %v: %w", i+1, err)
+		}
+	}
+
+	err = c.update(r, userID, func(items []CartItem) ([]CartItem, error) {
+		for i, operation := range reqData.Operations {
+			index := c.findCartItem(items, operation.ID)
+
+			switch {
+			case operation.Op == OperationRemove && index >= 0:
+				items = append(items[:index], items[index+1:]...)
+			case operation.Op == OperationRemove:
+			case index < 0:
+				items = append(items, CartItem{ProductID: operation.ID, Quantity: quantities[i], AddedPrice: c.unitPrice(products[operation.ID])})
+			case operation.Op == OperationAdd:
+				items[index].Quantity += quantities[i]
+				items[index].AddedPrice = c.unitPrice(products[operation.ID])
+			default:
+				items[index].Quantity = quantities[i]
+				items[index].AddedPrice = c.unitPrice(products[operation.ID])
+			}
+		}
+
+		for _, item := range items {
+			if productCol, ok := products[item.ProductID]; ok {
+				if err := c.validateQuantity(productCol, item.Quantity); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		return items, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "Successfully updated cart", nil
+}
+
+// prepareOperation checks operation and returns its quantity, products caches the
+// products of the batch
+func (c *cartHandler) prepareOperation(operation CartOperation, products map[uint]*entities.ProductCollection) (int, error) {
+	switch operation.Op {
+	case OperationRemove:
+		if operation.ID == 0 {
+			return 0, errors.New("Product id is required")
+		}
+		return 0, nil
+	case OperationAdd, OperationSet:
+	default:
+		return 0, fmt.Errorf("Unknown op:%v, use %v, %v or %v", operation.Op, OperationAdd, OperationSet, OperationRemove)
+	}
+
+	productCol, ok := products[operation.ID]
+	if !ok {
+		var err error
+		if productCol, err = c.dbManager.GetProductByID(operation.ID); err != nil {
+			return 0, err
+		}
+		products[operation.ID] = productCol
+	}
+
+	quantity, err := c.parseQuantity(productCol, operation.Quantity, operation.Unit)
+	if err != nil {
+		return 0, err
+	}
+
+	return quantity, c.validateQuantity(productCol, quantity)
+}
@@ -10,59 +10,134 @@ import (
 
 	"github.com/emanpicar/minimart-api/db/entities"
 
+	"github.com/emanpicar/minimart-api/money"
 	"github.com/emanpicar/minimart-api/product"
+	"github.com/emanpicar/minimart-api/promotions"
+	"github.com/emanpicar/minimart-api/settings"
 
 	"github.com/emanpicar/minimart-api/auth"
 	"github.com/emanpicar/minimart-api/db"
-
-	"github.com/gorilla/context"
-	"github.com/mitchellh/mapstructure"
-
-	jwt "github.com/dgrijalva/jwt-go"
-	gocache "github.com/patrickmn/go-cache"
 )
 
 type (
 	Manager interface {
-		GetAllCarts(r *http.Request) *[]CartCollection
+		GetAllCarts(r *http.Request) (*CartSummary, error)
 		AddToCart(r *http.Request) (string, error)
 		UpdateCart(r *http.Request, productID string) (string, error)
 		DeleteCart(r *http.Request, productID string) (string, error)
+		BatchUpdateCart(r *http.Request) (string, error)
+		CreateGuestCart() (*GuestCart, error)
+		MergeGuestCart(cartToken, owner string) error
+		ParseQuantity(productID uint, quantity json.Number, unit string) (int, error)
+		AddItem(owner string, productID uint, quantity int) error
+		RemoveItem(owner string, productID uint) (int, error)
+		Checkout(owner, idempotencyKey string, newOrder func(summary *CartSummary) *entities.Order) (*entities.Order, error)
 	}
 
 	cartHandler struct {
-		cache     *gocache.Cache
-		dbManager db.Manager
+		store            CartStore
+		dbManager        db.Manager
+		promotionManager promotions.Manager
+		now              func() time.Time
+		// taxRate is in basis points
+		taxRate       int64
+		mergeStrategy string
 	}
 
+	// CartCollection is a priced cart line, products sold by weight have their Quantity
+	// in Unit g and their SalesPrice per PricedPer unit. Lines are priced at the current
+	// SalesPrice, PriceChanged flags a SalesPrice other than the AddedPrice the shopper
+	// saw and Availability anything but AvailabilityAvailable.
 	CartCollection struct {
 		product.ProductCollection
-		Quantity int `json:"quantity"`
+		Quantity     int         `json:"quantity"`
+		Unit         string      `json:"unit,omitempty"`
+		PricedPer    string      `json:"priced_per,omitempty"`
+		Subtotal     money.Cents `json:"subtotal"`
+		AddedPrice   money.Cents `json:"added_price"`
+		PriceChanged bool        `json:"price_changed"`
+		Availability string      `json:"availability"`
 	}
 
+	// CartReqBody takes decimal quantities in Unit kg or g for products sold by weight
 	CartReqBody struct {
-		ID       uint `json:"id"`
-		Quantity int  `json:"quantity"`
+		ID       uint        `json:"id"`
+		Quantity json.Number `json:"quantity"`
+		Unit     string      `json:"unit,omitempty"`
 	}
 )
 
-func NewManager(dbManager db.Manager) Manager {
+const (
+	// AvailabilityInsufficientStock lines are priced but have to be reduced to the stock
+	// left, AvailabilityOutOfStock and AvailabilityUnavailable lines of products removed
+	// from the catalog can not be bought and are left out of the totals
+	AvailabilityAvailable         = "available"
+	AvailabilityInsufficientStock = "insufficient_stock"
+	AvailabilityOutOfStock        = "out_of_stock"
+	AvailabilityUnavailable       = "unavailable"
+
+	maxIdempotencyKeyLength = 100
+)
+
+func NewManager(dbManager db.Manager, store CartStore, promotionManager promotions.Manager) Manager {
 	return &cartHandler{
-		cache:     gocache.New(time.Hour*1, time.Minute*10),
-		dbManager: dbManager,
+		store:            store,
+		dbManager:        dbManager,
+		promotionManager: promotionManager,
+		now:              time.Now,
+		taxRate:          settings.GetTaxRate(),
+		mergeStrategy:    settings.GetCartMergeStrategy(),
 	}
 }
 
-func (c *cartHandler) GetAllCarts(r *http.Request) *[]CartCollection {
-	user := c.getUserInContext(r)
+func (c *cartHandler) GetAllCarts(r *http.Request) (*CartSummary, error) {
+	userID, err := c.getUserIDInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := c.store.Get(userID)
+	if err != nil {
+		return nil, err
+	}
 
-	if data, ok := c.cache.Get(user.Username); ok {
-		myCart := data.(*[]CartCollection)
+	return c.priceItems(items)
+}
 
-		return myCart
+// priceItems prices items at the current catalog prices and applies the promotions
+func (c *cartHandler) priceItems(items []CartItem) (*CartSummary, error) {
+	cartCol := []CartCollection{}
+	lines := []promotions.Line{}
+	for _, item := range items {
+		product, err := c.dbManager.GetProductByID(item.ProductID)
+		if err != nil {
+			// The product was removed from the catalog after it was added
+			data := CartCollection{Quantity: item.Quantity, AddedPrice: item.AddedPrice, Availability: AvailabilityUnavailable}
+			data.ID = item.ProductID
+			cartCol = append(cartCol, data)
+			continue
+		}
+
+		data := c.populateToCartCollection(product, item)
+		cartCol = append(cartCol, data)
+		if !data.purchasable() {
+			continue
+		}
+
+		// A product sold by weight counts as one item priced at its subtotal for promotions
+		line := promotions.Line{ProductID: data.ID, Quantity: data.Quantity, UnitPrice: data.SalesPrice}
+		if product.SoldByWeight {
+			line.Quantity, line.UnitPrice = 1, data.Subtotal
+		}
+		lines = append(lines, line)
 	}
 
-	return &[]CartCollection{}
+	discounts, err := c.promotionManager.Apply(lines)
+	if err != nil {
+		return nil, err
+	}
+
+	return summarize(cartCol, discounts, c.taxRate), nil
 }
 
 func (c *cartHandler) AddToCart(r *http.Request) (string, error) {
@@ -71,24 +146,34 @@ func (c *cartHandler) AddToCart(r *http.Request) (string, error) {
 		return "", err
 	}
 
-	product, err := c.dbManager.GetProductByID(reqData.ID)
+	productCol, err := c.dbManager.GetProductByID(reqData.ID)
+	if err != nil {
+		return "", err
+	}
+
+	quantity, err := c.parseQuantity(productCol, reqData.Quantity, reqData.Unit)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.validateQuantity(productCol, quantity); err != nil {
+		return "", err
+	}
+
+	userID, err := c.getUserIDInContext(r)
 	if err != nil {
 		return "", err
 	}
 
-	user := c.getUserInContext(r)
-	cachedData, ok := c.cache.Get(user.Username)
-	if ok {
-		cachedList := cachedData.(*[]CartCollection)
-		if c.isProductIDInCache(cachedList, reqData.ID) {
-			return "", errors.New("Product already in cart instead use PUT to update cart")
+	err = c.update(r, userID, func(items []CartItem) ([]CartItem, error) {
+		if c.findCartItem(items, reqData.ID) >= 0 {
+			return nil, errors.New("Product already in cart instead use PUT to update cart")
 		}
 
-		*cachedList = append(*cachedList, c.populateToCartCollection(product, reqData.Quantity))
-		c.cache.Set(user.Username, cachedList, gocache.DefaultExpiration)
-	} else {
-		cartCol := &[]CartCollection{c.populateToCartCollection(product, reqData.Quantity)}
-		c.cache.Set(user.Username, cartCol, gocache.DefaultExpiration)
+		return append(items, CartItem{ProductID: reqData.ID, Quantity: quantity, AddedPrice: c.unitPrice(productCol)}), nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	return "Successfully added to cart", nil
@@ -105,14 +190,39 @@ func (c *cartHandler) UpdateCart(r *http.Request, productID string) (string, err
 		return "", fmt.Errorf("Unable to parse productID:%v", productID)
 	}
 
-	user := c.getUserInContext(r)
-	cachedData, ok := c.cache.Get(user.Username)
-	if !ok || !c.isProductIDInCache(cachedData.(*[]CartCollection), uint(pID)) {
-		return "", errors.New("Product does not exist in cart instead use POST to add in cart")
+	productCol, err := c.dbManager.GetProductByID(uint(pID))
+	if err != nil {
+		return "", err
+	}
+
+	quantity, err := c.parseQuantity(productCol, reqData.Quantity, reqData.Unit)
+	if err != nil {
+		return "", err
 	}
 
-	cartCol := c.updateCartCollection(reqData, uint(pID), cachedData.(*[]CartCollection))
-	c.cache.Set(user.Username, cartCol, gocache.DefaultExpiration)
+	if err := c.validateQuantity(productCol, quantity); err != nil {
+		return "", err
+	}
+
+	userID, err := c.getUserIDInContext(r)
+	if err != nil {
+		return "", err
+	}
+
+	err = c.update(r, userID, func(items []CartItem) ([]CartItem, error) {
+		index := c.findCartItem(items, uint(pID))
+		if index < 0 {
+			return nil, errors.New("Product does not exist in cart instead use POST to add in cart")
+		}
+
+		items[index].Quantity = quantity
+		items[index].AddedPrice = c.unitPrice(productCol)
+
+		return items, nil
+	})
+	if err != nil {
+		return "", err
+	}
 
 	return "Successfully updated in cart", nil
 }
@@ -123,80 +233,165 @@ func (c *cartHandler) DeleteCart(r *http.Request, productID string) (string, err
 		return "", fmt.Errorf("Unable to parse productID:%v", productID)
 	}
 
-	user := c.getUserInContext(r)
-	cachedData, ok := c.cache.Get(user.Username)
-
-	if !ok || !c.isProductIDInCache(cachedData.(*[]CartCollection), uint(pID)) {
-		return "", errors.New("Product does not exist in cart")
+	userID, err := c.getUserIDInContext(r)
+	if err != nil {
+		return "", err
 	}
 
-	cartCol := c.deleteInCartCollection(uint(pID), cachedData.(*[]CartCollection))
-	if len(*cartCol) > 0 {
-		c.cache.Set(user.Username, cartCol, gocache.DefaultExpiration)
-	} else {
-		c.cache.Delete(user.Username)
+	err = c.update(r, userID, func(items []CartItem) ([]CartItem, error) {
+		index := c.findCartItem(items, uint(pID))
+		if index < 0 {
+			return nil, errors.New("Product does not exist in cart")
+		}
+
+		return append(items[:index], items[index+1:]...), nil
+	})
+	if err != nil {
+		return "", err
 	}
 
 	return "Successfully deleted in cart", nil
 }
 
-func (c *cartHandler) getUserInContext(r *http.Request) auth.User {
-	decoded := context.Get(r, "tokenClaims")
-	var user auth.User
-	mapstructure.Decode(decoded.(jwt.MapClaims), &user)
+// ParseQuantity reads a requested quantity of productID like a cart change, in grams
+// for products sold by weight
+func (c *cartHandler) ParseQuantity(productID uint, quantity json.Number, unit string) (int, error) {
+	productCol, err := c.dbManager.GetProductByID(productID)
+	if err != nil {
+		return 0, err
+	}
 
-	return user
+	return c.parseQuantity(productCol, quantity, unit)
 }
 
-func (c *cartHandler) updateCartCollection(reqData CartReqBody, pID uint, cachedCol *[]CartCollection) *[]CartCollection {
-	var cartCol []CartCollection
+// AddItem adds quantity of productID to the cart of owner, the quantity is added to the
+// one already in the cart and validated like any other change
+func (c *cartHandler) AddItem(owner string, productID uint, quantity int) error {
+	productCol, err := c.dbManager.GetProductByID(productID)
+	if err != nil {
+		return err
+	}
 
-	for _, data := range *cachedCol {
-		if data.ID == reqData.ID {
-			data.Quantity = reqData.Quantity
+	return c.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+		index := c.findCartItem(items, productID)
+		if index < 0 {
+			items = append(items, CartItem{ProductID: productID})
+			index = len(items) - 1
 		}
 
-		cartCol = append(cartCol, data)
-	}
+		items[index].Quantity += quantity
+		items[index].AddedPrice = c.unitPrice(productCol)
 
-	return &cartCol
+		return items, c.validateQuantity(productCol, items[index].Quantity)
+	})
 }
 
-func (c *cartHandler) deleteInCartCollection(productID uint, cachedCol *[]CartCollection) *[]CartCollection {
-	var cartCol []CartCollection
-
-	for _, data := range *cachedCol {
-		if data.ID != productID {
-			cartCol = append(cartCol, data)
+// RemoveItem takes productID out of the cart of owner and returns its quantity
+func (c *cartHandler) RemoveItem(owner string, productID uint) (int, error) {
+	var quantity int
+	err := c.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+		index := c.findCartItem(items, productID)
+		if index < 0 {
+			return nil, errors.New("Product does not exist in cart")
 		}
+
+		quantity = items[index].Quantity
+
+		return append(items[:index], items[index+1:]...), nil
+	})
+
+	return quantity, err
+}
+
+// update applies a change of the cart of userID once per Idempotency-Key header, a
+// retried request with the same key succeeds without changing the cart again
+func (c *cartHandler) update(r *http.Request, userID string, update func(items []CartItem) ([]CartItem, error)) error {
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return fmt.Errorf("Idempotency-Key must be at most %v characters", maxIdempotencyKeyLength)
+	}
+
+	_, err := c.store.UpdateOnce(userID, idempotencyKey, update)
+
+	return err
+}
+
+func (c *cartHandler) getUserIDInContext(r *http.Request) (string, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return "", err
 	}
 
-	return &cartCol
+	return principal.Subject, nil
 }
 
-func (c *cartHandler) populateToCartCollection(product *entities.ProductCollection, quantity int) CartCollection {
-	data := CartCollection{Quantity: quantity}
-	data.ID = product.ID
-	data.Name = product.Name
-	data.Slug = product.Slug
+// populateToCartCollection prices item at the current price of productCol and flags
+// the changes since the item was added
+func (c *cartHandler) populateToCartCollection(productCol *entities.ProductCollection, item CartItem) CartCollection {
+	quantity := item.Quantity
+	data := CartCollection{Quantity: quantity, AddedPrice: item.AddedPrice, Availability: availability(productCol, quantity)}
+	data.ID = productCol.ID
+	data.Name = productCol.Name
+	data.Slug = productCol.Slug
+
+	if len(productCol.Images) > 0 {
+		data.Image = productCol.Images[0].Value
+	}
+
+	data.SalesPrice = c.unitPrice(productCol)
+	data.PriceChanged = item.AddedPrice > 0 && item.AddedPrice != data.SalesPrice
+
+	if productCol.SoldByWeight {
+		data.Unit, data.PricedPer = product.UnitGram, product.WeightUnit(productCol)
+	}
 
-	if len(product.Images) > 0 {
-		data.Image = product.Images[0].Value
+	if !data.purchasable() {
+		return data
 	}
 
-	if len(product.Offers) > 0 {
-		data.SalesPrice = product.Offers[0].Price
+	data.Subtotal = data.SalesPrice.Times(quantity)
+	if productCol.SoldByWeight {
+		data.Subtotal = data.SalesPrice.Scale(int64(quantity), int64(product.GramsPerUnit(data.PricedPer)))
 	}
 
 	return data
 }
 
-func (c *cartHandler) isProductIDInCache(cachedList *[]CartCollection, pID uint) bool {
-	for _, c := range *cachedList {
-		if c.ID == pID {
-			return true
+// unitPrice is the price of one unit of productCol, or of the unit it is priced per when
+// it is sold by weight. Items are priced at their base price, offers are applied to the
+// cart as discounts so products without a base price are unavailable.
+func (c *cartHandler) unitPrice(productCol *entities.ProductCollection) money.Cents {
+	return productCol.BasePrice
+}
+
+// availability compares quantity with the stock of productCol, both in grams for
+// products sold by weight. Products without a base price can not be priced and are unavailable
+func availability(productCol *entities.ProductCollection, quantity int) string {
+	switch {
+	case productCol.BasePrice <= 0:
+		return AvailabilityUnavailable
+	case productCol.Stock == nil || productCol.UnlimitedStock:
+		return AvailabilityAvailable
+	case *productCol.Stock <= 0:
+		return AvailabilityOutOfStock
+	case *productCol.Stock < quantity:
+		return AvailabilityInsufficientStock
+	default:
+		return AvailabilityAvailable
+	}
+}
+
+// purchasable reports whether the line counts towards the totals of the cart
+func (data CartCollection) purchasable() bool {
+	return data.Availability != AvailabilityOutOfStock && data.Availability != AvailabilityUnavailable
+}
+
+func (c *cartHandler) findCartItem(items []CartItem, pID uint) int {
+	for i, item := range items {
+		if item.ProductID == pID {
+			return i
 		}
 	}
 
-	return false
+	return -1
 }
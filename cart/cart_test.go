@@ -0,0 +1,501 @@
+package cart
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+type fakeCartDB struct {
+	db.Manager
+	// mu stands in for the row lock taken by UpdateCartItems
+	mu              sync.Mutex
+	carts           map[string][]entities.CartItem
+	idempotencyKeys map[string]bool
+	promotions      []entities.Promotion
+	// catalog replaces the generated products by id
+	catalog map[uint]entities.ProductCollection
+}
+
+func newFakeCartDB() *fakeCartDB {
+	return &fakeCartDB{carts: make(map[string][]entities.CartItem), idempotencyKeys: make(map[string]bool)}
+}
+
+// weightProductID is sold by weight at 12.99 per kg in steps of 50 g
+const weightProductID = 500
+
+func (f *fakeCartDB) GetProductByID(pID uint) (*entities.ProductCollection, error) {
+	f.mu.Lock()
+	productCol, ok := f.catalog[pID]
+	f.mu.Unlock()
+	if ok {
+		return &productCol, nil
+	}
+
+	if pID == weightProductID {
+		return &entities.ProductCollection{
+			ID:              pID,
+			Name:            "Minced Beef",
+			BasePrice:       1299,
+			SoldByWeight:    true,
+			WeightUnit:      "kg",
+			WeightIncrement: 50,
+		}, nil
+	}
+
+	if pID == 0 || pID > 100 {
+		return nil, fmt.Errorf("Product with productID:%v does not exist", pID)
+	}
+
+	return &entities.ProductCollection{
+		ID:        pID,
+		Name:      fmt.Sprintf("Product %v", pID),
+		BasePrice: 150,
+	}, nil
+}
+
+func (f *fakeCartDB) GetCartItems(owner string) (*[]entities.CartItem, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	items := append([]entities.CartItem{}, f.carts[owner]...)
+	return &items, nil
+}
+
+func (f *fakeCartDB) UpdateCartItems(owner, idempotencyKey string, update func(items []entities.CartItem) ([]entities.CartItem, error)) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if idempotencyKey != "" && f.idempotencyKeys[owner+":"+idempotencyKey] {
+		return false, nil
+	}
+
+	items, err := update(append([]entities.CartItem{}, f.carts[owner]...))
+	if err != nil {
+		return false, err
+	}
+
+	if idempotencyKey != "" {
+		f.idempotencyKeys[owner+":"+idempotencyKey] = true
+	}
+
+	if len(items) == 0 {
+		delete(f.carts, owner)
+	} else {
+		f.carts[owner] = items
+	}
+
+	return true, nil
+}
+
+func (f *fakeCartDB) GetPromotionsByProductIDs(productIDs []uint) (*[]entities.Promotion, error) {
+	data := []entities.Promotion{}
+	for _, promotion := range f.promotions {
+		for _, link := range promotion.Products {
+			if containsProductID(productIDs, link.ProductID) {
+				data = append(data, promotion)
+				break
+			}
+		}
+	}
+
+	return &data, nil
+}
+
+func containsProductID(productIDs []uint, productID uint) bool {
+	for _, id := range productIDs {
+		if id == productID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newCartRequest(method, target, body, owner string) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: owner, Role: auth.RoleCustomer}))
+}
+
+func Test_cartHandler_sqlStore(t *testing.T) {
+	fakeDB := newFakeCartDB()
+	c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":1,"quantity":2}`, "1")); err != nil {
+		t.Fatalf("AddToCart() error = %v", err)
+	}
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":2,"quantity":1}`, "1")); err != nil {
+		t.Fatalf("AddToCart() error = %v", err)
+	}
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":1,"quantity":1}`, "1")); err == nil {
+		t.Errorf("AddToCart() accepted a product already in the cart")
+	}
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":999,"quantity":1}`, "1")); err == nil {
+		t.Errorf("AddToCart() accepted an unknown product")
+	}
+	if _, err := c.UpdateCart(newCartRequest("PUT", "/api/carts/2", `{"quantity":5}`, "1"), "2"); err != nil {
+		t.Fatalf("UpdateCart() error = %v", err)
+	}
+
+	// A new handler sharing the database stands in for a restart or a second replica
+	restarted := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	tests := []struct {
+		name  string
+		owner string
+		want  map[uint]int
+	}{
+		{
+			name:  "Cart survives the restart",
+			owner: "1",
+			want:  map[uint]int{1: 2, 2: 5},
+		},
+		{
+			name:  "Other owners have an empty cart",
+			owner: "2",
+			want:  map[uint]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := restarted.GetAllCarts(newCartRequest("GET", "/api/carts", "", tt.owner))
+			if err != nil {
+				t.Fatalf("GetAllCarts() error = %v", err)
+			}
+
+			if len(got.Items) != len(tt.want) {
+				t.Fatalf("GetAllCarts() = %+v, want %v", *got, tt.want)
+			}
+			for _, cartCol := range got.Items {
+				if tt.want[cartCol.ID] != cartCol.Quantity {
+					t.Errorf("GetAllCarts() product %v quantity = %v, want %v", cartCol.ID, cartCol.Quantity, tt.want[cartCol.ID])
+				}
+			}
+		})
+	}
+
+	for _, pID := range []string{"1", "2"} {
+		if _, err := restarted.DeleteCart(newCartRequest("DELETE", "/api/carts/"+pID, "", "1"), pID); err != nil {
+			t.Fatalf("DeleteCart() error = %v", err)
+		}
+	}
+
+	if _, ok := fakeDB.carts["1"]; ok {
+		t.Errorf("DeleteCart() kept an empty cart")
+	}
+}
+
+func Test_cartHandler_promotions(t *testing.T) {
+	fakeDB := newFakeCartDB()
+	fakeDB.promotions = []entities.Promotion{{
+		ID:          261604,
+		Type:        promotions.TypeBuyXAtPrice,
+		Description: "Buy 1 Product 1 @ $0.50 Off",
+		Rule:        `{"buy":{"1":{"q":1}},"total":{"t":"ABSOLUTE_OFF","v":0.5}}`,
+		Products:    []entities.PromotionProduct{{PromotionID: 261604, ProductID: 1}},
+	}}
+	c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	for _, body := range []string{`{"id":1,"quantity":3}`, `{"id":2,"quantity":1}`} {
+		if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", body, "1")); err != nil {
+			t.Fatalf("AddToCart() error = %v", err)
+		}
+	}
+
+	got, err := c.GetAllCarts(newCartRequest("GET", "/api/carts", "", "1"))
+	if err != nil {
+		t.Fatalf("GetAllCarts() error = %v", err)
+	}
+
+	if len(got.Discounts) != 1 || got.Discounts[0].Times != 3 || got.Discounts[0].Amount != 150 {
+		t.Fatalf("GetAllCarts() discounts = %+v, want 3 times 0.50 off", got.Discounts)
+	}
+	if got.Subtotal != 600 || got.DiscountTotal != 150 || got.Total != money.Cents(450)+got.Tax {
+		t.Errorf("GetAllCarts() = %+v", *got)
+	}
+}
+
+func Test_cartHandler_soldByWeight(t *testing.T) {
+	fakeDB := newFakeCartDB()
+	c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":500,"quantity":0.25}`, "1")); err != nil {
+		t.Fatalf("AddToCart() error = %v", err)
+	}
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":1,"quantity":2}`, "1")); err != nil {
+		t.Fatalf("AddToCart() error = %v", err)
+	}
+	if _, err := c.UpdateCart(newCartRequest("PUT", "/api/carts/500", `{"quantity":1.05}`, "1"), "500"); err != nil {
+		t.Fatalf("UpdateCart() error = %v", err)
+	}
+	if _, err := c.UpdateCart(newCartRequest("PUT", "/api/carts/500", `{"quantity":1020,"unit":"g"}`, "1"), "500"); err == nil {
+		t.Errorf("UpdateCart() accepted a weight between two increments")
+	}
+
+	got, err := c.GetAllCarts(newCartRequest("GET", "/api/carts", "", "1"))
+	if err != nil {
+		t.Fatalf("GetAllCarts() error = %v", err)
+	}
+
+	for _, item := range got.Items {
+		if item.ID == weightProductID && (item.Quantity != 1050 || item.Unit != "g" || item.PricedPer != "kg" || item.Subtotal != 1364) {
+			t.Errorf("GetAllCarts() weight item = %+v, want 1050 g priced per kg", item)
+		}
+	}
+	if got.ItemCount != 3 || got.Subtotal != 1364+300 {
+		t.Errorf("GetAllCarts() item count = %v, subtotal = %v", got.ItemCount, got.Subtotal)
+	}
+}
+
+func Test_cartHandler_BatchUpdateCart(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[uint]int
+		wantErr bool
+	}{
+		{
+			name: "Add and set put products in the cart",
+			body: `{"operations":[{"op":"add","id":1,"quantity":2},{"op":"set","id":3,"quantity":4}]}`,
+			want: map[uint]int{1: 3, 2: 1, 3: 4},
+		},
+		{
+			name: "Set replaces and remove takes out",
+			body: `{"operations":[{"op":"set","id":1,"quantity":5},{"op":"remove","id":2},{"op":"remove","id":9}]}`,
+			want: map[uint]int{1: 5},
+		},
+		{
+			name: "Weight products take decimal quantities",
+			body: `{"operations":[{"op":"add","id":500,"quantity":0.5},{"op":"add","id":500,"quantity":250,"unit":"g"}]}`,
+			want: map[uint]int{1: 1, 2: 1, 500: 750},
+		},
+		{
+			name:    "A failing operation leaves the cart unchanged",
+			body:    `{"operations":[{"op":"remove","id":1},{"op":"add","id":999,"quantity":1}]}`,
+			want:    map[uint]int{1: 1, 2: 1},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown op",
+			body:    `{"operations":[{"op":"replace","id":1,"quantity":1}]}`,
+			want:    map[uint]int{1: 1, 2: 1},
+			wantErr: true,
+		},
+		{
+			name:    "Empty batch",
+			body:    `{"operations":[]}`,
+			want:    map[uint]int{1: 1, 2: 1},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDB := newFakeCartDB()
+			fakeDB.carts["1"] = []entities.CartItem{{ProductID: 1, Quantity: 1}, {ProductID: 2, Quantity: 1}}
+			c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+			_, err := c.BatchUpdateCart(newCartRequest("PATCH", "/api/carts", tt.body, "1"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BatchUpdateCart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got := make(map[uint]int)
+			for _, item := range fakeDB.carts["1"] {
+				got[item.ProductID] = item.Quantity
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("BatchUpdateCart() cart = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cartHandler_idempotencyKey(t *testing.T) {
+	fakeDB := newFakeCartDB()
+	c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	for attempt := 0; attempt < 2; attempt++ {
+		r := newCartRequest("PATCH", "/api/carts", `{"operations":[{"op":"add","id":1,"quantity":2}]}`, "1")
+		r.Header.Set("Idempotency-Key", "9b1deb4d-3b7d-4bad-9bdd-2b0d7b3dcb6d")
+
+		if _, err := c.BatchUpdateCart(r); err != nil {
+			t.Fatalf("BatchUpdateCart() attempt %v error = %v", attempt, err)
+		}
+	}
+
+	if items := fakeDB.carts["1"]; len(items) != 1 || items[0].Quantity != 2 {
+		t.Errorf("BatchUpdateCart() retried with the same key = %+v, want quantity 2", items)
+	}
+
+	r := newCartRequest("POST", "/api/carts", `{"id":2,"quantity":1}`, "1")
+	r.Header.Set("Idempotency-Key", strings.Repeat("k", 101))
+	if _, err := c.AddToCart(r); err == nil {
+		t.Errorf("AddToCart() accepted an Idempotency-Key longer than 100 characters")
+	}
+}
+
+func Test_cartHandler_priceSnapshot(t *testing.T) {
+	stock := func(quantity int) *int { return &quantity }
+
+	fakeDB := newFakeCartDB()
+	fakeDB.catalog = map[uint]entities.ProductCollection{
+		201: {ID: 201, Name: "Meiji Fresh Milk", BasePrice: 635},
+		202: {ID: 202, Name: "Marigold Yoghurt", BasePrice: 199, Stock: stock(10)},
+		203: {ID: 203, Name: "Gardenia Bread", BasePrice: 285, Stock: stock(10)},
+		204: {ID: 204, Name: "Pokka Green Tea", BasePrice: 150},
+		205: {ID: 205, Name: "Hokkaido Butter", BasePrice: 890},
+		// Offers are discounts on the base price, they do not price a product without one
+		206: {ID: 206, Name: "Kewpie Mayonnaise", Offers: []entities.ProductOffers{{Price: 3.5}}},
+	}
+	c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	body := `{"operations":[{"op":"add","id":201,"quantity":2},{"op":"add","id":202,"quantity":3},{"op":"add","id":203,"quantity":1},{"op":"add","id":204,"quantity":1},{"op":"add","id":205,"quantity":1},{"op":"add","id":206,"quantity":1}]}`
+	if _, err := c.BatchUpdateCart(newCartRequest("PATCH", "/api/carts", body, "1")); err != nil {
+		t.Fatalf("BatchUpdateCart() error = %v", err)
+	}
+
+	fakeDB.mu.Lock()
+	fakeDB.catalog[201] = entities.ProductCollection{ID: 201, Name: "Meiji Fresh Milk", BasePrice: 655}
+	fakeDB.catalog[202] = entities.ProductCollection{ID: 202, Name: "Marigold Yoghurt", BasePrice: 199, Stock: stock(2)}
+	fakeDB.catalog[203] = entities.ProductCollection{ID: 203, Name: "Gardenia Bread", BasePrice: 285, Stock: stock(0)}
+	delete(fakeDB.catalog, 205)
+	fakeDB.mu.Unlock()
+
+	summary, err := c.GetAllCarts(newCartRequest("GET", "/api/carts", "", "1"))
+	if err != nil {
+		t.Fatalf("GetAllCarts() error = %v", err)
+	}
+
+	type line struct {
+		salesPrice, addedPrice, subtotal money.Cents
+		priceChanged                     bool
+		availability                     string
+	}
+	want := map[uint]line{
+		201: {655, 635, 1310, true, AvailabilityAvailable},
+		202: {199, 199, 597, false, AvailabilityInsufficientStock},
+		203: {285, 285, 0, false, AvailabilityOutOfStock},
+		204: {150, 150, 150, false, AvailabilityAvailable},
+		205: {0, 890, 0, false, AvailabilityUnavailable},
+		206: {0, 0, 0, false, AvailabilityUnavailable},
+	}
+	for _, item := range summary.Items {
+		got := line{item.SalesPrice, item.AddedPrice, item.Subtotal, item.PriceChanged, item.Availability}
+		if got != want[item.ID] {
+			t.Errorf("GetAllCarts() product %v = %+v, want %+v", item.ID, got, want[item.ID])
+		}
+	}
+	if len(summary.Items) != len(want) || !summary.HasChanges || summary.ItemCount != 6 || summary.Subtotal != 2057 {
+		t.Errorf("GetAllCarts() = %v lines, has changes %v, %v items, subtotal %v", len(summary.Items), summary.HasChanges, summary.ItemCount, summary.Subtotal)
+	}
+
+	// Changing the quantity records the price the shopper sees now
+	if _, err := c.UpdateCart(newCartRequest("PUT", "/api/carts/201", `{"quantity":1}`, "1"), "201"); err != nil {
+		t.Fatalf("UpdateCart() error = %v", err)
+	}
+	if items := fakeDB.carts["1"]; items[0].ProductID != 201 || items[0].AddedPrice != 655 {
+		t.Errorf("UpdateCart() = %+v, want added price 655", items[0])
+	}
+}
+
+func Test_cartHandler_noPrincipal(t *testing.T) {
+	fakeDB := newFakeCartDB()
+	c := NewManager(fakeDB, NewSQLStore(fakeDB), promotions.NewManager(fakeDB))
+
+	if _, err := c.GetAllCarts(httptest.NewRequest("GET", "/api/carts", nil)); err != auth.ErrNoPrincipal {
+		t.Errorf("GetAllCarts() error = %v, want %v", err, auth.ErrNoPrincipal)
+	}
+}
+
+// Test_cartHandler_concurrent is meant to run with go test -race, every request
+// changes the same cart so lost updates show up as missing or leftover items
+func Test_cartHandler_concurrent(t *testing.T) {
+	server, closeServer := newTestRedisPool(t)
+	defer closeServer()
+
+	redisPool := NewRedisPool(testRedisURL(server))
+	defer redisPool.Close()
+
+	fakeDB := newFakeCartDB()
+
+	tests := []struct {
+		name  string
+		store CartStore
+	}{
+		{
+			name:  "memory",
+			store: NewMemoryStore(time.Hour, time.Hour),
+		},
+		{
+			name:  "sql",
+			store: NewSQLStore(fakeDB),
+		},
+		{
+			name:  "redis",
+			store: NewRedisStore(redisPool, time.Hour, time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewManager(fakeDB, tt.store, promotions.NewManager(fakeDB))
+			owner := "concurrent-" + tt.name
+			const products = 20
+
+			hammer := func(request func(pID string) (string, error)) {
+				var wg sync.WaitGroup
+				for i := 1; i <= products; i++ {
+					wg.Add(1)
+					go func(pID string) {
+						defer wg.Done()
+						if _, err := request(pID); err != nil {
+							t.Errorf("request for product %v error = %v", pID, err)
+						}
+					}(strconv.Itoa(i))
+				}
+				wg.Wait()
+			}
+
+			hammer(func(pID string) (string, error) {
+				return c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":`+pID+`,"quantity":1}`, owner))
+			})
+			hammer(func(pID string) (string, error) {
+				return c.UpdateCart(newCartRequest("PUT", "/api/carts/"+pID, `{"quantity":`+pID+`}`, owner), pID)
+			})
+
+			got, err := c.GetAllCarts(newCartRequest("GET", "/api/carts", "", owner))
+			if err != nil {
+				t.Fatalf("GetAllCarts() error = %v", err)
+			}
+			if len(got.Items) != products {
+				t.Fatalf("GetAllCarts() has %v products, want %v", len(got.Items), products)
+			}
+			for _, cartCol := range got.Items {
+				if cartCol.Quantity != int(cartCol.ID) {
+					t.Errorf("GetAllCarts() product %v quantity = %v, want %v", cartCol.ID, cartCol.Quantity, cartCol.ID)
+				}
+			}
+
+			hammer(func(pID string) (string, error) {
+				return c.DeleteCart(newCartRequest("DELETE", "/api/carts/"+pID, "", owner), pID)
+			})
+
+			if got, _ := c.GetAllCarts(newCartRequest("GET", "/api/carts", "", owner)); len(got.Items) != 0 {
+				t.Errorf("GetAllCarts() after deleting every product = %+v", got.Items)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package cart
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+// ErrEmptyCart is returned by Checkout when there is nothing to order
+var ErrEmptyCart = errors.New("Cart is empty")
+
+// Checkout prices the cart of owner, saves the order newOrder builds from the summary
+// and empties the cart. Changes of the cart wait for the checkout so the summary is the
+// cart that is emptied. Carts with lines that can not be bought as they are are rejected.
+// A checkout retried with the idempotencyKey of a placed order returns that order.
+func (c *cartHandler) Checkout(owner, idempotencyKey string, newOrder func(summary *CartSummary) *entities.Order) (*entities.Order, error) {
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, fmt.Errorf("Idempotency-Key must be at most %v characters", maxIdempotencyKeyLength)
+	}
+
+	place := func(items []CartItem) (*entities.Order, error) {
+		if len(items) == 0 {
+			return nil, ErrEmptyCart
+		}
+
+		summary, err := c.priceItems(items)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range summary.Items {
+			if err := checkoutError(item); err != nil {
+				return nil, err
+			}
+		}
+
+		order := newOrder(summary)
+		if idempotencyKey != "" {
+			order.IdempotencyKey = &idempotencyKey
+		}
+
+		return order, nil
+	}
+
+	if store, ok := c.store.(orderStore); ok {
+		return store.PlaceOrder(owner, idempotencyKey, place)
+	}
+
+	return c.placeOrder(owner, idempotencyKey, place)
+}
+
+// placeOrder saves the order before the cart is emptied for stores outside the database.
+// When emptying the cart fails the order stays placed, a retry with its idempotencyKey
+// returns it and takes its lines out of the cart instead of ordering them again.
+func (c *cartHandler) placeOrder(owner, idempotencyKey string, place func(items []CartItem) (*entities.Order, error)) (*entities.Order, error) {
+	var placed *entities.Order
+
+	err := c.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+		if placed == nil && idempotencyKey != "" {
+			if order, err := c.dbManager.GetOrderByIdempotencyKey(owner, idempotencyKey); err == nil {
+				placed = order
+			}
+		}
+
+		// The Redis store runs the update again when the cart changed in between, the
+		// order is placed once and only its lines are taken out of the cart
+		if placed != nil {
+			return withoutOrderLines(items, placed), nil
+		}
+
+		order, err := place(items)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := c.dbManager.CreateOrder(order); err != nil {
+			return nil, err
+		}
+
+		placed = order
+
+		return []CartItem{}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return placed, nil
+}
+
+// withoutOrderLines returns the items of a cart that were not ordered by order, items
+// changed since the order was placed stay in the cart
+func withoutOrderLines(items []CartItem, order *entities.Order) []CartItem {
+	ordered := make(map[uint]int)
+	for _, line := range order.Lines {
+		ordered[line.ProductID] = line.Quantity
+	}
+
+	remaining := []CartItem{}
+	for _, item := range items {
+		if quantity, ok := ordered[item.ProductID]; !ok || quantity != item.Quantity {
+			remaining = append(remaining, item)
+		}
+	}
+
+	return remaining
+}
+
+// checkoutError explains why item can not be ordered, it is nil for available items
+func checkoutError(item CartCollection) error {
+	switch item.Availability {
+	case AvailabilityUnavailable:
+		return fmt.Errorf("Product:%v is no longer available, remove it from the cart before checkout", item.ID)
+	case AvailabilityOutOfStock:
+		return fmt.Errorf("%v is out of stock, remove it from the cart before checkout", item.Name)
+	case AvailabilityInsufficientStock:
+		return fmt.Errorf("Not enough of %v is in stock, update the cart before checkout", item.Name)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,212 @@
+package cart
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+// fakeOrderDB saves the orders of a checkout, createErr fails every CreateOrder
+type fakeOrderDB struct {
+	*fakeCartDB
+	orders    []entities.Order
+	createErr error
+}
+
+func (f *fakeOrderDB) CreateOrder(order *entities.Order) error {
+	if f.createErr != nil {
+		return f.createErr
+	}
+
+	if order.IdempotencyKey != nil {
+		if _, err := f.GetOrderByIdempotencyKey(order.Owner, *order.IdempotencyKey); err == nil {
+			return fmt.Errorf("Unable to create order of owner:%v", order.Owner)
+		}
+	}
+
+	order.ID = uint(len(f.orders) + 1)
+	f.orders = append(f.orders, *order)
+
+	return nil
+}
+
+func (f *fakeOrderDB) GetOrderByIdempotencyKey(owner, idempotencyKey string) (*entities.Order, error) {
+	for _, order := range f.orders {
+		if order.Owner == owner && order.IdempotencyKey != nil && *order.IdempotencyKey == idempotencyKey {
+			return &order, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Order with Idempotency-Key:%v does not exist", idempotencyKey)
+}
+
+func (f *fakeOrderDB) PlaceCartOrder(owner, idempotencyKey string, newOrder func(items []entities.CartItem) (*entities.Order, error)) (*entities.Order, error) {
+	if order, err := f.GetOrderByIdempotencyKey(owner, idempotencyKey); err == nil {
+		return order, nil
+	}
+
+	f.mu.Lock()
+	items := append([]entities.CartItem{}, f.carts[owner]...)
+	f.mu.Unlock()
+
+	order, err := newOrder(items)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.CreateOrder(order); err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	delete(f.carts, owner)
+	f.mu.Unlock()
+
+	return order, nil
+}
+
+// failingStore loses the first result of Update after applying it, like a Redis
+// transaction whose EXEC fails after the order was placed
+type failingStore struct {
+	CartStore
+	failed bool
+}
+
+func (s *failingStore) Update(owner string, update func(items []CartItem) ([]CartItem, error)) error {
+	if s.failed {
+		return s.CartStore.Update(owner, update)
+	}
+
+	s.failed = true
+	items, _ := s.CartStore.Get(owner)
+	if _, err := update(items); err != nil {
+		return err
+	}
+
+	return errors.New("Unable to update cart of owner:1")
+}
+
+func newOrder(summary *CartSummary) *entities.Order {
+	order := &entities.Order{Owner: "1", ItemCount: summary.ItemCount, Total: summary.Total}
+	for _, item := range summary.Items {
+		order.Lines = append(order.Lines, entities.OrderLine{ProductID: item.ID, Quantity: item.Quantity})
+	}
+
+	return order
+}
+
+func Test_cartHandler_Checkout(t *testing.T) {
+	stock := 0
+	cartDB := newFakeCartDB()
+	cartDB.catalog = map[uint]entities.ProductCollection{
+		201: {ID: 201, Name: "Gardenia Bread", BasePrice: 285, Stock: &stock},
+	}
+	errPlace := errors.New("Unable to create order")
+
+	tests := []struct {
+		name      string
+		sqlStore  bool
+		items     []CartItem
+		createErr error
+		wantErr   bool
+		wantItems int
+	}{
+		{
+			name:    "Empty cart",
+			wantErr: true,
+		},
+		{
+			name:      "Out of stock line",
+			items:     []CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 201, Quantity: 1}},
+			wantErr:   true,
+			wantItems: 2,
+		},
+		{
+			name:      "Order can not be placed",
+			items:     []CartItem{{ProductID: 1, Quantity: 2}},
+			createErr: errPlace,
+			wantErr:   true,
+			wantItems: 1,
+		},
+		{
+			name:  "Cart is emptied",
+			items: []CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}},
+		},
+		{
+			name:      "Order can not be placed from the sql store",
+			sqlStore:  true,
+			items:     []CartItem{{ProductID: 1, Quantity: 2}},
+			createErr: errPlace,
+			wantErr:   true,
+			wantItems: 1,
+		},
+		{
+			name:     "Sql store cart is emptied with the order",
+			sqlStore: true,
+			items:    []CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDB := &fakeOrderDB{fakeCartDB: cartDB, createErr: tt.createErr}
+			store := NewMemoryStore(time.Hour, time.Hour)
+			if tt.sqlStore {
+				store = NewSQLStore(fakeDB)
+			}
+			store.Update("1", func(items []CartItem) ([]CartItem, error) { return tt.items, nil })
+			c := NewManager(fakeDB, store, promotions.NewManager(fakeDB))
+
+			placed, err := c.Checkout("1", "", newOrder)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Checkout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (placed == nil || placed.ID == 0 || placed.ItemCount != 3 || placed.Total != 450) {
+				t.Errorf("Checkout() placed %+v, want the priced cart", placed)
+			}
+
+			if items, _ := store.Get("1"); len(items) != tt.wantItems {
+				t.Errorf("Checkout() left %v items in the cart, want %v", len(items), tt.wantItems)
+			}
+		})
+	}
+}
+
+func Test_cartHandler_Checkout_retry(t *testing.T) {
+	fakeDB := &fakeOrderDB{fakeCartDB: newFakeCartDB()}
+	store := &failingStore{CartStore: NewMemoryStore(time.Hour, time.Hour)}
+	store.CartStore.Update("1", func(items []CartItem) ([]CartItem, error) {
+		return []CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}}, nil
+	})
+	c := NewManager(fakeDB, store, promotions.NewManager(fakeDB))
+
+	if _, err := c.Checkout("1", "checkout-1", newOrder); err == nil {
+		t.Fatalf("Checkout() emptied the cart of the failing store")
+	}
+
+	// The shopper changed the cart before retrying, the change is not ordered
+	store.Update("1", func(items []CartItem) ([]CartItem, error) {
+		return append(items, CartItem{ProductID: 3, Quantity: 1}), nil
+	})
+
+	placed, err := c.Checkout("1", "checkout-1", newOrder)
+	if err != nil {
+		t.Fatalf("Checkout() retry error = %v", err)
+	}
+
+	if len(fakeDB.orders) != 1 || placed.ID != fakeDB.orders[0].ID {
+		t.Errorf("Checkout() retry placed %+v, want the order of the first attempt of %v orders", placed, len(fakeDB.orders))
+	}
+
+	if items, _ := store.Get("1"); len(items) != 1 || items[0].ProductID != 3 {
+		t.Errorf("Checkout() retry left %+v in the cart, want only the item added since", items)
+	}
+
+	if _, err := c.Checkout("1", "checkout-2", newOrder); err != nil || len(fakeDB.orders) != 2 {
+		t.Errorf("Checkout() with another key error = %v, placed %v orders, want a new order", err, len(fakeDB.orders))
+	}
+}
@@ -0,0 +1,143 @@
+package cart
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+)
+
+const (
+	// CartTokenHeader carries the token of the guest cart of an anonymous shopper
+	CartTokenHeader = "X-Cart-Token"
+
+	// MergeSum adds the quantities of products in both carts when a guest logs in,
+	// MergeLatest keeps the quantity of the guest cart which was changed last
+	MergeSum    = "sum"
+	MergeLatest = "latest"
+
+	guestOwnerPrefix = "guest:"
+	cartTokenBytes   = 32
+)
+
+// ErrInvalidCartToken is returned for a cart token that is not 32 base64url encoded bytes.
+// Tokens are not stored, any well formed token opens its own guest cart and can not be
+// guessed to reach the cart of another token
+var ErrInvalidCartToken = errors.New("Invalid cart token")
+
+type GuestCart struct {
+	CartToken string `json:"cart_token"`
+}
+
+// CreateGuestCart issues the token of a new guest cart, the cart itself is created by its first change
+func (c *cartHandler) CreateGuestCart() (*GuestCart, error) {
+	b := make([]byte, cartTokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &GuestCart{CartToken: base64.RawURLEncoding.EncodeToString(b)}, nil
+}
+
+// GuestPrincipal returns the principal of an anonymous shopper using the guest cart of cartToken
+func GuestPrincipal(cartToken string) (*auth.Principal, error) {
+	owner, err := guestOwner(cartToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &auth.Principal{Subject: owner, Role: auth.RoleGuest}, nil
+}
+
+// guestOwner is the owner of the guest cart of cartToken, it holds a hash of the
+// token so the token can not be read back from the cart store
+func guestOwner(cartToken string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cartToken)
+	if err != nil || len(b) != cartTokenBytes {
+		return "", ErrInvalidCartToken
+	}
+
+	sum := sha256.Sum256([]byte(cartToken))
+
+	return guestOwnerPrefix + hex.EncodeToString(sum[:]), nil
+}
+
+// MergeGuestCart moves the items of the guest cart of cartToken into the cart of owner,
+// products in both carts are merged by the CART_MERGE_STRATEGY. The guest cart is emptied
+// first so a repeated login never merges it twice, its items are put back when the merge fails.
+func (c *cartHandler) MergeGuestCart(cartToken, owner string) error {
+	guest, err := guestOwner(cartToken)
+	if err != nil {
+		return err
+	}
+
+	var guestItems []CartItem
+	err = c.store.Update(guest, func(items []CartItem) ([]CartItem, error) {
+		guestItems = items
+		return []CartItem{}, nil
+	})
+	if err != nil || len(guestItems) == 0 {
+		return err
+	}
+
+	products := make(map[uint]*entities.ProductCollection)
+	for _, item := range guestItems {
+		if productCol, err := c.dbManager.GetProductByID(item.ProductID); err == nil {
+			products[item.ProductID] = productCol
+		}
+	}
+
+	err = c.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+		return c.mergeItems(items, guestItems, products), nil
+	})
+	if err != nil {
+		restoreErr := c.store.Update(guest, func(items []CartItem) ([]CartItem, error) {
+			return c.mergeItems(items, guestItems, products), nil
+		})
+		if restoreErr != nil {
+			logger.Log.Errorf("Unable to restore guest cart of owner:%v due to: %v", owner, restoreErr)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// mergeItems merges guestItems into items, products no longer in the catalog are
+// dropped and a merged quantity the product limits reject keeps the larger quantity
+func (c *cartHandler) mergeItems(items, guestItems []CartItem, products map[uint]*entities.ProductCollection) []CartItem {
+	for _, guestItem := range guestItems {
+		productCol, ok := products[guestItem.ProductID]
+		if !ok {
+			continue
+		}
+
+		index := c.findCartItem(items, guestItem.ProductID)
+		if index < 0 {
+			items = append(items, guestItem)
+			continue
+		}
+
+		quantity := guestItem.Quantity
+		if c.mergeStrategy == MergeSum {
+			quantity += items[index].Quantity
+		}
+
+		if c.validateQuantity(productCol, quantity) != nil {
+			quantity = items[index].Quantity
+			if guestItem.Quantity > quantity {
+				quantity = guestItem.Quantity
+			}
+		}
+
+		items[index].Quantity = quantity
+	}
+
+	return items
+}
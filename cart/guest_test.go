@@ -0,0 +1,121 @@
+package cart
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+func TestGuestPrincipal(t *testing.T) {
+	c := &cartHandler{}
+	guestCart, err := c.CreateGuestCart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, err := GuestPrincipal(guestCart.CartToken)
+	if err != nil {
+		t.Fatalf("GuestPrincipal() error = %v", err)
+	}
+	if principal.Role != auth.RoleGuest || !strings.HasPrefix(principal.Subject, guestOwnerPrefix) || strings.Contains(principal.Subject, guestCart.CartToken) {
+		t.Errorf("GuestPrincipal() = %+v, want a guest owner that does not hold the token", principal)
+	}
+
+	for _, cartToken := range []string{"", "not-a-token", guestCart.CartToken[:20], guestCart.CartToken + "=="} {
+		if _, err := GuestPrincipal(cartToken); err != ErrInvalidCartToken {
+			t.Errorf("GuestPrincipal(%q) error = %v, want %v", cartToken, err, ErrInvalidCartToken)
+		}
+	}
+}
+
+func Test_cartHandler_mergeItems(t *testing.T) {
+	maxPurchasable := &entities.ProductCollection{ID: 2, MaxPurchasableStock: 5}
+	products := map[uint]*entities.ProductCollection{1: {ID: 1}, 2: maxPurchasable, 3: {ID: 3}}
+
+	tests := []struct {
+		name          string
+		mergeStrategy string
+		items         []CartItem
+		guestItems    []CartItem
+		want          []CartItem
+	}{
+		{
+			name:          "Sum quantities",
+			mergeStrategy: MergeSum,
+			items:         []CartItem{{ProductID: 1, Quantity: 2}},
+			guestItems:    []CartItem{{ProductID: 1, Quantity: 3}, {ProductID: 3, Quantity: 1}},
+			want:          []CartItem{{ProductID: 1, Quantity: 5}, {ProductID: 3, Quantity: 1}},
+		},
+		{
+			name:          "Keep latest quantity",
+			mergeStrategy: MergeLatest,
+			items:         []CartItem{{ProductID: 1, Quantity: 2}},
+			guestItems:    []CartItem{{ProductID: 1, Quantity: 1}},
+			want:          []CartItem{{ProductID: 1, Quantity: 1}},
+		},
+		{
+			name:          "Sum above the limit keeps the larger quantity",
+			mergeStrategy: MergeSum,
+			items:         []CartItem{{ProductID: 2, Quantity: 3}},
+			guestItems:    []CartItem{{ProductID: 2, Quantity: 4}},
+			want:          []CartItem{{ProductID: 2, Quantity: 4}},
+		},
+		{
+			name:          "Product removed from the catalog",
+			mergeStrategy: MergeSum,
+			items:         []CartItem{{ProductID: 1, Quantity: 2}},
+			guestItems:    []CartItem{{ProductID: 99, Quantity: 1}},
+			want:          []CartItem{{ProductID: 1, Quantity: 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cartHandler{mergeStrategy: tt.mergeStrategy}
+			if got := c.mergeItems(tt.items, tt.guestItems, products); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeItems() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cartHandler_MergeGuestCart(t *testing.T) {
+	fakeDB := newFakeCartDB()
+	c := NewManager(fakeDB, NewMemoryStore(time.Hour, time.Hour), promotions.NewManager(fakeDB))
+
+	guestCart, err := c.CreateGuestCart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	principal, _ := GuestPrincipal(guestCart.CartToken)
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":1,"quantity":3}`, principal.Subject)); err != nil {
+		t.Fatalf("AddToCart() as guest error = %v", err)
+	}
+	if _, err := c.AddToCart(newCartRequest("POST", "/api/carts", `{"id":1,"quantity":2}`, "1")); err != nil {
+		t.Fatalf("AddToCart() error = %v", err)
+	}
+
+	// Logging in twice with the same token must not add the guest items twice
+	for attempt := 0; attempt < 2; attempt++ {
+		if err := c.MergeGuestCart(guestCart.CartToken, "1"); err != nil {
+			t.Fatalf("MergeGuestCart() attempt %v error = %v", attempt, err)
+		}
+	}
+
+	summary, err := c.GetAllCarts(newCartRequest("GET", "/api/carts", "", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Items) != 1 || summary.Items[0].Quantity != 5 {
+		t.Errorf("GetAllCarts() after merge = %+v, want quantity 5", summary.Items)
+	}
+
+	if err := c.MergeGuestCart("not-a-token", "1"); err != ErrInvalidCartToken {
+		t.Errorf("MergeGuestCart() error = %v, want %v", err, ErrInvalidCartToken)
+	}
+}
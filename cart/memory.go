@@ -0,0 +1,92 @@
+package cart
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+type memoryStore struct {
+	cache *gocache.Cache
+	// idempotencyKeys holds the applied keys by owner and key
+	idempotencyKeys *gocache.Cache
+	// locks serializes updates, a cart always maps to the same lock
+	locks [64]sync.Mutex
+}
+
+// NewMemoryStore keeps carts in the process, they are lost on restart and not
+// shared between instances. A cart expires after ttl without activity and an
+// applied idempotency key after idempotencyTTL.
+func NewMemoryStore(ttl, idempotencyTTL time.Duration) CartStore {
+	return &memoryStore{
+		cache:           gocache.New(ttl, time.Minute*10),
+		idempotencyKeys: gocache.New(idempotencyTTL, time.Minute*10),
+	}
+}
+
+func (m *memoryStore) Get(owner string) ([]CartItem, error) {
+	lock := m.lock(owner)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return m.get(owner), nil
+}
+
+func (m *memoryStore) Update(owner string, update func(items []CartItem) ([]CartItem, error)) error {
+	_, err := m.UpdateOnce(owner, "", update)
+	return err
+}
+
+func (m *memoryStore) UpdateOnce(owner, idempotencyKey string, update func(items []CartItem) ([]CartItem, error)) (bool, error) {
+	lock := m.lock(owner)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The owner can not contain a NUL byte, it is a subject or a username
+	key := owner + "\x00" + idempotencyKey
+	if idempotencyKey != "" {
+		if _, applied := m.idempotencyKeys.Get(key); applied {
+			return false, nil
+		}
+	}
+
+	items, err := update(m.get(owner))
+	if err != nil {
+		return false, err
+	}
+
+	if idempotencyKey != "" {
+		m.idempotencyKeys.Set(key, true, gocache.DefaultExpiration)
+	}
+
+	if len(items) == 0 {
+		m.cache.Delete(owner)
+		return true, nil
+	}
+
+	m.cache.Set(owner, append([]CartItem{}, items...), gocache.DefaultExpiration)
+
+	return true, nil
+}
+
+// get returns a copy of the items so callers never modify the cached slice
+func (m *memoryStore) get(owner string) []CartItem {
+	data, ok := m.cache.Get(owner)
+	if !ok {
+		return []CartItem{}
+	}
+
+	items := data.([]CartItem)
+	m.cache.Set(owner, items, gocache.DefaultExpiration)
+
+	return append([]CartItem{}, items...)
+}
+
+func (m *memoryStore) lock(owner string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(owner))
+
+	return &m.locks[h.Sum32()%uint32(len(m.locks))]
+}
@@ -0,0 +1,90 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/product"
+)
+
+var errTooPrecise = errors.New("Quantity has too many decimals")
+
+// parseQuantity converts a requested quantity to the quantity kept in the cart: a whole
+// count for count based products and grams for products sold by weight, which accept
+// decimal quantities in kg or g and default to the unit they are priced per
+func (c *cartHandler) parseQuantity(productCol *entities.ProductCollection, quantity json.Number, unit string) (int, error) {
+	if !productCol.SoldByWeight {
+		if unit != "" {
+			return 0, fmt.Errorf("%v is not sold by weight, its quantity has no unit", productCol.Name)
+		}
+
+		count, err := parseDecimal(quantity.String(), 0)
+		if err == errTooPrecise {
+			return 0, &QuantityError{ProductID: productCol.ID, ProductName: productCol.Name, Limit: LimitWholeQuantity, Min: 1}
+		}
+
+		return count, err
+	}
+
+	if unit == "" {
+		unit = product.WeightUnit(productCol)
+	}
+
+	var decimals int
+	switch unit {
+	case product.UnitKilogram:
+		decimals = 3
+	case product.UnitGram:
+		decimals = 0
+	default:
+		return 0, fmt.Errorf("Unit of %v must be %v or %v", productCol.Name, product.UnitKilogram, product.UnitGram)
+	}
+
+	grams, err := parseDecimal(quantity.String(), decimals)
+	if err == errTooPrecise {
+		return 0, &QuantityError{
+			ProductID:   productCol.ID,
+			ProductName: productCol.Name,
+			Limit:       LimitWeightIncrement,
+			Unit:        product.UnitGram,
+			Min:         weightIncrement(productCol),
+		}
+	}
+
+	return grams, err
+}
+
+// parseDecimal reads value scaled by 10^decimals, "1.25" with 3 decimals is 1250
+func parseDecimal(value string, decimals int) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	whole, fraction := value, ""
+	if i := strings.Index(value, "."); i >= 0 {
+		whole, fraction = value[:i], strings.TrimRight(value[i+1:], "0")
+	}
+
+	if len(fraction) > decimals {
+		return 0, errTooPrecise
+	}
+
+	scaled, err := strconv.ParseInt(whole+fraction+strings.Repeat("0", decimals-len(fraction)), 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid quantity:%v", value)
+	}
+
+	return int(scaled), nil
+}
+
+func weightIncrement(productCol *entities.ProductCollection) int {
+	if productCol.WeightIncrement > 0 {
+		return productCol.WeightIncrement
+	}
+
+	return 1
+}
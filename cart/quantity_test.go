@@ -0,0 +1,56 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+func Test_cartHandler_parseQuantity(t *testing.T) {
+	counted := &entities.ProductCollection{ID: 1, Name: "Meiji Fresh Milk"}
+	weighed := &entities.ProductCollection{ID: 2, Name: "Minced Beef", SoldByWeight: true, WeightUnit: "kg", WeightIncrement: 100}
+
+	tests := []struct {
+		name      string
+		product   *entities.ProductCollection
+		quantity  string
+		unit      string
+		want      int
+		wantLimit string
+		wantErr   bool
+	}{
+		{name: "Count", product: counted, quantity: "3", want: 3},
+		{name: "Count with trailing zeros", product: counted, quantity: "3.00", want: 3},
+		{name: "Fractional count", product: counted, quantity: "1.5", wantLimit: LimitWholeQuantity},
+		{name: "Count with a unit", product: counted, quantity: "1", unit: "kg", wantErr: true},
+		{name: "Missing quantity", product: counted, quantity: "", want: 0},
+		{name: "Kilograms by default", product: weighed, quantity: "1.25", want: 1250},
+		{name: "Grams", product: weighed, quantity: "300", unit: "g", want: 300},
+		{name: "Fractional grams", product: weighed, quantity: "300.5", unit: "g", wantLimit: LimitWeightIncrement},
+		{name: "Below a gram", product: weighed, quantity: "0.0005", wantLimit: LimitWeightIncrement},
+		{name: "Unknown unit", product: weighed, quantity: "1", unit: "lb", wantErr: true},
+		{name: "Exponent", product: weighed, quantity: "1e3", unit: "g", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cartHandler{}
+			got, err := c.parseQuantity(tt.product, json.Number(tt.quantity), tt.unit)
+
+			var quantityErr *QuantityError
+			if tt.wantLimit != "" {
+				if !errors.As(err, &quantityErr) || quantityErr.Limit != tt.wantLimit {
+					t.Errorf("parseQuantity() error = %v, want limit %v", err, tt.wantLimit)
+				}
+				return
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseQuantity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseQuantity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package cart
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type redisStore struct {
+	pool           *redis.Pool
+	ttl            time.Duration
+	idempotencyTTL time.Duration
+}
+
+const (
+	redisKeyPrefix = "minimart:cart:"
+	// redisIdempotencyKeyPrefix is followed by the owner and the key, the length of
+	// the owner comes first so owners and keys containing ":" can not collide
+	redisIdempotencyKeyPrefix = "minimart:cart-idempotency:"
+	// redisMaxAttempts bounds the retries of Update, every conflict means
+	// another update of the same cart succeeded
+	redisMaxAttempts = 50
+)
+
+// NewRedisPool connects to the Redis compatible server at url, e.g. redis://:password@host:6379/0
+func NewRedisPool(url string) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(url)
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+}
+
+// NewRedisStore keeps each cart as a JSON document under its own key. The key
+// expires after ttl, every read or write of the cart starts the ttl again. Applied
+// idempotency keys are kept under their own key for idempotencyTTL.
+func NewRedisStore(pool *redis.Pool, ttl, idempotencyTTL time.Duration) CartStore {
+	return &redisStore{pool: pool, ttl: ttl, idempotencyTTL: idempotencyTTL}
+}
+
+func (s *redisStore) Get(owner string) ([]CartItem, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	key := redisKeyPrefix + owner
+
+	conn.Send("MULTI")
+	conn.Send("GET", key)
+	conn.Send("PEXPIRE", key, s.ttl.Milliseconds())
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get cart of owner:%v due to: %v", owner, err)
+	}
+
+	return s.decode(owner, values[0])
+}
+
+func (s *redisStore) Update(owner string, update func(items []CartItem) ([]CartItem, error)) error {
+	_, err := s.UpdateOnce(owner, "", update)
+	return err
+}
+
+// UpdateOnce watches the cart and idempotency keys and retries when another client
+// changed the cart between reading it and writing the result
+func (s *redisStore) UpdateOnce(owner, idempotencyKey string, update func(items []CartItem) ([]CartItem, error)) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	key := redisKeyPrefix + owner
+	watched := []interface{}{key}
+	appliedKey := ""
+	if idempotencyKey != "" {
+		appliedKey = fmt.Sprintf("%v%d:%v:%v", redisIdempotencyKeyPrefix, len(owner), owner, idempotencyKey)
+		watched = append(watched, appliedKey)
+	}
+
+	for attempt := 0; attempt < redisMaxAttempts; attempt++ {
+		if _, err := conn.Do("WATCH", watched...); err != nil {
+			return false, fmt.Errorf("Unable to update cart of owner:%v due to: %v", owner, err)
+		}
+
+		if appliedKey != "" {
+			applied, err := redis.Bool(conn.Do("EXISTS", appliedKey))
+			if err != nil || applied {
+				conn.Do("UNWATCH")
+				if err != nil {
+					return false, fmt.Errorf("Unable to update cart of owner:%v due to: %v", owner, err)
+				}
+				return false, nil
+			}
+		}
+
+		reply, err := conn.Do("GET", key)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return false, fmt.Errorf("Unable to get cart of owner:%v due to: %v", owner, err)
+		}
+
+		items, err := s.decode(owner, reply)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return false, err
+		}
+
+		items, err = update(items)
+		if err != nil {
+			conn.Do("UNWATCH")
+			return false, err
+		}
+
+		conn.Send("MULTI")
+		if len(items) == 0 {
+			conn.Send("DEL", key)
+		} else {
+			bytesData, err := json.Marshal(items)
+			if err != nil {
+				conn.Do("DISCARD")
+				return false, err
+			}
+			conn.Send("SET", key, bytesData, "PX", s.ttl.Milliseconds())
+		}
+		if appliedKey != "" {
+			conn.Send("SET", appliedKey, 1, "PX", s.idempotencyTTL.Milliseconds())
+		}
+
+		// The transaction is aborted when a watched key changed, Redis then
+		// replies with a nil array and some compatible servers with an empty one
+		values, err := redis.Values(conn.Do("EXEC"))
+		if err != nil && err != redis.ErrNil {
+			return false, fmt.Errorf("Unable to update cart of owner:%v due to: %v", owner, err)
+		}
+
+		if len(values) > 0 {
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("Unable to update cart of owner:%v due to concurrent updates", owner)
+}
+
+func (s *redisStore) decode(owner string, reply interface{}) ([]CartItem, error) {
+	items := []CartItem{}
+	if reply == nil {
+		return items, nil
+	}
+
+	bytesData, err := redis.Bytes(reply, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get cart of owner:%v due to: %v", owner, err)
+	}
+
+	if err := json.Unmarshal(bytesData, &items); err != nil {
+		return nil, fmt.Errorf("Unable to parse cart of owner:%v due to: %v", owner, err)
+	}
+
+	return items, nil
+}
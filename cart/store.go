@@ -0,0 +1,99 @@
+package cart
+
+import (
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/money"
+)
+
+type (
+	// CartStore persists the items of each cart by owner
+	CartStore interface {
+		// Get returns the items of the cart of owner, it is empty when owner has no cart
+		Get(owner string) ([]CartItem, error)
+		// Update replaces the items of the cart of owner with the result of update.
+		// Concurrent updates of the same cart are applied one after another so none
+		// is lost, an error returned by update leaves the cart unchanged and an
+		// empty result deletes the cart.
+		Update(owner string, update func(items []CartItem) ([]CartItem, error)) error
+		// UpdateOnce is Update skipped when idempotencyKey was already applied to the
+		// cart of owner, it reports whether update was applied
+		UpdateOnce(owner, idempotencyKey string, update func(items []CartItem) ([]CartItem, error)) (bool, error)
+	}
+
+	// orderStore is a CartStore that saves an order in the transaction emptying the cart,
+	// place builds the order from the items of the cart of owner. The order already placed
+	// with idempotencyKey is returned instead when there is one.
+	orderStore interface {
+		PlaceOrder(owner, idempotencyKey string, place func(items []CartItem) (*entities.Order, error)) (*entities.Order, error)
+	}
+
+	// CartItem is a product in a cart, AddedPrice is the unit price the shopper saw when
+	// the quantity was last changed and is zero for items stored before it was recorded
+	CartItem struct {
+		ProductID  uint        `json:"product_id"`
+		Quantity   int         `json:"quantity"`
+		AddedPrice money.Cents `json:"added_price"`
+	}
+
+	sqlStore struct {
+		dbManager db.Manager
+	}
+)
+
+// NewSQLStore keeps carts in the carts and cart_items tables so they survive
+// restarts and are shared by every instance behind a load balancer
+func NewSQLStore(dbManager db.Manager) CartStore {
+	return &sqlStore{dbManager}
+}
+
+func (s *sqlStore) Get(owner string) ([]CartItem, error) {
+	cartItems, err := s.dbManager.GetCartItems(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []CartItem{}
+	for _, cartItem := range *cartItems {
+		items = append(items, CartItem{ProductID: cartItem.ProductID, Quantity: cartItem.Quantity, AddedPrice: cartItem.AddedPrice})
+	}
+
+	return items, nil
+}
+
+func (s *sqlStore) Update(owner string, update func(items []CartItem) ([]CartItem, error)) error {
+	_, err := s.UpdateOnce(owner, "", update)
+	return err
+}
+
+func (s *sqlStore) UpdateOnce(owner, idempotencyKey string, update func(items []CartItem) ([]CartItem, error)) (bool, error) {
+	return s.dbManager.UpdateCartItems(owner, idempotencyKey, func(cartItems []entities.CartItem) ([]entities.CartItem, error) {
+		items := []CartItem{}
+		for _, cartItem := range cartItems {
+			items = append(items, CartItem{ProductID: cartItem.ProductID, Quantity: cartItem.Quantity, AddedPrice: cartItem.AddedPrice})
+		}
+
+		items, err := update(items)
+		if err != nil {
+			return nil, err
+		}
+
+		cartItems = []entities.CartItem{}
+		for _, item := range items {
+			cartItems = append(cartItems, entities.CartItem{ProductID: item.ProductID, Quantity: item.Quantity, AddedPrice: item.AddedPrice})
+		}
+
+		return cartItems, nil
+	})
+}
+
+func (s *sqlStore) PlaceOrder(owner, idempotencyKey string, place func(items []CartItem) (*entities.Order, error)) (*entities.Order, error) {
+	return s.dbManager.PlaceCartOrder(owner, idempotencyKey, func(cartItems []entities.CartItem) (*entities.Order, error) {
+		items := []CartItem{}
+		for _, cartItem := range cartItems {
+			items = append(items, CartItem{ProductID: cartItem.ProductID, Quantity: cartItem.Quantity, AddedPrice: cartItem.AddedPrice})
+		}
+
+		return place(items)
+	})
+}
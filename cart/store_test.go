@@ -0,0 +1,218 @@
+package cart
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+)
+
+// newTestRedisPool uses the server of REDIS_TEST_URL when set, otherwise miniredis
+func newTestRedisPool(t *testing.T) (*miniredis.Miniredis, func()) {
+	if url := os.Getenv("REDIS_TEST_URL"); url != "" {
+		pool := NewRedisPool(url)
+		return nil, func() { pool.Close() }
+	}
+
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return server, server.Close
+}
+
+func testRedisURL(server *miniredis.Miniredis) string {
+	if server == nil {
+		return os.Getenv("REDIS_TEST_URL")
+	}
+
+	return "redis://" + server.Addr()
+}
+
+func TestCartStore(t *testing.T) {
+	server, closeServer := newTestRedisPool(t)
+	defer closeServer()
+
+	redisPool := NewRedisPool(testRedisURL(server))
+	defer redisPool.Close()
+
+	fakeDB := newFakeCartDB()
+
+	tests := []struct {
+		name  string
+		store CartStore
+	}{
+		{
+			name:  "memory",
+			store: NewMemoryStore(time.Hour, time.Hour),
+		},
+		{
+			name:  "sql",
+			store: NewSQLStore(fakeDB),
+		},
+		{
+			name:  "redis",
+			store: NewRedisStore(redisPool, time.Hour, time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := "store-test-" + tt.name
+
+			items, err := tt.store.Get(owner)
+			if err != nil || len(items) != 0 {
+				t.Fatalf("Get() of missing cart = %v, %v", items, err)
+			}
+
+			want := []CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 7, Quantity: 1}}
+			err = tt.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+				return append(items, want...), nil
+			})
+			if err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+
+			err = tt.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+				return nil, errors.New("rejected")
+			})
+			if err == nil || err.Error() != "rejected" {
+				t.Errorf("Update() error = %v, want the error of update", err)
+			}
+
+			got, err := tt.store.Get(owner)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Get() = %v, want %v", got, want)
+			}
+
+			got[0].Quantity = 99
+			if again, _ := tt.store.Get(owner); again[0].Quantity != 2 {
+				t.Errorf("Get() returned items shared with the store")
+			}
+
+			err = tt.store.Update(owner, func(items []CartItem) ([]CartItem, error) {
+				return []CartItem{}, nil
+			})
+			if err != nil {
+				t.Fatalf("Update() to empty cart error = %v", err)
+			}
+
+			if items, _ := tt.store.Get(owner); len(items) != 0 {
+				t.Errorf("Get() of emptied cart = %v", items)
+			}
+		})
+	}
+}
+
+func Test_redisStore_ttl(t *testing.T) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	pool := NewRedisPool("redis://" + server.Addr())
+	defer pool.Close()
+
+	store := NewRedisStore(pool, time.Hour, time.Hour)
+	key := redisKeyPrefix + "1"
+
+	err = store.Update("1", func(items []CartItem) ([]CartItem, error) {
+		return []CartItem{{ProductID: 1, Quantity: 1}}, nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	server.FastForward(45 * time.Minute)
+	if _, err := store.Get("1"); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+
+	if ttl := server.TTL(key); ttl != time.Hour {
+		t.Errorf("Get() did not refresh the ttl, ttl = %v", ttl)
+	}
+
+	server.FastForward(45 * time.Minute)
+	if !server.Exists(key) {
+		t.Errorf("Cart expired although it was active")
+	}
+
+	server.FastForward(30 * time.Minute)
+	if server.Exists(key) {
+		t.Errorf("Cart did not expire after an hour without activity")
+	}
+}
+
+func TestCartStore_UpdateOnce(t *testing.T) {
+	server, closeServer := newTestRedisPool(t)
+	defer closeServer()
+
+	redisPool := NewRedisPool(testRedisURL(server))
+	defer redisPool.Close()
+
+	fakeDB := newFakeCartDB()
+
+	tests := []struct {
+		name  string
+		store CartStore
+	}{
+		{
+			name:  "memory",
+			store: NewMemoryStore(time.Hour, time.Hour),
+		},
+		{
+			name:  "sql",
+			store: NewSQLStore(fakeDB),
+		},
+		{
+			name:  "redis",
+			store: NewRedisStore(redisPool, time.Hour, time.Hour),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner := "once-test-" + tt.name
+			addOne := func(items []CartItem) ([]CartItem, error) {
+				if len(items) == 0 {
+					return []CartItem{{ProductID: 1, Quantity: 1}}, nil
+				}
+				items[0].Quantity++
+				return items, nil
+			}
+
+			if _, err := tt.store.UpdateOnce(owner, "key-1", func(items []CartItem) ([]CartItem, error) {
+				return nil, errors.New("rejected")
+			}); err == nil {
+				t.Fatalf("UpdateOnce() error = %v, want the error of update", err)
+			}
+
+			for attempt := 0; attempt < 3; attempt++ {
+				applied, err := tt.store.UpdateOnce(owner, "key-1", addOne)
+				if err != nil {
+					t.Fatalf("UpdateOnce() error = %v", err)
+				}
+				if applied != (attempt == 0) {
+					t.Errorf("UpdateOnce() attempt %v applied = %v", attempt, applied)
+				}
+			}
+
+			if applied, _ := tt.store.UpdateOnce(owner, "key-2", addOne); !applied {
+				t.Errorf("UpdateOnce() skipped a new key")
+			}
+			if applied, _ := tt.store.UpdateOnce(owner+"-other", "key-1", addOne); !applied {
+				t.Errorf("UpdateOnce() skipped a key of another owner")
+			}
+
+			if items, _ := tt.store.Get(owner); len(items) != 1 || items[0].Quantity != 2 {
+				t.Errorf("Get() = %v, want quantity 2", items)
+			}
+		})
+	}
+}
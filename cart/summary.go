@@ -0,0 +1,59 @@
+package cart
+
+import (
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+type (
+	// CartSummary is the priced cart, every amount is computed in whole cents. HasChanges
+	// tells the shopper to review the lines flagged since they were added.
+	CartSummary struct {
+		Items         []CartCollection      `json:"items"`
+		HasChanges    bool                  `json:"has_changes"`
+		Discounts     []promotions.Discount `json:"discounts"`
+		ItemCount     int                   `json:"item_count"`
+		Subtotal      money.Cents           `json:"subtotal"`
+		DiscountTotal money.Cents           `json:"discount_total"`
+		Tax           money.Cents           `json:"tax"`
+		Total         money.Cents           `json:"total"`
+	}
+)
+
+// summarize adds up the lines, discounts never take the subtotal below zero
+// and tax is charged on the discounted subtotal
+func summarize(items []CartCollection, discounts []promotions.Discount, taxRate int64) *CartSummary {
+	summary := &CartSummary{Items: items, Discounts: discounts}
+
+	for _, item := range items {
+		if item.PriceChanged || (item.Availability != "" && item.Availability != AvailabilityAvailable) {
+			summary.HasChanges = true
+		}
+
+		if !item.purchasable() {
+			continue
+		}
+
+		if item.Unit == "" {
+			summary.ItemCount += item.Quantity
+		} else {
+			// Products sold by weight count as one item whatever they weigh
+			summary.ItemCount++
+		}
+		summary.Subtotal += item.Subtotal
+	}
+
+	for _, discount := range discounts {
+		summary.DiscountTotal += discount.Amount
+	}
+
+	if summary.DiscountTotal > summary.Subtotal {
+		summary.DiscountTotal = summary.Subtotal
+	}
+
+	taxable := summary.Subtotal - summary.DiscountTotal
+	summary.Tax = taxable.Percent(taxRate)
+	summary.Total = taxable + summary.Tax
+
+	return summary
+}
@@ -0,0 +1,68 @@
+package cart
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+func Test_summarize(t *testing.T) {
+	line := func(price money.Cents, quantity int) CartCollection {
+		data := CartCollection{Quantity: quantity, Subtotal: price.Times(quantity)}
+		data.SalesPrice = price
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		items     []CartCollection
+		discounts []promotions.Discount
+		taxRate   int64
+		want      CartSummary
+	}{
+		{
+			name: "Empty cart",
+			want: CartSummary{},
+		},
+		{
+			name:  "Lines without tax",
+			items: []CartCollection{line(580, 3), line(199, 1)},
+			want:  CartSummary{ItemCount: 4, Subtotal: 1939, Total: 1939},
+		},
+		{
+			name:      "Tax on the discounted subtotal",
+			items:     []CartCollection{line(635, 2)},
+			discounts: []promotions.Discount{{Description: "Buy 1 Meiji Fresh Milk - Regular @ $0.55 Off", Amount: 110}},
+			taxRate:   700,
+			want:      CartSummary{ItemCount: 2, Subtotal: 1270, DiscountTotal: 110, Tax: 81, Total: 1241},
+		},
+		{
+			name: "Lines that can not be bought are flagged and left out",
+			items: []CartCollection{
+				line(580, 3),
+				{Quantity: 2, Availability: AvailabilityOutOfStock},
+				{Quantity: 1, Availability: AvailabilityUnavailable},
+			},
+			want: CartSummary{HasChanges: true, ItemCount: 3, Subtotal: 1740, Total: 1740},
+		},
+		{
+			name:      "Discounts never exceed the subtotal",
+			items:     []CartCollection{line(100, 1)},
+			discounts: []promotions.Discount{{Description: "Free", Amount: 150}},
+			taxRate:   700,
+			want:      CartSummary{ItemCount: 1, Subtotal: 100, DiscountTotal: 100, Total: 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarize(tt.items, tt.discounts, tt.taxRate)
+			got.Items, got.Discounts = nil, nil
+
+			if !reflect.DeepEqual(*got, tt.want) {
+				t.Errorf("summarize() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package cart
+
+import (
+	"fmt"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/product"
+)
+
+const (
+	LimitMinimumQuantity = "minimum_quantity"
+	LimitWholeQuantity   = "whole_quantity"
+	LimitWeightIncrement = "weight_increment"
+	LimitStock           = "stock"
+	LimitMaxPurchasable  = "max_purchasable_stock"
+	LimitBulkOrder       = "bulk_order_threshold"
+)
+
+// QuantityError is returned when a cart quantity violates a limit of the product,
+// Min or Max is the quantity the limit allows, Max is 0 when the product is out of stock.
+// Quantities of products sold by weight are in Unit, which is always g
+type QuantityError struct {
+	ProductID   uint   `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Quantity    int    `json:"quantity"`
+	Unit        string `json:"unit,omitempty"`
+	Limit       string `json:"limit"`
+	Min         int    `json:"min,omitempty"`
+	Max         *int   `json:"max,omitempty"`
+}
+
+func (e *QuantityError) Error() string {
+	switch e.Limit {
+	case LimitMinimumQuantity:
+		return fmt.Sprintf("Quantity of %v must be at least %v", e.ProductName, e.amount(e.Min))
+	case LimitWholeQuantity:
+		return fmt.Sprintf("Quantity of %v must be a whole number", e.ProductName)
+	case LimitWeightIncrement:
+		return fmt.Sprintf("Quantity of %v must be a multiple of %v", e.ProductName, e.amount(e.Min))
+	case LimitStock:
+		if *e.Max == 0 {
+			return fmt.Sprintf("%v is out of stock", e.ProductName)
+		}
+		return fmt.Sprintf("Only %v of %v are in stock", e.amount(*e.Max), e.ProductName)
+	case LimitMaxPurchasable:
+		return fmt.Sprintf("At most %v of %v can be purchased", e.amount(*e.Max), e.ProductName)
+	case LimitBulkOrder:
+		return fmt.Sprintf("More than %v of %v is a bulk order", e.amount(*e.Max), e.ProductName)
+	}
+
+	return fmt.Sprintf("Invalid quantity:%v of %v", e.amount(e.Quantity), e.ProductName)
+}
+
+func (e *QuantityError) amount(quantity int) string {
+	if e.Unit == "" {
+		return fmt.Sprint(quantity)
+	}
+
+	return fmt.Sprintf("%v %v", quantity, e.Unit)
+}
+
+// validateQuantity checks quantity against the limits of productCol, products sold by weight
+// are added in steps of their weight increment. When several limits are exceeded the error
+// names the one allowing the smallest quantity
+func (c *cartHandler) validateQuantity(productCol *entities.ProductCollection, quantity int) error {
+	quantityErr := &QuantityError{ProductID: productCol.ID, ProductName: productCol.Name, Quantity: quantity}
+
+	minimum := 1
+	if productCol.SoldByWeight {
+		quantityErr.Unit, minimum = product.UnitGram, weightIncrement(productCol)
+	}
+
+	if quantity < minimum {
+		quantityErr.Limit, quantityErr.Min = LimitMinimumQuantity, minimum
+		return quantityErr
+	}
+
+	if quantity%minimum != 0 {
+		quantityErr.Limit, quantityErr.Min = LimitWeightIncrement, minimum
+		return quantityErr
+	}
+
+	exceeds := func(limit string, max int) {
+		if quantity > max && (quantityErr.Max == nil || max < *quantityErr.Max) {
+			quantityErr.Limit, quantityErr.Max = limit, &max
+		}
+	}
+
+	if !productCol.UnlimitedStock && productCol.Stock != nil {
+		exceeds(LimitStock, *productCol.Stock)
+	}
+	if productCol.MaxPurchasableStock > 0 {
+		exceeds(LimitMaxPurchasable, productCol.MaxPurchasableStock)
+	}
+	if productCol.BulkOrderThreshold > 0 {
+		exceeds(LimitBulkOrder, productCol.BulkOrderThreshold)
+	}
+
+	if quantityErr.Limit != "" {
+		return quantityErr
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package cart
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+func Test_cartHandler_validateQuantity(t *testing.T) {
+	stock := func(quantity int) *int { return &quantity }
+
+	tests := []struct {
+		name      string
+		product   entities.ProductCollection
+		quantity  int
+		wantLimit string
+		wantMax   int
+		wantMsg   string
+	}{
+		{
+			name:     "Within every limit",
+			product:  entities.ProductCollection{Stock: stock(441), BulkOrderThreshold: 30},
+			quantity: 30,
+		},
+		{
+			name:      "Zero quantity",
+			product:   entities.ProductCollection{Name: "Meiji Fresh Milk", Stock: stock(441)},
+			quantity:  0,
+			wantLimit: LimitMinimumQuantity,
+			wantMsg:   "Quantity of Meiji Fresh Milk must be at least 1",
+		},
+		{
+			name:      "Negative quantity",
+			product:   entities.ProductCollection{Stock: stock(441)},
+			quantity:  -2,
+			wantLimit: LimitMinimumQuantity,
+		},
+		{
+			name:      "More than in stock",
+			product:   entities.ProductCollection{Name: "Meiji Fresh Milk", Stock: stock(13), BulkOrderThreshold: 30},
+			quantity:  40,
+			wantLimit: LimitStock,
+			wantMax:   13,
+			wantMsg:   "Only 13 of Meiji Fresh Milk are in stock",
+		},
+		{
+			name:      "Out of stock",
+			product:   entities.ProductCollection{Name: "Meiji Fresh Milk", Stock: stock(0)},
+			quantity:  1,
+			wantLimit: LimitStock,
+			wantMax:   0,
+			wantMsg:   "Meiji Fresh Milk is out of stock",
+		},
+		{
+			name:     "Unlimited stock",
+			product:  entities.ProductCollection{Stock: stock(0), UnlimitedStock: true},
+			quantity: 500,
+		},
+		{
+			name:      "Max purchasable stock",
+			product:   entities.ProductCollection{Stock: stock(30), MaxPurchasableStock: 10},
+			quantity:  11,
+			wantLimit: LimitMaxPurchasable,
+			wantMax:   10,
+		},
+		{
+			name:      "Weight below the increment",
+			product:   entities.ProductCollection{Name: "Minced Beef", SoldByWeight: true, WeightIncrement: 100},
+			quantity:  50,
+			wantLimit: LimitMinimumQuantity,
+			wantMsg:   "Quantity of Minced Beef must be at least 100 g",
+		},
+		{
+			name:      "Weight between increments",
+			product:   entities.ProductCollection{Name: "Minced Beef", SoldByWeight: true, WeightIncrement: 100},
+			quantity:  1250,
+			wantLimit: LimitWeightIncrement,
+			wantMsg:   "Quantity of Minced Beef must be a multiple of 100 g",
+		},
+		{
+			name:      "Weight above the stock",
+			product:   entities.ProductCollection{Name: "Minced Beef", SoldByWeight: true, WeightIncrement: 100, Stock: stock(2000)},
+			quantity:  2100,
+			wantLimit: LimitStock,
+			wantMax:   2000,
+			wantMsg:   "Only 2000 g of Minced Beef are in stock",
+		},
+		{
+			name:      "Bulk order",
+			product:   entities.ProductCollection{Name: "Meiji Fresh Milk", Stock: stock(441), BulkOrderThreshold: 30},
+			quantity:  31,
+			wantLimit: LimitBulkOrder,
+			wantMax:   30,
+			wantMsg:   "More than 30 of Meiji Fresh Milk is a bulk order",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &cartHandler{}
+			err := c.validateQuantity(&tt.product, tt.quantity)
+			if tt.wantLimit == "" {
+				if err != nil {
+					t.Errorf("validateQuantity() error = %v", err)
+				}
+				return
+			}
+
+			var quantityErr *QuantityError
+			if !errors.As(err, &quantityErr) {
+				t.Fatalf("validateQuantity() error = %v, want a QuantityError", err)
+			}
+			if quantityErr.Limit != tt.wantLimit || (quantityErr.Max != nil && *quantityErr.Max != tt.wantMax) {
+				t.Errorf("validateQuantity() = %+v, want limit %v max %v", quantityErr, tt.wantLimit, tt.wantMax)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("validateQuantity() message = %v, want %v", err.Error(), tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestQuantityError_JSON(t *testing.T) {
+	max := 0
+	bytesData, err := json.Marshal(&QuantityError{ProductID: 198281, ProductName: "Meiji Fresh Milk", Quantity: 1, Limit: LimitStock, Max: &max})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"product_id":198281,"product_name":"Meiji Fresh Milk","quantity":1,"limit":"stock","max":0}`
+	if string(bytesData) != want {
+		t.Errorf("json.Marshal() = %s, want %s", bytesData, want)
+	}
+}
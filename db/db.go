@@ -2,6 +2,8 @@ package db
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/emanpicar/minimart-api/settings"
 
@@ -16,6 +18,45 @@ type (
 		BatchFirstOrCreate(prodCollection *[]entities.ProductCollection)
 		GetProductCollection() *[]entities.ProductCollection
 		GetProductByID(pID uint) (*entities.ProductCollection, error)
+		GetCredentialByUsername(username string) (*entities.Credential, error)
+		GetCredentialByID(id uint) (*entities.Credential, error)
+		CreateCredential(credential *entities.Credential) error
+		UpdateCredential(credential *entities.Credential) error
+		CreateRefreshToken(refreshToken *entities.RefreshToken) error
+		GetRefreshTokenByHash(tokenHash string) (*entities.RefreshToken, error)
+		RevokeRefreshToken(id uint) (bool, error)
+		RevokeRefreshTokenFamily(familyID string) error
+		RevokeCredentialRefreshTokens(credentialID uint) error
+		RevokeToken(jti string, expiresAt time.Time) error
+		IsTokenRevoked(jti string) bool
+		CreateAPIKey(apiKey *entities.APIKey) error
+		GetAPIKeyByPrefix(prefix string) (*entities.APIKey, error)
+		GetAPIKeys() *[]entities.APIKey
+		RevokeAPIKey(id uint) error
+		TouchAPIKey(id uint, usedAt time.Time)
+		GetCartItems(owner string) (*[]entities.CartItem, error)
+		UpdateCartItems(owner, idempotencyKey string, update func(items []entities.CartItem) ([]entities.CartItem, error)) (bool, error)
+		GetWishlists(owner string) (*[]entities.Wishlist, error)
+		GetWishlist(owner string, id uint) (*entities.Wishlist, error)
+		GetSavedForLater(owner string) (*entities.Wishlist, error)
+		CreateWishlist(wishlist *entities.Wishlist) error
+		RenameWishlist(owner string, id uint, name string) error
+		DeleteWishlist(owner string, id uint) error
+		SaveWishlistItem(item *entities.WishlistItem) error
+		DeleteWishlistItem(wishlistID, productID uint) error
+		CreateOrder(order *entities.Order) error
+		PlaceCartOrder(owner, idempotencyKey string, newOrder func(items []entities.CartItem) (*entities.Order, error)) (*entities.Order, error)
+		GetOrderByIdempotencyKey(owner, idempotencyKey string) (*entities.Order, error)
+		GetOrders(owner string) (*[]entities.Order, error)
+		GetOrder(owner string, id uint) (*entities.Order, error)
+		GetOrderByID(id uint) (*entities.Order, error)
+		TransitionOrder(transition *entities.OrderTransition, restock bool) error
+		CreatePayment(payment *entities.Payment) error
+		UpdatePayment(payment *entities.Payment) error
+		GetPaymentsByOrderID(orderID uint) (*[]entities.Payment, error)
+		GetPaymentByProviderID(provider, providerPaymentID string) (*entities.Payment, error)
+		BatchSavePromotions(promotions *[]entities.Promotion)
+		GetPromotionsByProductIDs(productIDs []uint) (*[]entities.Promotion, error)
 	}
 
 	dbHandler struct {
@@ -51,11 +92,66 @@ func (dbHandler *dbHandler) migrateTables() {
 	dbHandler.database.AutoMigrate(&entities.ProductOffers{}).AddForeignKey("product_id", "product_collections(id)", "CASCADE", "CASCADE")
 	dbHandler.database.AutoMigrate(&entities.ProductImages{}).AddForeignKey("product_id", "product_collections(id)", "CASCADE", "CASCADE")
 	dbHandler.database.AutoMigrate(&entities.Credential{})
+	// AutoMigrate keeps removed columns, the plaintext passwords stored before they were hashed are dropped
+	if dbHandler.database.Dialect().HasColumn(entities.Credential{}.TableName(), "password") {
+		dbHandler.database.Model(&entities.Credential{}).DropColumn("password")
+	}
+	dbHandler.database.AutoMigrate(&entities.RefreshToken{}).AddForeignKey("credential_id", "credentials(id)", "CASCADE", "CASCADE")
+	dbHandler.database.AutoMigrate(&entities.RevokedToken{})
+	dbHandler.database.AutoMigrate(&entities.APIKey{})
+	dbHandler.database.AutoMigrate(&entities.Promotion{})
+	dbHandler.database.AutoMigrate(&entities.PromotionProduct{}).
+		AddForeignKey("promotion_id", "promotions(id)", "CASCADE", "CASCADE").
+		AddForeignKey("product_id", "product_collections(id)", "CASCADE", "CASCADE")
+	dbHandler.database.AutoMigrate(&entities.Cart{})
+	dbHandler.database.AutoMigrate(&entities.CartIdempotencyKey{})
+	dbHandler.database.AutoMigrate(&entities.CartItem{}).
+		AddForeignKey("cart_id", "carts(id)", "CASCADE", "CASCADE").
+		AddForeignKey("product_id", "product_collections(id)", "CASCADE", "CASCADE")
+	dbHandler.database.AutoMigrate(&entities.Order{})
+	dbHandler.database.AutoMigrate(&entities.OrderLine{}).AddForeignKey("order_id", "orders(id)", "CASCADE", "CASCADE")
+	dbHandler.database.AutoMigrate(&entities.OrderDiscount{}).AddForeignKey("order_id", "orders(id)", "CASCADE", "CASCADE")
+	// Orders with history can not be deleted so the history is never lost
+	dbHandler.database.AutoMigrate(&entities.OrderTransition{}).AddForeignKey("order_id", "orders(id)", "RESTRICT", "CASCADE")
+	dbHandler.database.AutoMigrate(&entities.Payment{}).AddForeignKey("order_id", "orders(id)", "RESTRICT", "CASCADE")
+	dbHandler.database.AutoMigrate(&entities.Wishlist{})
+	dbHandler.database.AutoMigrate(&entities.WishlistItem{}).
+		AddForeignKey("wishlist_id", "wishlists(id)", "CASCADE", "CASCADE").
+		AddForeignKey("product_id", "product_collections(id)", "CASCADE", "CASCADE")
 }
 
 func (dbHandler *dbHandler) BatchFirstOrCreate(prodCollection *[]entities.ProductCollection) {
 	for _, product := range *prodCollection {
-		dbHandler.database.FirstOrCreate(&product, entities.ProductCollection{})
+		offers := product.Offers
+
+		// Base prices and purchase limits are assigned to products created before they were imported
+		dbHandler.database.Assign(map[string]interface{}{
+			"base_price":            product.BasePrice,
+			"unlimited_stock":       product.UnlimitedStock,
+			"max_purchasable_stock": product.MaxPurchasableStock,
+			"bulk_order_threshold":  product.BulkOrderThreshold,
+			"sold_by_weight":        product.SoldByWeight,
+			"weight_unit":           product.WeightUnit,
+			"weight_increment":      product.WeightIncrement,
+		}).FirstOrCreate(&product, entities.ProductCollection{})
+
+		// The stock is only imported once, afterwards it is kept by the database
+		dbHandler.database.Model(&entities.ProductCollection{}).
+			Where("id = ? AND stock IS NULL", product.ID).
+			Update("stock", product.Stock)
+
+		// Offers created before their validity window was imported are replaced once by the imported ones
+		replaced := dbHandler.database.Unscoped().
+			Where("product_id = ? AND (time_zone IS NULL OR time_zone = '')", product.ID).
+			Delete(&entities.ProductOffers{})
+		if replaced.RowsAffected == 0 {
+			continue
+		}
+
+		for _, offer := range offers {
+			offer.ProductID = product.ID
+			dbHandler.database.Create(&offer)
+		}
 	}
 }
 
@@ -76,3 +172,684 @@ func (dbHandler *dbHandler) GetProductByID(pID uint) (*entities.ProductCollectio
 
 	return &searchedData, nil
 }
+
+func (dbHandler *dbHandler) GetCredentialByUsername(username string) (*entities.Credential, error) {
+	searchedData := entities.Credential{}
+
+	// Usernames stored before they were lowercased are found regardless of case
+	err := dbHandler.database.Where("lower(username) = ?", strings.ToLower(username)).First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Credential with username:%v does not exist", username)
+	}
+
+	return &searchedData, nil
+}
+
+func (dbHandler *dbHandler) GetCredentialByID(id uint) (*entities.Credential, error) {
+	searchedData := entities.Credential{}
+
+	err := dbHandler.database.Where("id = ?", id).First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Credential with id:%v does not exist", id)
+	}
+
+	return &searchedData, nil
+}
+
+func (dbHandler *dbHandler) CreateCredential(credential *entities.Credential) error {
+	if err := dbHandler.database.Create(credential).Error; err != nil {
+		return fmt.Errorf("Unable to create credential for username:%v", credential.Username)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) UpdateCredential(credential *entities.Credential) error {
+	if err := dbHandler.database.Save(credential).Error; err != nil {
+		return fmt.Errorf("Unable to update credential with id:%v", credential.ID)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) CreateRefreshToken(refreshToken *entities.RefreshToken) error {
+	if err := dbHandler.database.Create(refreshToken).Error; err != nil {
+		return fmt.Errorf("Unable to create refresh token for credential id:%v", refreshToken.CredentialID)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) GetRefreshTokenByHash(tokenHash string) (*entities.RefreshToken, error) {
+	searchedData := entities.RefreshToken{}
+
+	err := dbHandler.database.Where("token_hash = ?", tokenHash).First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Refresh token does not exist")
+	}
+
+	return &searchedData, nil
+}
+
+// RevokeRefreshToken marks a single refresh token as revoked and reports
+// whether this call was the one that revoked it
+func (dbHandler *dbHandler) RevokeRefreshToken(id uint) (bool, error) {
+	result := dbHandler.database.Model(&entities.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, fmt.Errorf("Unable to revoke refresh token with id:%v", id)
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+func (dbHandler *dbHandler) RevokeRefreshTokenFamily(familyID string) error {
+	err := dbHandler.database.Model(&entities.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("Unable to revoke refresh token family:%v", familyID)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) RevokeCredentialRefreshTokens(credentialID uint) error {
+	err := dbHandler.database.Model(&entities.RefreshToken{}).
+		Where("credential_id = ? AND revoked_at IS NULL", credentialID).
+		Update("revoked_at", time.Now()).Error
+	if err != nil {
+		return fmt.Errorf("Unable to revoke refresh tokens of credential id:%v", credentialID)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) RevokeToken(jti string, expiresAt time.Time) error {
+	// Entries past their expiry are useless since the token is rejected anyway
+	dbHandler.database.Where("expires_at < ?", time.Now()).Delete(&entities.RevokedToken{})
+
+	revokedToken := entities.RevokedToken{}
+	err := dbHandler.database.Where(entities.RevokedToken{JTI: jti}).Attrs(entities.RevokedToken{ExpiresAt: expiresAt}).FirstOrCreate(&revokedToken).Error
+	if err != nil {
+		return fmt.Errorf("Unable to revoke token:%v", jti)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked fails closed, a token is treated as revoked when the lookup itself fails
+func (dbHandler *dbHandler) IsTokenRevoked(jti string) bool {
+	var count int
+	if err := dbHandler.database.Model(&entities.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		logger.Log.Errorf("Unable to check revocation of token:%v due to: %v", jti, err)
+		return true
+	}
+
+	return count > 0
+}
+
+func (dbHandler *dbHandler) CreateAPIKey(apiKey *entities.APIKey) error {
+	if err := dbHandler.database.Create(apiKey).Error; err != nil {
+		return fmt.Errorf("Unable to create API key:%v", apiKey.Name)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) GetAPIKeyByPrefix(prefix string) (*entities.APIKey, error) {
+	searchedData := entities.APIKey{}
+
+	err := dbHandler.database.Where("prefix = ?", prefix).First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("API key does not exist")
+	}
+
+	return &searchedData, nil
+}
+
+func (dbHandler *dbHandler) GetAPIKeys() *[]entities.APIKey {
+	var data []entities.APIKey
+	dbHandler.database.Order("id").Find(&data)
+
+	return &data
+}
+
+func (dbHandler *dbHandler) RevokeAPIKey(id uint) error {
+	result := dbHandler.database.Model(&entities.APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil || result.RowsAffected == 0 {
+		return fmt.Errorf("API key with id:%v does not exist or is already revoked", id)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) TouchAPIKey(id uint, usedAt time.Time) {
+	dbHandler.database.Model(&entities.APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt)
+}
+
+// GetCartItems returns the items of the cart of owner, it is empty when owner has no cart
+func (dbHandler *dbHandler) GetCartItems(owner string) (*[]entities.CartItem, error) {
+	data := []entities.CartItem{}
+
+	err := dbHandler.database.
+		Joins("JOIN carts ON carts.id = cart_items.cart_id").
+		Where("carts.owner = ?", owner).
+		Order("cart_items.id").
+		Find(&data).Error
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get cart of owner:%v", owner)
+	}
+
+	return &data, nil
+}
+
+// UpdateCartItems replaces the items of the cart of owner with the result of update.
+// The cart row is locked for the transaction so concurrent updates of the same
+// cart are applied one after another, an empty result deletes the cart. When
+// idempotencyKey is set and was already applied to the cart of owner, update is
+// skipped and false is returned.
+func (dbHandler *dbHandler) UpdateCartItems(owner, idempotencyKey string, update func(items []entities.CartItem) ([]entities.CartItem, error)) (bool, error) {
+	tx := dbHandler.database.Begin()
+	if tx.Error != nil {
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	now := time.Now()
+	err := tx.Exec("INSERT INTO carts (owner, created_at, updated_at) VALUES (?, ?, ?) ON CONFLICT (owner) DO NOTHING", owner, now, now).Error
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	cart := entities.Cart{}
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("owner = ?", owner).First(&cart).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	if idempotencyKey != "" {
+		applied, err := dbHandler.claimIdempotencyKey(tx, owner, idempotencyKey, now)
+		if err != nil || applied {
+			tx.Rollback()
+			return false, err
+		}
+	}
+
+	items := []entities.CartItem{}
+	if err := tx.Where("cart_id = ?", cart.ID).Order("id").Find(&items).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	items, err = update(items)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if len(items) == 0 {
+		// Items are removed by the foreign key cascade
+		if err := tx.Unscoped().Delete(&cart).Error; err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("Unable to delete cart of owner:%v", owner)
+		}
+
+		return true, tx.Commit().Error
+	}
+
+	if err := tx.Unscoped().Where("cart_id = ?", cart.ID).Delete(&entities.CartItem{}).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	for _, item := range items {
+		item.Model = gorm.Model{}
+		item.CartID = cart.ID
+		if err := tx.Create(&item).Error; err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("Unable to save product:%v in cart of owner:%v", item.ProductID, owner)
+		}
+	}
+
+	if err := tx.Model(&cart).Update("updated_at", now).Error; err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	return true, tx.Commit().Error
+}
+
+// claimIdempotencyKey records key for owner in tx and reports whether it was already
+// recorded, keys older than IDEMPOTENCY_KEY_TTL are forgotten first
+func (dbHandler *dbHandler) claimIdempotencyKey(tx *gorm.DB, owner, key string, now time.Time) (bool, error) {
+	err := tx.Where("owner = ? AND created_at < ?", owner, now.Add(-settings.GetIdempotencyKeyTTL())).
+		Delete(&entities.CartIdempotencyKey{}).Error
+	if err != nil {
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	var count int
+	if err := tx.Model(&entities.CartIdempotencyKey{}).Where("owner = ? AND key = ?", owner, key).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	if count > 0 {
+		return true, nil
+	}
+
+	if err := tx.Create(&entities.CartIdempotencyKey{Owner: owner, Key: key, CreatedAt: now}).Error; err != nil {
+		return false, fmt.Errorf("Unable to update cart of owner:%v", owner)
+	}
+
+	return false, nil
+}
+
+// GetWishlists returns the wishlists of owner with their items, the saved for later list first
+func (dbHandler *dbHandler) GetWishlists(owner string) (*[]entities.Wishlist, error) {
+	data := []entities.Wishlist{}
+
+	err := dbHandler.database.
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("wishlist_items.id") }).
+		Where("owner = ?", owner).
+		Order("saved_for_later DESC, id").
+		Find(&data).Error
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get wishlists of owner:%v", owner)
+	}
+
+	return &data, nil
+}
+
+func (dbHandler *dbHandler) GetWishlist(owner string, id uint) (*entities.Wishlist, error) {
+	searchedData := entities.Wishlist{}
+
+	err := dbHandler.database.
+		Preload("Items", func(db *gorm.DB) *gorm.DB { return db.Order("wishlist_items.id") }).
+		Where("owner = ? AND id = ?", owner, id).
+		First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Wishlist with id:%v does not exist", id)
+	}
+
+	return &searchedData, nil
+}
+
+// GetSavedForLater returns the saved for later list of owner, it is created on first use
+func (dbHandler *dbHandler) GetSavedForLater(owner string) (*entities.Wishlist, error) {
+	searchedData := entities.Wishlist{}
+
+	err := dbHandler.database.
+		Where(entities.Wishlist{Owner: owner, SavedForLater: true}).
+		Attrs(entities.Wishlist{Name: entities.SavedForLaterName}).
+		FirstOrCreate(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get saved for later list of owner:%v", owner)
+	}
+
+	err = dbHandler.database.Where("wishlist_id = ?", searchedData.ID).Order("id").Find(&searchedData.Items).Error
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get saved for later list of owner:%v", owner)
+	}
+
+	return &searchedData, nil
+}
+
+func (dbHandler *dbHandler) CreateWishlist(wishlist *entities.Wishlist) error {
+	if err := dbHandler.database.Create(wishlist).Error; err != nil {
+		return fmt.Errorf("Wishlist named %v already exists", wishlist.Name)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) RenameWishlist(owner string, id uint, name string) error {
+	result := dbHandler.database.Model(&entities.Wishlist{}).
+		Where("owner = ? AND id = ?", owner, id).
+		Update("name", name)
+	if result.Error != nil {
+		return fmt.Errorf("Wishlist named %v already exists", name)
+	}
+
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("Wishlist with id:%v does not exist", id)
+	}
+
+	return nil
+}
+
+// DeleteWishlist deletes the wishlist of owner, its items are removed by the foreign key cascade
+func (dbHandler *dbHandler) DeleteWishlist(owner string, id uint) error {
+	result := dbHandler.database.Unscoped().Where("owner = ? AND id = ?", owner, id).Delete(&entities.Wishlist{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return fmt.Errorf("Wishlist with id:%v does not exist", id)
+	}
+
+	return nil
+}
+
+// SaveWishlistItem adds the product of item to its wishlist or replaces its quantity
+// when the product is already in the list
+func (dbHandler *dbHandler) SaveWishlistItem(item *entities.WishlistItem) error {
+	searchedData := entities.WishlistItem{}
+
+	err := dbHandler.database.
+		Where(entities.WishlistItem{WishlistID: item.WishlistID, ProductID: item.ProductID}).
+		Assign(entities.WishlistItem{Quantity: item.Quantity}).
+		FirstOrCreate(&searchedData).Error
+	if err != nil {
+		return fmt.Errorf("Unable to save product:%v in wishlist:%v", item.ProductID, item.WishlistID)
+	}
+
+	*item = searchedData
+
+	return nil
+}
+
+func (dbHandler *dbHandler) DeleteWishlistItem(wishlistID, productID uint) error {
+	result := dbHandler.database.Unscoped().
+		Where("wishlist_id = ? AND product_id = ?", wishlistID, productID).
+		Delete(&entities.WishlistItem{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return fmt.Errorf("Product does not exist in wishlist")
+	}
+
+	return nil
+}
+
+// CreateOrder saves order with its lines and discounts and takes the ordered quantities
+// out of the stock of tracked products in one transaction, nothing is saved when a
+// product does not have enough stock left
+func (dbHandler *dbHandler) CreateOrder(order *entities.Order) error {
+	tx := dbHandler.database.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("Unable to create order of owner:%v", order.Owner)
+	}
+
+	if err := dbHandler.createOrder(tx, order); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// PlaceCartOrder locks the cart of owner, saves the order newOrder builds from its items
+// and deletes the cart in one transaction so an order is never placed without emptying
+// its cart. The order already placed with idempotencyKey is returned instead when there is one.
+func (dbHandler *dbHandler) PlaceCartOrder(owner, idempotencyKey string, newOrder func(items []entities.CartItem) (*entities.Order, error)) (*entities.Order, error) {
+	tx := dbHandler.database.Begin()
+	if tx.Error != nil {
+		return nil, fmt.Errorf("Unable to create order of owner:%v", owner)
+	}
+
+	now := time.Now()
+	err := tx.Exec("INSERT INTO carts (owner, created_at, updated_at) VALUES (?, ?, ?) ON CONFLICT (owner) DO NOTHING", owner, now, now).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Unable to create order of owner:%v", owner)
+	}
+
+	cart := entities.Cart{}
+	if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("owner = ?", owner).First(&cart).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Unable to create order of owner:%v", owner)
+	}
+
+	if idempotencyKey != "" {
+		if placed, err := dbHandler.getOrderByIdempotencyKey(tx, owner, idempotencyKey); err == nil {
+			tx.Rollback()
+			return placed, nil
+		}
+	}
+
+	items := []entities.CartItem{}
+	if err := tx.Where("cart_id = ?", cart.ID).Order("id").Find(&items).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Unable to create order of owner:%v", owner)
+	}
+
+	order, err := newOrder(items)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := dbHandler.createOrder(tx, order); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Items are removed by the foreign key cascade
+	if err := tx.Unscoped().Delete(&cart).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("Unable to delete cart of owner:%v", owner)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("Unable to create order of owner:%v", owner)
+	}
+
+	return order, nil
+}
+
+// GetOrderByIdempotencyKey returns the order owner placed with idempotencyKey
+func (dbHandler *dbHandler) GetOrderByIdempotencyKey(owner, idempotencyKey string) (*entities.Order, error) {
+	return dbHandler.getOrderByIdempotencyKey(dbHandler.database, owner, idempotencyKey)
+}
+
+func (dbHandler *dbHandler) getOrderByIdempotencyKey(db *gorm.DB, owner, idempotencyKey string) (*entities.Order, error) {
+	searchedData := entities.Order{}
+
+	err := db.
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("order_lines.id") }).
+		Preload("Discounts", func(db *gorm.DB) *gorm.DB { return db.Order("order_discounts.id") }).
+		Preload("Transitions", func(db *gorm.DB) *gorm.DB { return db.Order("order_transitions.id") }).
+		Where("owner = ? AND idempotency_key = ?", owner, idempotencyKey).
+		First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Order with Idempotency-Key:%v does not exist", idempotencyKey)
+	}
+
+	return &searchedData, nil
+}
+
+// createOrder takes the ordered quantities from tracked stock and saves order on tx,
+// the stock of a product never drops below zero
+func (dbHandler *dbHandler) createOrder(tx *gorm.DB, order *entities.Order) error {
+	for _, line := range order.Lines {
+		err := tx.Model(&entities.ProductCollection{}).
+			Where("id = ? AND stock IS NOT NULL AND unlimited_stock = false", line.ProductID).
+			UpdateColumn("stock", gorm.Expr("stock - ?", line.Quantity)).Error
+		if err != nil {
+			return fmt.Errorf("Unable to update stock of product:%v", line.ProductID)
+		}
+
+		var count int
+		if err := tx.Model(&entities.ProductCollection{}).Where("id = ? AND stock < 0", line.ProductID).Count(&count).Error; err != nil || count > 0 {
+			return fmt.Errorf("Not enough of %v is in stock", line.Name)
+		}
+	}
+
+	if err := tx.Create(order).Error; err != nil {
+		return fmt.Errorf("Unable to create order of owner:%v", order.Owner)
+	}
+
+	return nil
+}
+
+// GetOrders returns the orders of owner with their lines and discounts, newest first
+func (dbHandler *dbHandler) GetOrders(owner string) (*[]entities.Order, error) {
+	data := []entities.Order{}
+
+	err := dbHandler.database.
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("order_lines.id") }).
+		Preload("Discounts", func(db *gorm.DB) *gorm.DB { return db.Order("order_discounts.id") }).
+		Preload("Transitions", func(db *gorm.DB) *gorm.DB { return db.Order("order_transitions.id") }).
+		Where("owner = ?", owner).
+		Order("id DESC").
+		Find(&data).Error
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get orders of owner:%v", owner)
+	}
+
+	return &data, nil
+}
+
+func (dbHandler *dbHandler) GetOrder(owner string, id uint) (*entities.Order, error) {
+	searchedData := entities.Order{}
+
+	err := dbHandler.database.
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("order_lines.id") }).
+		Preload("Discounts", func(db *gorm.DB) *gorm.DB { return db.Order("order_discounts.id") }).
+		Preload("Transitions", func(db *gorm.DB) *gorm.DB { return db.Order("order_transitions.id") }).
+		Where("owner = ? AND id = ?", owner, id).
+		First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Order with id:%v does not exist", id)
+	}
+
+	return &searchedData, nil
+}
+
+// GetOrderByID returns the order of any owner for store staff
+func (dbHandler *dbHandler) GetOrderByID(id uint) (*entities.Order, error) {
+	searchedData := entities.Order{}
+
+	err := dbHandler.database.
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("order_lines.id") }).
+		Preload("Discounts", func(db *gorm.DB) *gorm.DB { return db.Order("order_discounts.id") }).
+		Preload("Transitions", func(db *gorm.DB) *gorm.DB { return db.Order("order_transitions.id") }).
+		Where("id = ?", id).
+		First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Order with id:%v does not exist", id)
+	}
+
+	return &searchedData, nil
+}
+
+// TransitionOrder moves the order of transition from its FromStatus to its ToStatus and
+// records transition in one transaction. The order must still be in FromStatus so
+// concurrent changes can not both apply, restock puts the ordered quantities back in
+// the stock of tracked products.
+func (dbHandler *dbHandler) TransitionOrder(transition *entities.OrderTransition, restock bool) error {
+	tx := dbHandler.database.Begin()
+	if tx.Error != nil {
+		return fmt.Errorf("Unable to update order with id:%v", transition.OrderID)
+	}
+
+	result := tx.Model(&entities.Order{}).
+		Where("id = ? AND status = ?", transition.OrderID, transition.FromStatus).
+		Updates(map[string]interface{}{"status": transition.ToStatus, "updated_at": transition.CreatedAt})
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to update order with id:%v", transition.OrderID)
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("Order with id:%v is no longer %v", transition.OrderID, transition.FromStatus)
+	}
+
+	if restock {
+		lines := []entities.OrderLine{}
+		if err := tx.Where("order_id = ?", transition.OrderID).Find(&lines).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("Unable to update order with id:%v", transition.OrderID)
+		}
+
+		for _, line := range lines {
+			err := tx.Model(&entities.ProductCollection{}).
+				Where("id = ? AND stock IS NOT NULL AND unlimited_stock = false", line.ProductID).
+				UpdateColumn("stock", gorm.Expr("stock + ?", line.Quantity)).Error
+			if err != nil {
+				tx.Rollback()
+				return fmt.Errorf("Unable to update stock of product:%v", line.ProductID)
+			}
+		}
+	}
+
+	if err := tx.Create(transition).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("Unable to update order with id:%v", transition.OrderID)
+	}
+
+	return tx.Commit().Error
+}
+
+func (dbHandler *dbHandler) CreatePayment(payment *entities.Payment) error {
+	if err := dbHandler.database.Create(payment).Error; err != nil {
+		return fmt.Errorf("Unable to save payment of order:%v", payment.OrderID)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) UpdatePayment(payment *entities.Payment) error {
+	if err := dbHandler.database.Save(payment).Error; err != nil {
+		return fmt.Errorf("Unable to save payment of order:%v", payment.OrderID)
+	}
+
+	return nil
+}
+
+func (dbHandler *dbHandler) GetPaymentsByOrderID(orderID uint) (*[]entities.Payment, error) {
+	data := []entities.Payment{}
+
+	if err := dbHandler.database.Where("order_id = ?", orderID).Order("id").Find(&data).Error; err != nil {
+		return nil, fmt.Errorf("Unable to get payments of order:%v", orderID)
+	}
+
+	return &data, nil
+}
+
+func (dbHandler *dbHandler) GetPaymentByProviderID(provider, providerPaymentID string) (*entities.Payment, error) {
+	searchedData := entities.Payment{}
+
+	err := dbHandler.database.Where("provider = ? AND provider_payment_id = ?", provider, providerPaymentID).First(&searchedData).Error
+	if err != nil {
+		return nil, fmt.Errorf("Payment:%v does not exist", providerPaymentID)
+	}
+
+	return &searchedData, nil
+}
+
+// BatchSavePromotions creates or updates the promotions and their product links
+func (dbHandler *dbHandler) BatchSavePromotions(promotions *[]entities.Promotion) {
+	for _, promotion := range *promotions {
+		products := promotion.Products
+		promotion.Products = nil
+
+		if err := dbHandler.database.Save(&promotion).Error; err != nil {
+			logger.Log.Errorf("Unable to save promotion:%v due to: %v", promotion.ID, err)
+			continue
+		}
+
+		for _, product := range products {
+			product.PromotionID = promotion.ID
+			dbHandler.database.FirstOrCreate(&product, product)
+		}
+	}
+}
+
+// GetPromotionsByProductIDs returns the promotions linked to any of productIDs with all their product links
+func (dbHandler *dbHandler) GetPromotionsByProductIDs(productIDs []uint) (*[]entities.Promotion, error) {
+	data := []entities.Promotion{}
+	if len(productIDs) == 0 {
+		return &data, nil
+	}
+
+	err := dbHandler.database.Preload("Products").
+		Where("id IN (SELECT promotion_id FROM promotion_products WHERE product_id IN (?))", productIDs).
+		Order("id").
+		Find(&data).Error
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get promotions of products:%v", productIDs)
+	}
+
+	return &data, nil
+}
@@ -1,9 +1,15 @@
 package entities
 
 import (
+	"time"
+
+	"github.com/emanpicar/minimart-api/money"
 	"github.com/jinzhu/gorm"
 )
 
+// SavedForLaterName is the name of the saved for later list of every owner
+const SavedForLaterName = "Saved for later"
+
 type (
 	ProductCollection struct {
 		ID     uint            `gorm:"unique;primary_key" json:"id"`
@@ -11,12 +17,30 @@ type (
 		Name   string          `gorm:"type:varchar(100)" json:"name"`
 		Offers []ProductOffers `gorm:"foreignkey:ProductID" json:"offers,omitempty"`
 		Slug   string          `gorm:"type:varchar(100)" json:"slug"`
+		// BasePrice is the regular price before promotions
+		BasePrice money.Cents `gorm:"type:bigint;not null;default:0" json:"-"`
+		// Stock is nil when it is not tracked, UnlimitedStock products are never out of stock
+		Stock          *int `json:"-"`
+		UnlimitedStock bool `gorm:"not null;default:false" json:"-"`
+		// MaxPurchasableStock and BulkOrderThreshold limit the quantity in one cart, 0 is no limit
+		MaxPurchasableStock int `gorm:"not null;default:0" json:"-"`
+		BulkOrderThreshold  int `gorm:"not null;default:0" json:"-"`
+		// SoldByWeight products are priced per WeightUnit, their quantities and limits are
+		// kept in grams and can be changed in steps of WeightIncrement grams
+		SoldByWeight    bool   `gorm:"not null;default:false" json:"-"`
+		WeightUnit      string `gorm:"type:varchar(10)" json:"-"`
+		WeightIncrement int    `gorm:"not null;default:0" json:"-"`
 	}
 
 	ProductOffers struct {
 		gorm.Model `json:"-"`
 		Price      float32 `gorm:"type:decimal(10,2)" json:"price"`
 		ProductID  uint    `json:"-"`
+		// ValidFrom and ValidTill bound the offer, a missing bound is open
+		ValidFrom *time.Time `json:"-"`
+		ValidTill *time.Time `json:"-"`
+		// TimeZone is the zone the window was given in
+		TimeZone string `gorm:"type:varchar(50)" json:"-"`
 	}
 
 	ProductImages struct {
@@ -27,8 +51,164 @@ type (
 
 	Credential struct {
 		gorm.Model
-		Username string `gorm:"type:varchar(40)"`
-		Password string `gorm:"type:varchar(40)"`
+		Username     string `gorm:"type:varchar(40);unique_index"`
+		PasswordHash string `gorm:"type:varchar(60)"`
+		Email        string `gorm:"type:varchar(254)"`
+		FullName     string `gorm:"type:varchar(100)"`
+		Role         string `gorm:"type:varchar(20);default:'customer'"`
+	}
+
+	RefreshToken struct {
+		gorm.Model
+		TokenHash    string `gorm:"type:varchar(64);unique_index"`
+		FamilyID     string `gorm:"type:varchar(32);index"`
+		CredentialID uint   `gorm:"index"`
+		ExpiresAt    time.Time
+		RevokedAt    *time.Time
+	}
+
+	APIKey struct {
+		gorm.Model
+		Name       string `gorm:"type:varchar(100)"`
+		Prefix     string `gorm:"type:varchar(16);unique_index"`
+		KeyHash    string `gorm:"type:varchar(64)"`
+		Role       string `gorm:"type:varchar(20)"`
+		Scopes     string `gorm:"type:varchar(500)"`
+		CreatedBy  string `gorm:"type:varchar(64)"`
+		LastUsedAt *time.Time
+		RevokedAt  *time.Time
+	}
+
+	// Cart is the persisted cart of an owner, the subject of the principal that created it
+	Cart struct {
+		gorm.Model
+		Owner string     `gorm:"type:varchar(100);unique_index"`
+		Items []CartItem `gorm:"foreignkey:CartID"`
+	}
+
+	CartItem struct {
+		gorm.Model
+		CartID    uint `gorm:"unique_index:idx_cart_items_cart_product"`
+		ProductID uint `gorm:"unique_index:idx_cart_items_cart_product"`
+		Quantity  int
+		// AddedPrice is the unit price of the product when its quantity was last changed
+		AddedPrice money.Cents `gorm:"type:bigint;not null;default:0"`
+	}
+
+	// CartIdempotencyKey is an Idempotency-Key already applied to the cart of Owner,
+	// it is kept by owner so it outlives the cart being emptied
+	CartIdempotencyKey struct {
+		Owner     string `gorm:"type:varchar(100);primary_key"`
+		Key       string `gorm:"type:varchar(100);primary_key"`
+		CreatedAt time.Time
+	}
+
+	// Wishlist is a named list of products kept by the subject of its Owner, every owner
+	// has at most one SavedForLater list holding the items moved out of the cart
+	Wishlist struct {
+		gorm.Model
+		Owner         string         `gorm:"type:varchar(100);unique_index:idx_wishlists_owner_name"`
+		Name          string         `gorm:"type:varchar(100);unique_index:idx_wishlists_owner_name"`
+		SavedForLater bool           `gorm:"not null;default:false"`
+		Items         []WishlistItem `gorm:"foreignkey:WishlistID"`
+	}
+
+	// WishlistItem keeps its Quantity like a cart item, in grams for products sold by weight
+	WishlistItem struct {
+		gorm.Model
+		WishlistID uint `gorm:"unique_index:idx_wishlist_items_wishlist_product"`
+		ProductID  uint `gorm:"unique_index:idx_wishlist_items_wishlist_product"`
+		Quantity   int
+	}
+
+	// Order is a checked out cart of Owner, its lines and amounts are a snapshot of the
+	// cart at checkout so later changes of the catalog or promotions leave it unchanged
+	Order struct {
+		gorm.Model
+		Owner string `gorm:"type:varchar(100);index;unique_index:idx_orders_owner_idempotency_key"`
+		// IdempotencyKey is the Idempotency-Key of the checkout, nil when none was sent
+		IdempotencyKey *string           `gorm:"type:varchar(100);unique_index:idx_orders_owner_idempotency_key"`
+		Status         string            `gorm:"type:varchar(20)"`
+		ItemCount      int               `gorm:"not null;default:0"`
+		Subtotal       money.Cents       `gorm:"type:bigint;not null;default:0"`
+		DiscountTotal  money.Cents       `gorm:"type:bigint;not null;default:0"`
+		Tax            money.Cents       `gorm:"type:bigint;not null;default:0"`
+		Total          money.Cents       `gorm:"type:bigint;not null;default:0"`
+		Lines          []OrderLine       `gorm:"foreignkey:OrderID"`
+		Discounts      []OrderDiscount   `gorm:"foreignkey:OrderID"`
+		Transitions    []OrderTransition `gorm:"foreignkey:OrderID"`
+	}
+
+	// OrderLine keeps the name and price of the product at checkout, Quantity is in
+	// Unit g and UnitPrice per PricedPer unit for products sold by weight
+	OrderLine struct {
+		gorm.Model
+		OrderID   uint        `gorm:"index"`
+		ProductID uint        `gorm:"index"`
+		Name      string      `gorm:"type:varchar(100)"`
+		Quantity  int         `gorm:"not null;default:0"`
+		Unit      string      `gorm:"type:varchar(10)"`
+		PricedPer string      `gorm:"type:varchar(10)"`
+		UnitPrice money.Cents `gorm:"type:bigint;not null;default:0"`
+		Subtotal  money.Cents `gorm:"type:bigint;not null;default:0"`
+	}
+
+	// OrderDiscount is a promotion applied to the order at checkout
+	OrderDiscount struct {
+		gorm.Model
+		OrderID     uint        `gorm:"index"`
+		PromotionID uint        `gorm:"not null;default:0"`
+		Description string      `gorm:"type:varchar(200)"`
+		Times       int         `gorm:"not null;default:0"`
+		Amount      money.Cents `gorm:"type:bigint;not null;default:0"`
+	}
+
+	// OrderTransition records a change of the status of an order, Actor is the subject of
+	// the principal that made it. Transitions are only ever added, never changed or deleted.
+	OrderTransition struct {
+		ID         uint      `gorm:"primary_key"`
+		OrderID    uint      `gorm:"index"`
+		FromStatus string    `gorm:"type:varchar(20)"`
+		ToStatus   string    `gorm:"type:varchar(20)"`
+		Actor      string    `gorm:"type:varchar(100)"`
+		ActorRole  string    `gorm:"type:varchar(20)"`
+		Note       string    `gorm:"type:varchar(500)"`
+		CreatedAt  time.Time `gorm:"not null"`
+	}
+
+	// Payment is an attempt to pay an order through Provider, ProviderPaymentID is the id
+	// of the payment at the provider that its status callbacks refer to
+	Payment struct {
+		gorm.Model
+		OrderID           uint        `gorm:"index"`
+		Provider          string      `gorm:"type:varchar(20);unique_index:idx_payments_provider_payment_id"`
+		ProviderPaymentID string      `gorm:"type:varchar(100);unique_index:idx_payments_provider_payment_id"`
+		Status            string      `gorm:"type:varchar(20)"`
+		Amount            money.Cents `gorm:"type:bigint;not null;default:0"`
+		RefundedAmount    money.Cents `gorm:"type:bigint;not null;default:0"`
+	}
+
+	// Promotion is an offer of the product catalog, Rule holds the rule payload as JSON
+	Promotion struct {
+		ID          uint               `gorm:"primary_key;auto_increment:false"`
+		Type        string             `gorm:"type:varchar(20)"`
+		Description string             `gorm:"type:varchar(200)"`
+		Rule        string             `gorm:"type:text"`
+		Products    []PromotionProduct `gorm:"foreignkey:PromotionID"`
+		ValidFrom   *time.Time
+		ValidTill   *time.Time
+		TimeZone    string `gorm:"type:varchar(50)"`
+	}
+
+	// PromotionProduct links a promotion to a product listing it as one of its offers
+	PromotionProduct struct {
+		PromotionID uint `gorm:"primary_key;auto_increment:false"`
+		ProductID   uint `gorm:"primary_key;auto_increment:false"`
+	}
+
+	RevokedToken struct {
+		JTI       string `gorm:"type:varchar(64);primary_key"`
+		ExpiresAt time.Time
 	}
 )
 
@@ -47,3 +227,82 @@ func (ProductImages) TableName() string {
 func (Credential) TableName() string {
 	return "credentials"
 }
+
+func (RefreshToken) TableName() string {
+	return "refresh_tokens"
+}
+
+func (RevokedToken) TableName() string {
+	return "revoked_tokens"
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+func (Cart) TableName() string {
+	return "carts"
+}
+
+func (CartItem) TableName() string {
+	return "cart_items"
+}
+
+func (CartIdempotencyKey) TableName() string {
+	return "cart_idempotency_keys"
+}
+
+func (Wishlist) TableName() string {
+	return "wishlists"
+}
+
+func (WishlistItem) TableName() string {
+	return "wishlist_items"
+}
+
+func (Order) TableName() string {
+	return "orders"
+}
+
+func (OrderLine) TableName() string {
+	return "order_lines"
+}
+
+func (OrderDiscount) TableName() string {
+	return "order_discounts"
+}
+
+func (OrderTransition) TableName() string {
+	return "order_transitions"
+}
+
+func (Payment) TableName() string {
+	return "payments"
+}
+
+func (Promotion) TableName() string {
+	return "promotions"
+}
+
+func (PromotionProduct) TableName() string {
+	return "promotion_products"
+}
+
+// ValidAt reports whether the offer applies at t
+func (offer *ProductOffers) ValidAt(t time.Time) bool {
+	return validAt(t, offer.ValidFrom, offer.ValidTill)
+}
+
+// ValidAt reports whether the promotion applies at t
+func (promotion *Promotion) ValidAt(t time.Time) bool {
+	return validAt(t, promotion.ValidFrom, promotion.ValidTill)
+}
+
+// validAt treats both bounds as inclusive, the default data ends windows at 23:59:59
+func validAt(t time.Time, from, till *time.Time) bool {
+	if from != nil && t.Before(*from) {
+		return false
+	}
+
+	return till == nil || !t.After(*till)
+}
@@ -3,11 +3,14 @@ module github.com/emanpicar/minimart-api
 go 1.13
 
 require (
+	github.com/alicebob/gopher-json v0.0.0-20180125190556-5a6b3ba71ee6 // indirect
+	github.com/alicebob/miniredis v2.5.0+incompatible
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/gorilla/context v1.1.1
+	github.com/gomodule/redigo v1.7.0
 	github.com/gorilla/mux v1.7.3
 	github.com/jinzhu/gorm v1.9.11
-	github.com/mitchellh/mapstructure v1.1.2
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/sirupsen/logrus v1.4.2
+	github.com/yuin/gopher-lua v0.0.0-20180827083657-b942cacc89fe // indirect
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
 )
@@ -7,9 +7,15 @@ import (
 	"github.com/emanpicar/minimart-api/cart"
 	"github.com/emanpicar/minimart-api/db"
 	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/order"
+	"github.com/emanpicar/minimart-api/payment"
 	"github.com/emanpicar/minimart-api/product"
+	"github.com/emanpicar/minimart-api/promotions"
 	"github.com/emanpicar/minimart-api/routes"
 	"github.com/emanpicar/minimart-api/settings"
+	"github.com/emanpicar/minimart-api/throttle"
+	"github.com/emanpicar/minimart-api/user"
+	"github.com/emanpicar/minimart-api/wishlist"
 
 	"net/http"
 )
@@ -20,15 +26,96 @@ func main() {
 
 	dbManager := db.NewDBManager()
 	productManager := product.NewManager(dbManager)
-	cartManager := cart.NewManager(dbManager)
-	authHandler := auth.NewManager()
+	promotionManager := promotions.NewManager(dbManager)
+	cartManager := cart.NewManager(dbManager, newCartStore(dbManager), promotionManager)
+	authHandler := newAuthManager(dbManager)
+	userManager := user.NewManager(dbManager)
+	wishlistManager := wishlist.NewManager(dbManager, cartManager)
+	orderManager := order.NewManager(dbManager, cartManager, newPaymentProvider())
 
 	productManager.PopulateDefaultData()
+	promotionManager.PopulateDefaultData()
+	userManager.PopulateDefaultAdmin()
 
 	logger.Log.Fatal(http.ListenAndServeTLS(
 		fmt.Sprintf("%v:%v", settings.GetServerHost(), settings.GetServerPort()),
 		settings.GetServerPublicKey(),
 		settings.GetServerPrivateKey(),
-		routes.NewRouter(productManager, cartManager, authHandler),
+		routes.NewRouter(productManager, cartManager, authHandler, userManager, wishlistManager, orderManager),
 	))
 }
+
+func newAuthManager(dbManager db.Manager) auth.Manager {
+	if settings.GetAuthMode() != "oidc" {
+		return auth.NewManager(dbManager, newTokenKeySet(), throttle.NewMemoryStore())
+	}
+
+	roleMapping, err := auth.ParseRoleMapping(settings.GetOIDCRoleMapping())
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+
+	if settings.GetOIDCIssuerURL() == "" {
+		logger.Log.Fatalln("OIDC_ISSUER_URL is required when AUTH_MODE is oidc")
+	}
+
+	logger.Log.Infof("Validating tokens issued by %v", settings.GetOIDCIssuerURL())
+
+	return auth.NewOIDCManager(auth.OIDCConfig{
+		IssuerURL:   settings.GetOIDCIssuerURL(),
+		JWKSFile:    settings.GetOIDCJWKSFile(),
+		Audience:    settings.GetOIDCAudience(),
+		RoleMapping: roleMapping,
+	})
+}
+
+func newCartStore(dbManager db.Manager) cart.CartStore {
+	switch settings.GetCartStore() {
+	case "sql":
+		return cart.NewSQLStore(dbManager)
+	case "memory":
+		logger.Log.Warnln("Keeping carts in memory, they are lost on restart and not shared between instances")
+		return cart.NewMemoryStore(settings.GetCartTTL(), settings.GetIdempotencyKeyTTL())
+	case "redis":
+		return cart.NewRedisStore(cart.NewRedisPool(settings.GetRedisURL()), settings.GetCartTTL(), settings.GetIdempotencyKeyTTL())
+	}
+
+	logger.Log.Fatalf("Unknown CART_STORE:%v, use sql, memory or redis", settings.GetCartStore())
+
+	return nil
+}
+
+func newPaymentProvider() payment.Provider {
+	if settings.GetPaymentWebhookSecret() == "" {
+		logger.Log.Warnln("PAYMENT_WEBHOOK_SECRET is not set, payment callbacks are rejected")
+	}
+
+	switch settings.GetPaymentProvider() {
+	case "fake":
+		logger.Log.Warnln("Using the fake payment provider, no money is moved")
+		return payment.NewFakeProvider()
+	}
+
+	logger.Log.Fatalf("Unknown PAYMENT_PROVIDER:%v, use fake", settings.GetPaymentProvider())
+
+	return nil
+}
+
+func newTokenKeySet() *auth.KeySet {
+	if settings.GetTokenSigningKey() == "" {
+		// notSoSecret was the default TOKEN_SECRET, tokens signed with it can be forged by anyone
+		if secret := settings.GetTokenSecret(); secret == "" || secret == "notSoSecret" {
+			logger.Log.Fatalln("TOKEN_SIGNING_KEY or a TOKEN_SECRET other than the former default notSoSecret must be set")
+		}
+
+		logger.Log.Warnln("TOKEN_SIGNING_KEY is not set, signing tokens with the shared TOKEN_SECRET")
+		return auth.NewHMACKeySet(settings.GetTokenSecret())
+	}
+
+	keySet, err := auth.LoadKeySet(settings.GetTokenSigningKey(), settings.GetTokenSigningKeyID(), settings.GetTokenVerificationKeys())
+	if err != nil {
+		logger.Log.Fatalln(err)
+	}
+
+	return keySet
+}
@@ -0,0 +1,110 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Cents is an amount of money in the smallest currency unit. All arithmetic
+// on prices uses Cents so totals never drift the way float32 sums do.
+type Cents int64
+
+// FromFloat rounds a decimal amount such as a price stored as float32 to the nearest cent
+func FromFloat(amount float64) Cents {
+	return Cents(math.Round(amount * 100))
+}
+
+// Parse reads a decimal amount with at most two fractional digits, e.g. "6.35"
+func Parse(amount string) (Cents, error) {
+	amount = strings.TrimSpace(amount)
+
+	negative := strings.HasPrefix(amount, "-")
+	whole, fraction := strings.TrimPrefix(amount, "-"), ""
+	if i := strings.Index(whole, "."); i >= 0 {
+		whole, fraction = whole[:i], whole[i+1:]
+	}
+
+	if whole == "" && fraction == "" || len(fraction) > 2 || !isDigits(whole) || !isDigits(fraction) {
+		return 0, fmt.Errorf("Invalid amount:%v", amount)
+	}
+
+	units, err := strconv.ParseInt("0"+whole, 10, 64)
+	if err != nil || units > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("Invalid amount:%v", amount)
+	}
+
+	cents, _ := strconv.ParseInt((fraction + "00")[:2], 10, 64)
+	result := Cents(units*100 + cents)
+	if negative {
+		result = -result
+	}
+
+	return result, nil
+}
+
+// Times returns the amount of quantity items
+func (c Cents) Times(quantity int) Cents {
+	return c * Cents(quantity)
+}
+
+// Percent returns basisPoints/10000 of the amount rounded half away from zero,
+// 700 basis points are 7%
+func (c Cents) Percent(basisPoints int64) Cents {
+	return c.Scale(basisPoints, 10000)
+}
+
+// Scale returns numerator/denominator of the amount rounded half away from zero,
+// e.g. the price of 250 g of a product priced per kg is price.Scale(250, 1000)
+func (c Cents) Scale(numerator, denominator int64) Cents {
+	product := int64(c) * numerator
+	if product < 0 {
+		return -Cents((-product + denominator/2) / denominator)
+	}
+
+	return Cents((product + denominator/2) / denominator)
+}
+
+// String formats the amount with two decimals, e.g. "6.35"
+func (c Cents) String() string {
+	sign := ""
+	value := int64(c)
+	if value < 0 {
+		sign, value = "-", -value
+	}
+
+	return fmt.Sprintf("%v%d.%02d", sign, value/100, value%100)
+}
+
+// MarshalJSON encodes the amount as a JSON number with two decimals
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "null" {
+		return nil
+	}
+
+	cents, err := Parse(value)
+	if err != nil {
+		return errors.New("Amounts must have at most two decimals")
+	}
+
+	*c = cents
+
+	return nil
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,112 @@
+package money
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		amount  string
+		want    Cents
+		wantErr bool
+	}{
+		{name: "Two decimals", amount: "6.35", want: 635},
+		{name: "One decimal", amount: "5.8", want: 580},
+		{name: "No decimals", amount: "12", want: 1200},
+		{name: "Leading dot", amount: ".5", want: 50},
+		{name: "Negative", amount: "-0.55", want: -55},
+		{name: "Too many decimals", amount: "1.005", wantErr: true},
+		{name: "Exponent", amount: "1e3", wantErr: true},
+		{name: "Empty", amount: "", wantErr: true},
+		{name: "Only a dot", amount: ".", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.amount)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Parse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromFloat(t *testing.T) {
+	// float32 cannot hold 0.55 exactly, each price is rounded to whole cents before summing
+	var cents Cents
+	for i := 0; i < 1000; i++ {
+		cents += FromFloat(float64(float32(0.55)))
+	}
+
+	if cents != 55000 {
+		t.Errorf("FromFloat() sum = %v, want 550.00", cents)
+	}
+}
+
+func TestCents_Percent(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      Cents
+		basisPoints int64
+		want        Cents
+	}{
+		{name: "7% of 10.00", amount: 1000, basisPoints: 700, want: 70},
+		{name: "7% of 5.80 rounds half up", amount: 580, basisPoints: 700, want: 41},
+		{name: "7% of 0.50 rounds half up", amount: 50, basisPoints: 700, want: 4},
+		{name: "8.25% of 19.99", amount: 1999, basisPoints: 825, want: 165},
+		{name: "Negative rounds away from zero", amount: -50, basisPoints: 700, want: -4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.Percent(tt.basisPoints); got != tt.want {
+				t.Errorf("Percent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCents_Scale(t *testing.T) {
+	tests := []struct {
+		name        string
+		amount      Cents
+		numerator   int64
+		denominator int64
+		want        Cents
+	}{
+		{name: "250 g at 12.99 per kg", amount: 1299, numerator: 250, denominator: 1000, want: 325},
+		{name: "1.5 kg at 3.30 per kg", amount: 330, numerator: 1500, denominator: 1000, want: 495},
+		{name: "Half a cent rounds up", amount: 1, numerator: 1, denominator: 2, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.amount.Scale(tt.numerator, tt.denominator); got != tt.want {
+				t.Errorf("Scale() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCents_JSON(t *testing.T) {
+	bytesData, err := json.Marshal(struct {
+		Total Cents `json:"total"`
+	}{Total: 1205})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(bytesData) != `{"total":12.05}` {
+		t.Errorf("MarshalJSON() = %s", bytesData)
+	}
+
+	var decoded struct {
+		Total Cents `json:"total"`
+	}
+	if err := json.Unmarshal(bytesData, &decoded); err != nil || decoded.Total != 1205 {
+		t.Errorf("UnmarshalJSON() = %v, %v", decoded.Total, err)
+	}
+}
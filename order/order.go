@@ -0,0 +1,360 @@
+package order
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/cart"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/payment"
+	"github.com/emanpicar/minimart-api/settings"
+)
+
+type (
+	Manager interface {
+		PlaceOrder(r *http.Request) (*Order, error)
+		GetAllOrders(r *http.Request) (*[]Order, error)
+		GetOrder(r *http.Request, orderID string) (*Order, error)
+		UpdateStatus(r *http.Request, orderID string) (*Order, error)
+		PayOrder(r *http.Request, orderID string) (*Order, error)
+		HandlePaymentWebhook(r *http.Request) (string, error)
+	}
+
+	orderHandler struct {
+		dbManager       db.Manager
+		cartManager     cart.Manager
+		paymentProvider payment.Provider
+		webhookSecret   []byte
+		now             func() time.Time
+	}
+
+	// Order is a placed order, every amount is the one of the cart at checkout
+	Order struct {
+		ID            uint         `json:"id"`
+		Status        string       `json:"status"`
+		Lines         []Line       `json:"lines"`
+		Discounts     []Discount   `json:"discounts"`
+		ItemCount     int          `json:"item_count"`
+		Subtotal      money.Cents  `json:"subtotal"`
+		DiscountTotal money.Cents  `json:"discount_total"`
+		Tax           money.Cents  `json:"tax"`
+		Total         money.Cents  `json:"total"`
+		CreatedAt     time.Time    `json:"created_at"`
+		History       []Transition `json:"history"`
+	}
+
+	// Transition is a change of the status of an order, the first one places the order
+	Transition struct {
+		From      string    `json:"from,omitempty"`
+		To        string    `json:"to"`
+		Actor     string    `json:"actor"`
+		ActorRole string    `json:"actor_role"`
+		Note      string    `json:"note,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	StatusReqBody struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+
+	// Line has its Quantity in Unit g and its UnitPrice per PricedPer unit for products sold by weight
+	Line struct {
+		ProductID uint        `json:"product_id"`
+		Name      string      `json:"name"`
+		Quantity  int         `json:"quantity"`
+		Unit      string      `json:"unit,omitempty"`
+		PricedPer string      `json:"priced_per,omitempty"`
+		UnitPrice money.Cents `json:"unit_price"`
+		Subtotal  money.Cents `json:"subtotal"`
+	}
+
+	Discount struct {
+		PromotionID uint        `json:"promotion_id"`
+		Description string      `json:"description"`
+		Times       int         `json:"times"`
+		Amount      money.Cents `json:"amount"`
+	}
+)
+
+const maxNoteLength = 500
+
+func NewManager(dbManager db.Manager, cartManager cart.Manager, paymentProvider payment.Provider) Manager {
+	return &orderHandler{
+		dbManager:       dbManager,
+		cartManager:     cartManager,
+		paymentProvider: paymentProvider,
+		webhookSecret:   []byte(settings.GetPaymentWebhookSecret()),
+		now:             time.Now,
+	}
+}
+
+// PlaceOrder turns the cart of the principal into an order priced like the cart is
+// and empties the cart, a retry with the Idempotency-Key header of a placed order
+// returns that order instead of placing another one
+func (o *orderHandler) PlaceOrder(r *http.Request) (*Order, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := o.cartManager.Checkout(principal.Subject, r.Header.Get("Idempotency-Key"), func(summary *cart.CartSummary) *entities.Order {
+		return o.populateOrderForModel(principal, summary)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data := o.populateOrder(order)
+
+	return &data, nil
+}
+
+func (o *orderHandler) GetAllOrders(r *http.Request) (*[]Order, error) {
+	owner, err := o.getOwnerInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := o.dbManager.GetOrders(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []Order{}
+	for i := range *orders {
+		data = append(data, o.populateOrder(&(*orders)[i]))
+	}
+
+	return &data, nil
+}
+
+func (o *orderHandler) GetOrder(r *http.Request, orderID string) (*Order, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := o.getOrder(principal, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := o.populateOrder(order)
+
+	return &data, nil
+}
+
+// UpdateStatus moves an order along the transition table and records who did it, store
+// staff and admins change any order and customers can only cancel their own. Orders only
+// become paid by capturing their payment and the payments of cancelled or refunded orders
+// are voided or refunded once the order changed
+func (o *orderHandler) UpdateStatus(r *http.Request, orderID string) (*Order, error) {
+	var reqData StatusReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	if len(reqData.Note) > maxNoteLength {
+		return nil, fmt.Errorf("Note must be at most %v characters", maxNoteLength)
+	}
+
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := o.getOrder(principal, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !principal.HasRole(auth.RoleStaff, auth.RoleAdmin) && reqData.Status != StatusCancelled {
+		return nil, errors.New("Only store staff can change the status of an order")
+	}
+
+	if reqData.Status == StatusPaid {
+		return nil, errors.New("Orders are paid by capturing their payment")
+	}
+
+	if err := validateTransition(order.Status, reqData.Status); err != nil {
+		return nil, err
+	}
+
+	// The money is only released once the order can no longer move to another status
+	if err := o.transition(order, reqData.Status, principal.Subject, principal.Role, strings.TrimSpace(reqData.Note)); err != nil {
+		return nil, err
+	}
+
+	if reqData.Status == StatusCancelled || reqData.Status == StatusRefunded {
+		if err := o.settlePayments(order); err != nil {
+			o.recordSettlementFailure(order, err)
+			return nil, fmt.Errorf("Order with id:%v is %v but its payments could not be released: %v", order.ID, order.Status, err)
+		}
+	}
+
+	data := o.populateOrder(order)
+
+	return &data, nil
+}
+
+// transition moves order to status and records the transition on order
+func (o *orderHandler) transition(order *entities.Order, status, actor, actorRole, note string) error {
+	transition := &entities.OrderTransition{
+		OrderID:    order.ID,
+		FromStatus: order.Status,
+		ToStatus:   status,
+		Actor:      actor,
+		ActorRole:  actorRole,
+		Note:       note,
+		CreatedAt:  o.now(),
+	}
+	if err := o.dbManager.TransitionOrder(transition, restocks(order.Status, status)); err != nil {
+		return err
+	}
+
+	order.Status = transition.ToStatus
+	order.Transitions = append(order.Transitions, *transition)
+
+	return nil
+}
+
+// recordSettlementFailure adds a transition that keeps the status of order to its history
+// so payments that could not be voided or refunded are settled by hand
+func (o *orderHandler) recordSettlementFailure(order *entities.Order, settleErr error) {
+	logger.Log.Errorf("Unable to settle payments of %v order:%v due to: %v", order.Status, order.ID, settleErr)
+
+	note := "Payments could not be released: " + settleErr.Error()
+	if len(note) > maxNoteLength {
+		note = note[:maxNoteLength]
+	}
+
+	if err := o.transition(order, order.Status, o.paymentProvider.Name(), actorRolePayment, note); err != nil {
+		logger.Log.Errorf("Unable to record settlement failure of order:%v due to: %v", order.ID, err)
+	}
+}
+
+// getOrder returns the order of orderID when principal may see it, store staff and
+// admins see the orders of every owner
+func (o *orderHandler) getOrder(principal *auth.Principal, orderID string) (*entities.Order, error) {
+	id, err := strconv.ParseUint(orderID, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse orderID:%v", orderID)
+	}
+
+	if principal.HasRole(auth.RoleStaff, auth.RoleAdmin) {
+		return o.dbManager.GetOrderByID(uint(id))
+	}
+
+	return o.dbManager.GetOrder(principal.Subject, uint(id))
+}
+
+func (o *orderHandler) getOwnerInContext(r *http.Request) (string, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return "", err
+	}
+
+	return principal.Subject, nil
+}
+
+func (o *orderHandler) populateOrderForModel(principal *auth.Principal, summary *cart.CartSummary) *entities.Order {
+	order := &entities.Order{
+		Owner:         principal.Subject,
+		Status:        StatusPendingPayment,
+		ItemCount:     summary.ItemCount,
+		Subtotal:      summary.Subtotal,
+		DiscountTotal: summary.DiscountTotal,
+		Tax:           summary.Tax,
+		Total:         summary.Total,
+	}
+
+	for _, item := range summary.Items {
+		order.Lines = append(order.Lines, entities.OrderLine{
+			ProductID: item.ID,
+			Name:      item.Name,
+			Quantity:  item.Quantity,
+			Unit:      item.Unit,
+			PricedPer: item.PricedPer,
+			UnitPrice: item.SalesPrice,
+			Subtotal:  item.Subtotal,
+		})
+	}
+
+	for _, discount := range summary.Discounts {
+		order.Discounts = append(order.Discounts, entities.OrderDiscount{
+			PromotionID: discount.PromotionID,
+			Description: discount.Description,
+			Times:       discount.Times,
+			Amount:      discount.Amount,
+		})
+	}
+
+	order.Transitions = []entities.OrderTransition{{
+		ToStatus:  StatusPendingPayment,
+		Actor:     principal.Subject,
+		ActorRole: principal.Role,
+		CreatedAt: o.now(),
+	}}
+
+	return order
+}
+
+func (o *orderHandler) populateOrder(order *entities.Order) Order {
+	data := Order{
+		ID:            order.ID,
+		Status:        order.Status,
+		Lines:         []Line{},
+		Discounts:     []Discount{},
+		ItemCount:     order.ItemCount,
+		Subtotal:      order.Subtotal,
+		DiscountTotal: order.DiscountTotal,
+		Tax:           order.Tax,
+		Total:         order.Total,
+		CreatedAt:     order.CreatedAt,
+		History:       []Transition{},
+	}
+
+	for _, line := range order.Lines {
+		data.Lines = append(data.Lines, Line{
+			ProductID: line.ProductID,
+			Name:      line.Name,
+			Quantity:  line.Quantity,
+			Unit:      line.Unit,
+			PricedPer: line.PricedPer,
+			UnitPrice: line.UnitPrice,
+			Subtotal:  line.Subtotal,
+		})
+	}
+
+	for _, discount := range order.Discounts {
+		data.Discounts = append(data.Discounts, Discount{
+			PromotionID: discount.PromotionID,
+			Description: discount.Description,
+			Times:       discount.Times,
+			Amount:      discount.Amount,
+		})
+	}
+
+	for _, transition := range order.Transitions {
+		data.History = append(data.History, Transition{
+			From:      transition.FromStatus,
+			To:        transition.ToStatus,
+			Actor:     transition.Actor,
+			ActorRole: transition.ActorRole,
+			Note:      transition.Note,
+			CreatedAt: transition.CreatedAt,
+		})
+	}
+
+	return data
+}
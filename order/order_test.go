@@ -0,0 +1,450 @@
+package order
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/cart"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/payment"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+type fakeOrderDB struct {
+	db.Manager
+	prices   map[uint]money.Cents
+	orders   []entities.Order
+	payments []entities.Payment
+	// restocked counts the transitions that put stock back
+	restocked int
+	// transitionErr fails every TransitionOrder like a lost race or a failed write
+	transitionErr error
+}
+
+func (f *fakeOrderDB) GetProductByID(pID uint) (*entities.ProductCollection, error) {
+	price, ok := f.prices[pID]
+	if !ok {
+		return nil, fmt.Errorf("Product with productID:%v does not exist", pID)
+	}
+
+	return &entities.ProductCollection{ID: pID, Name: fmt.Sprintf("Product %v", pID), BasePrice: price}, nil
+}
+
+func (f *fakeOrderDB) GetPromotionsByProductIDs(productIDs []uint) (*[]entities.Promotion, error) {
+	return &[]entities.Promotion{}, nil
+}
+
+func (f *fakeOrderDB) CreateOrder(order *entities.Order) error {
+	order.ID = uint(len(f.orders) + 1)
+	f.orders = append(f.orders, *order)
+
+	return nil
+}
+
+func (f *fakeOrderDB) GetOrderByIdempotencyKey(owner, idempotencyKey string) (*entities.Order, error) {
+	for _, order := range f.orders {
+		if order.Owner == owner && order.IdempotencyKey != nil && *order.IdempotencyKey == idempotencyKey {
+			return &order, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Order with Idempotency-Key:%v does not exist", idempotencyKey)
+}
+
+func (f *fakeOrderDB) GetOrders(owner string) (*[]entities.Order, error) {
+	data := []entities.Order{}
+	for i := len(f.orders) - 1; i >= 0; i-- {
+		if f.orders[i].Owner == owner {
+			data = append(data, f.orders[i])
+		}
+	}
+
+	return &data, nil
+}
+
+func (f *fakeOrderDB) GetOrder(owner string, id uint) (*entities.Order, error) {
+	for _, order := range f.orders {
+		if order.Owner == owner && order.ID == id {
+			return &order, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Order with id:%v does not exist", id)
+}
+
+func (f *fakeOrderDB) GetOrderByID(id uint) (*entities.Order, error) {
+	if id == 0 || int(id) > len(f.orders) {
+		return nil, fmt.Errorf("Order with id:%v does not exist", id)
+	}
+
+	order := f.orders[id-1]
+	return &order, nil
+}
+
+func (f *fakeOrderDB) TransitionOrder(transition *entities.OrderTransition, restock bool) error {
+	if f.transitionErr != nil {
+		return f.transitionErr
+	}
+
+	order := &f.orders[transition.OrderID-1]
+	if order.Status != transition.FromStatus {
+		return fmt.Errorf("Order with id:%v is no longer %v", order.ID, transition.FromStatus)
+	}
+
+	order.Status = transition.ToStatus
+	order.Transitions = append(order.Transitions, *transition)
+	if restock {
+		f.restocked++
+	}
+
+	return nil
+}
+
+func (f *fakeOrderDB) CreatePayment(record *entities.Payment) error {
+	record.ID = uint(len(f.payments) + 1)
+	f.payments = append(f.payments, *record)
+
+	return nil
+}
+
+func (f *fakeOrderDB) UpdatePayment(record *entities.Payment) error {
+	f.payments[record.ID-1] = *record
+
+	return nil
+}
+
+func (f *fakeOrderDB) GetPaymentsByOrderID(orderID uint) (*[]entities.Payment, error) {
+	data := []entities.Payment{}
+	for _, record := range f.payments {
+		if record.OrderID == orderID {
+			data = append(data, record)
+		}
+	}
+
+	return &data, nil
+}
+
+func (f *fakeOrderDB) GetPaymentByProviderID(provider, providerPaymentID string) (*entities.Payment, error) {
+	for _, record := range f.payments {
+		if record.Provider == provider && record.ProviderPaymentID == providerPaymentID {
+			return &record, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Payment:%v does not exist", providerPaymentID)
+}
+
+func newOrderRequest(method, target, owner string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: owner, Role: auth.RoleCustomer}))
+}
+
+func newStatusRequest(body, subject, role string) *http.Request {
+	r := httptest.NewRequest("PUT", "/api/orders", bytes.NewBufferString(body))
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: subject, Role: role}))
+}
+
+func Test_orderHandler_PlaceOrder(t *testing.T) {
+	fakeDB := &fakeOrderDB{prices: map[uint]money.Cents{1: 635, 2: 199}}
+	store := cart.NewMemoryStore(time.Hour, time.Hour)
+	o := NewManager(fakeDB, cart.NewManager(fakeDB, store, promotions.NewManager(fakeDB)), payment.NewSeededFakeProvider(1))
+
+	store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+		return []cart.CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 1}}, nil
+	})
+
+	placed, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1"))
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if placed.Status != StatusPendingPayment || placed.ItemCount != 3 || placed.Total != 1469 || len(placed.Lines) != 2 || placed.Lines[0].UnitPrice != 635 {
+		t.Errorf("PlaceOrder() = %+v, want the priced cart", placed)
+	}
+
+	if items, _ := store.Get("1"); len(items) != 0 {
+		t.Errorf("PlaceOrder() left %+v in the cart", items)
+	}
+	if _, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1")); err != cart.ErrEmptyCart {
+		t.Errorf("PlaceOrder() of the emptied cart error = %v, want %v", err, cart.ErrEmptyCart)
+	}
+
+	store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+		return []cart.CartItem{{ProductID: 2, Quantity: 1}}, nil
+	})
+	for attempt := 0; attempt < 2; attempt++ {
+		r := newOrderRequest("POST", "/api/orders", "1")
+		r.Header.Set("Idempotency-Key", "9b1deb4d-3b7d-4bad-9bdd-2b0d7b3dcb6d")
+
+		retried, err := o.PlaceOrder(r)
+		if err != nil || retried.ID != 2 || retried.Total != 199 {
+			t.Fatalf("PlaceOrder() attempt %v = %+v, %v, want the order placed with the key", attempt, retried, err)
+		}
+	}
+	if len(fakeDB.orders) != 2 {
+		t.Errorf("PlaceOrder() retried with the same key placed %v orders, want 2", len(fakeDB.orders))
+	}
+
+	// Orders keep the prices of the checkout
+	fakeDB.prices[1] = 655
+
+	tests := []struct {
+		name    string
+		owner   string
+		orderID string
+		wantErr bool
+	}{
+		{
+			name:    "Own order",
+			owner:   "1",
+			orderID: fmt.Sprint(placed.ID),
+		},
+		{
+			name:    "Order of another owner",
+			owner:   "2",
+			orderID: fmt.Sprint(placed.ID),
+			wantErr: true,
+		},
+		{
+			name:    "Invalid id",
+			owner:   "1",
+			orderID: "first",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := o.GetOrder(newOrderRequest("GET", "/api/orders/"+tt.orderID, tt.owner), tt.orderID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && (got.Total != placed.Total || got.Lines[0].UnitPrice != 635) {
+				t.Errorf("GetOrder() = %+v, want %+v", got, placed)
+			}
+		})
+	}
+
+	orders, err := o.GetAllOrders(newOrderRequest("GET", "/api/orders", "2"))
+	if err != nil || len(*orders) != 0 {
+		t.Errorf("GetAllOrders() of another owner = %+v, %v, want no orders", orders, err)
+	}
+}
+
+func Test_orderHandler_UpdateStatus(t *testing.T) {
+	fakeDB := &fakeOrderDB{prices: map[uint]money.Cents{1: 635}}
+	store := cart.NewMemoryStore(time.Hour, time.Hour)
+	o := &orderHandler{
+		dbManager:       fakeDB,
+		cartManager:     cart.NewManager(fakeDB, store, promotions.NewManager(fakeDB)),
+		paymentProvider: payment.NewSeededFakeProvider(1),
+		now:             func() time.Time { return time.Date(2019, 11, 20, 12, 0, 0, 0, time.UTC) },
+	}
+
+	for i := 0; i < 2; i++ {
+		store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+			return []cart.CartItem{{ProductID: 1, Quantity: 1}}, nil
+		})
+		if _, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1")); err != nil {
+			t.Fatalf("PlaceOrder() error = %v", err)
+		}
+	}
+
+	if _, err := o.PayOrder(newOrderRequest("POST", "/api/orders/1/payment", "1"), "1"); err != nil {
+		t.Fatalf("PayOrder() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		orderID    string
+		body       string
+		subject    string
+		role       string
+		wantStatus string
+		wantErr    bool
+	}{
+		{
+			name:    "Customers can not advance orders",
+			orderID: "1",
+			body:    `{"status":"picking"}`,
+			subject: "1",
+			role:    auth.RoleCustomer,
+			wantErr: true,
+		},
+		{
+			name:    "Orders are only paid by capturing their payment",
+			orderID: "2",
+			body:    `{"status":"paid"}`,
+			subject: "7",
+			role:    auth.RoleStaff,
+			wantErr: true,
+		},
+		{
+			name:       "Staff advances an order",
+			orderID:    "1",
+			body:       `{"status":"picking","note":"Picked by the morning shift"}`,
+			subject:    "7",
+			role:       auth.RoleStaff,
+			wantStatus: StatusPicking,
+		},
+		{
+			name:    "Transition outside the table",
+			orderID: "1",
+			body:    `{"status":"delivered"}`,
+			subject: "7",
+			role:    auth.RoleStaff,
+			wantErr: true,
+		},
+		{
+			name:    "Orders being picked can not be cancelled",
+			orderID: "1",
+			body:    `{"status":"cancelled"}`,
+			subject: "1",
+			role:    auth.RoleCustomer,
+			wantErr: true,
+		},
+		{
+			name:    "Order of another customer",
+			orderID: "2",
+			body:    `{"status":"cancelled"}`,
+			subject: "2",
+			role:    auth.RoleCustomer,
+			wantErr: true,
+		},
+		{
+			name:       "Customer cancels an unpaid order",
+			orderID:    "2",
+			body:       `{"status":"cancelled"}`,
+			subject:    "1",
+			role:       auth.RoleCustomer,
+			wantStatus: StatusCancelled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := o.UpdateStatus(newStatusRequest(tt.body, tt.subject, tt.role), tt.orderID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && got.Status != tt.wantStatus {
+				t.Errorf("UpdateStatus() status = %v, want %v", got.Status, tt.wantStatus)
+			}
+		})
+	}
+
+	order, err := o.GetOrder(newStatusRequest("", "7", auth.RoleStaff), "1")
+	if err != nil {
+		t.Fatalf("GetOrder() by staff error = %v", err)
+	}
+
+	want := []Transition{
+		{To: StatusPendingPayment, Actor: "1", ActorRole: auth.RoleCustomer, CreatedAt: o.now()},
+		{From: StatusPendingPayment, To: StatusPaid, Actor: "fake", ActorRole: actorRolePayment, Note: "Payment " + fakeDB.payments[0].ProviderPaymentID + " captured", CreatedAt: o.now()},
+		{From: StatusPaid, To: StatusPicking, Actor: "7", ActorRole: auth.RoleStaff, Note: "Picked by the morning shift", CreatedAt: o.now()},
+	}
+	if !reflect.DeepEqual(order.History, want) {
+		t.Errorf("GetOrder() history = %+v, want %+v", order.History, want)
+	}
+
+	if fakeDB.restocked != 1 {
+		t.Errorf("TransitionOrder() restocked %v times, want only the cancelled order", fakeDB.restocked)
+	}
+}
+
+func Test_orderHandler_UpdateStatus_settlement(t *testing.T) {
+	tests := []struct {
+		name              string
+		providerPaymentID string
+		transitionErr     error
+		wantErr           bool
+		wantStatus        string
+		wantPaymentStatus string
+		wantHistory       int
+	}{
+		{
+			name:              "Authorization is voided once the order is cancelled",
+			wantStatus:        StatusCancelled,
+			wantPaymentStatus: payment.StatusVoided,
+			wantHistory:       2,
+		},
+		{
+			name:              "Order that can not be cancelled keeps its authorization",
+			transitionErr:     errors.New("Order with id:1 is no longer pending_payment"),
+			wantErr:           true,
+			wantStatus:        StatusPendingPayment,
+			wantPaymentStatus: payment.StatusAuthorized,
+			wantHistory:       1,
+		},
+		{
+			name:              "Failure to void is recorded in the history",
+			providerPaymentID: "fake_pay_unknown",
+			wantErr:           true,
+			wantStatus:        StatusCancelled,
+			wantPaymentStatus: payment.StatusAuthorized,
+			wantHistory:       3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDB := &fakeOrderDB{prices: map[uint]money.Cents{1: 1000}}
+			store := cart.NewMemoryStore(time.Hour, time.Hour)
+			o := newPaymentHandler(fakeDB, store)
+
+			store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+				return []cart.CartItem{{ProductID: 1, Quantity: 1}}, nil
+			})
+			if _, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1")); err != nil {
+				t.Fatalf("PlaceOrder() error = %v", err)
+			}
+
+			paymentID, err := o.paymentProvider.Authorize("order-1", 1000)
+			if err != nil {
+				t.Fatalf("Authorize() error = %v", err)
+			}
+			if tt.providerPaymentID != "" {
+				paymentID = tt.providerPaymentID
+			}
+			fakeDB.CreatePayment(&entities.Payment{OrderID: 1, Provider: "fake", ProviderPaymentID: paymentID, Status: payment.StatusAuthorized, Amount: 1000})
+			fakeDB.transitionErr = tt.transitionErr
+
+			_, err = o.UpdateStatus(newStatusRequest(`{"status":"cancelled"}`, "1", auth.RoleCustomer), "1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.transitionErr != nil {
+				if err := o.paymentProvider.Void(paymentID); err != nil {
+					t.Errorf("UpdateStatus() released the authorization at the provider: %v", err)
+				}
+			}
+
+			order := fakeDB.orders[0]
+			if order.Status != tt.wantStatus || fakeDB.payments[0].Status != tt.wantPaymentStatus {
+				t.Errorf("UpdateStatus() order %v with payment %v, want %v with %v", order.Status, fakeDB.payments[0].Status, tt.wantStatus, tt.wantPaymentStatus)
+			}
+
+			if len(order.Transitions) != tt.wantHistory {
+				t.Fatalf("UpdateStatus() history = %+v, want %v transitions", order.Transitions, tt.wantHistory)
+			}
+
+			if last := order.Transitions[len(order.Transitions)-1]; tt.wantHistory == 3 && (last.FromStatus != StatusCancelled || last.ToStatus != StatusCancelled || last.ActorRole != actorRolePayment) {
+				t.Errorf("UpdateStatus() recorded %+v, want the settlement failure of the cancelled order", last)
+			}
+
+			if tt.transitionErr == nil && fakeDB.restocked != 1 {
+				t.Errorf("UpdateStatus() restocked %v times, want once", fakeDB.restocked)
+			}
+		})
+	}
+}
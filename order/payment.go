@@ -0,0 +1,231 @@
+package order
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/payment"
+)
+
+const (
+	// actorRolePayment is the role recorded for transitions made by the payment provider
+	actorRolePayment   = "payment"
+	maxWebhookBodySize = 64 * 1024
+)
+
+// PayOrder authorizes and captures the total of a pending order, the order only moves
+// to paid once the capture succeeded and an authorization that can not be captured is voided
+func (o *orderHandler) PayOrder(r *http.Request, orderID string) (*Order, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := o.getOrder(principal, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.Status != StatusPendingPayment {
+		return nil, fmt.Errorf("Order with id:%v is %v, only %v orders can be paid", order.ID, order.Status, StatusPendingPayment)
+	}
+
+	payments, err := o.dbManager.GetPaymentsByOrderID(order.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, existing := range *payments {
+		if existing.Status == payment.StatusAuthorized || existing.Status == payment.StatusCaptured {
+			return nil, fmt.Errorf("Order with id:%v already has a payment in progress", order.ID)
+		}
+	}
+
+	paymentID, err := o.paymentProvider.Authorize(fmt.Sprintf("order-%d", order.ID), order.Total)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &entities.Payment{
+		OrderID:           order.ID,
+		Provider:          o.paymentProvider.Name(),
+		ProviderPaymentID: paymentID,
+		Status:            payment.StatusAuthorized,
+		Amount:            order.Total,
+	}
+	if err := o.dbManager.CreatePayment(record); err != nil {
+		o.voidPayment(record)
+		return nil, err
+	}
+
+	if err := o.paymentProvider.Capture(paymentID, order.Total); err != nil {
+		o.voidPayment(record)
+		return nil, err
+	}
+
+	if err := o.capturePayment(order, record); err != nil {
+		return nil, err
+	}
+
+	data := o.populateOrder(order)
+
+	return &data, nil
+}
+
+// HandlePaymentWebhook applies a signed status callback of the payment provider, callbacks
+// are applied once so the provider can retry them
+func (o *orderHandler) HandlePaymentWebhook(r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBodySize))
+	if err != nil {
+		return "", err
+	}
+
+	event, err := payment.ParseEvent(o.webhookSecret, body, r.Header.Get(payment.SignatureHeader), o.now())
+	if err != nil {
+		return "", err
+	}
+
+	record, err := o.dbManager.GetPaymentByProviderID(o.paymentProvider.Name(), event.PaymentID)
+	if err != nil {
+		return "", err
+	}
+
+	order, err := o.dbManager.GetOrderByID(record.OrderID)
+	if err != nil {
+		return "", err
+	}
+
+	switch event.Type {
+	case payment.EventCaptured:
+		err = o.capturePayment(order, record)
+	case payment.EventFailed, payment.EventVoided:
+		if record.Status == payment.StatusAuthorized {
+			record.Status = payment.StatusFailed
+			if event.Type == payment.EventVoided {
+				record.Status = payment.StatusVoided
+			}
+			err = o.dbManager.UpdatePayment(record)
+		}
+	case payment.EventRefunded:
+		err = o.refundedPayment(order, record, event)
+	default:
+		return "", fmt.Errorf("Unknown event type:%v", event.Type)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return "Successfully received payment event", nil
+}
+
+// capturePayment records the capture of an authorized payment and moves its pending order
+// to paid, a payment captured for an order that was cancelled meanwhile is refunded.
+// Captures of payments that were voided, failed or refunded are ignored.
+func (o *orderHandler) capturePayment(order *entities.Order, record *entities.Payment) error {
+	if record.Status == payment.StatusCaptured {
+		if order.Status != StatusPendingPayment {
+			return nil
+		}
+		return o.transition(order, StatusPaid, o.paymentProvider.Name(), actorRolePayment, "Payment "+record.ProviderPaymentID+" captured")
+	}
+
+	if record.Status != payment.StatusAuthorized {
+		logger.Log.Warnf("Ignoring capture of payment:%v of order:%v that is %v", record.ProviderPaymentID, order.ID, record.Status)
+		return nil
+	}
+
+	record.Status = payment.StatusCaptured
+	if err := o.dbManager.UpdatePayment(record); err != nil {
+		return err
+	}
+
+	err := fmt.Errorf("Order with id:%v is %v, its payment is refunded", order.ID, order.Status)
+	if order.Status == StatusPendingPayment {
+		err = o.transition(order, StatusPaid, o.paymentProvider.Name(), actorRolePayment, "Payment "+record.ProviderPaymentID+" captured")
+	}
+	if err != nil {
+		if refundErr := o.refundPayment(record); refundErr != nil {
+			logger.Log.Errorf("Unable to refund payment:%v of order:%v due to: %v", record.ProviderPaymentID, order.ID, refundErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// refundedPayment records a refund made at the provider, a fully refunded payment
+// refunds its order when the order can still be refunded
+func (o *orderHandler) refundedPayment(order *entities.Order, record *entities.Payment, event *payment.Event) error {
+	if record.Status != payment.StatusCaptured || event.Amount <= record.RefundedAmount {
+		return nil
+	}
+
+	record.RefundedAmount = event.Amount
+	if record.RefundedAmount >= record.Amount {
+		record.RefundedAmount, record.Status = record.Amount, payment.StatusRefunded
+	}
+
+	if err := o.dbManager.UpdatePayment(record); err != nil {
+		return err
+	}
+
+	if record.Status != payment.StatusRefunded || !CanTransition(order.Status, StatusRefunded) {
+		return nil
+	}
+
+	return o.transition(order, StatusRefunded, o.paymentProvider.Name(), actorRolePayment, "Payment "+record.ProviderPaymentID+" refunded")
+}
+
+// settlePayments releases the money of order before it is cancelled or refunded:
+// authorizations are voided and captured payments are refunded
+func (o *orderHandler) settlePayments(order *entities.Order) error {
+	payments, err := o.dbManager.GetPaymentsByOrderID(order.ID)
+	if err != nil {
+		return err
+	}
+
+	for i := range *payments {
+		record := &(*payments)[i]
+		switch record.Status {
+		case payment.StatusAuthorized:
+			if err := o.voidPayment(record); err != nil {
+				return err
+			}
+		case payment.StatusCaptured:
+			if err := o.refundPayment(record); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (o *orderHandler) voidPayment(record *entities.Payment) error {
+	if err := o.paymentProvider.Void(record.ProviderPaymentID); err != nil {
+		logger.Log.Errorf("Unable to void payment:%v of order:%v due to: %v", record.ProviderPaymentID, record.OrderID, err)
+		return err
+	}
+
+	record.Status = payment.StatusVoided
+	if record.ID == 0 {
+		return nil
+	}
+
+	return o.dbManager.UpdatePayment(record)
+}
+
+func (o *orderHandler) refundPayment(record *entities.Payment) error {
+	if err := o.paymentProvider.Refund(record.ProviderPaymentID, record.Amount-record.RefundedAmount); err != nil {
+		return err
+	}
+
+	record.Status, record.RefundedAmount = payment.StatusRefunded, record.Amount
+
+	return o.dbManager.UpdatePayment(record)
+}
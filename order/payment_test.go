@@ -0,0 +1,290 @@
+package order
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/cart"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/payment"
+	"github.com/emanpicar/minimart-api/promotions"
+)
+
+func newPaymentHandler(fakeDB *fakeOrderDB, store cart.CartStore) *orderHandler {
+	return &orderHandler{
+		dbManager:       fakeDB,
+		cartManager:     cart.NewManager(fakeDB, store, promotions.NewManager(fakeDB)),
+		paymentProvider: payment.NewSeededFakeProvider(1),
+		webhookSecret:   []byte("webhook-secret"),
+		now:             func() time.Time { return time.Date(2019, 11, 20, 12, 0, 0, 0, time.UTC) },
+	}
+}
+
+func Test_orderHandler_PayOrder(t *testing.T) {
+	tests := []struct {
+		name              string
+		price             money.Cents
+		wantErr           bool
+		wantStatus        string
+		wantPaymentStatus string
+	}{
+		{
+			name:              "Captured payment",
+			price:             1000,
+			wantStatus:        StatusPaid,
+			wantPaymentStatus: payment.StatusCaptured,
+		},
+		{
+			name:       "Declined payment",
+			price:      1000 + payment.DeclineCents,
+			wantErr:    true,
+			wantStatus: StatusPendingPayment,
+		},
+		{
+			name:              "Capture failure voids the authorization",
+			price:             1000 + payment.CaptureFailureCents,
+			wantErr:           true,
+			wantStatus:        StatusPendingPayment,
+			wantPaymentStatus: payment.StatusVoided,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDB := &fakeOrderDB{prices: map[uint]money.Cents{1: tt.price}}
+			store := cart.NewMemoryStore(time.Hour, time.Hour)
+			o := newPaymentHandler(fakeDB, store)
+
+			store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+				return []cart.CartItem{{ProductID: 1, Quantity: 1}}, nil
+			})
+			if _, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1")); err != nil {
+				t.Fatalf("PlaceOrder() error = %v", err)
+			}
+
+			_, err := o.PayOrder(newOrderRequest("POST", "/api/orders/1/payment", "1"), "1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PayOrder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if fakeDB.orders[0].Status != tt.wantStatus {
+				t.Errorf("PayOrder() order status = %v, want %v", fakeDB.orders[0].Status, tt.wantStatus)
+			}
+
+			if tt.wantPaymentStatus == "" {
+				if len(fakeDB.payments) != 0 {
+					t.Errorf("PayOrder() saved %+v, want no payment", fakeDB.payments)
+				}
+				return
+			}
+
+			if len(fakeDB.payments) != 1 || fakeDB.payments[0].Status != tt.wantPaymentStatus {
+				t.Errorf("PayOrder() saved %+v, want one %v payment", fakeDB.payments, tt.wantPaymentStatus)
+			}
+		})
+	}
+}
+
+// paymentEvent returns the body of a status callback of paymentID
+func paymentEvent(eventType, paymentID, amount string) string {
+	return fmt.Sprintf(`{"type":%q,"payment_id":%q,"amount":%v}`, eventType, paymentID, amount)
+}
+
+func Test_orderHandler_HandlePaymentWebhook(t *testing.T) {
+	fakeDB := &fakeOrderDB{prices: map[uint]money.Cents{1: 1000, 2: 1000 + payment.CaptureFailureCents, 3: 1500}}
+	store := cart.NewMemoryStore(time.Hour, time.Hour)
+	o := newPaymentHandler(fakeDB, store)
+
+	for _, productID := range []uint{1, 2, 3} {
+		store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+			return []cart.CartItem{{ProductID: productID, Quantity: 1}}, nil
+		})
+		if _, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1")); err != nil {
+			t.Fatalf("PlaceOrder() error = %v", err)
+		}
+	}
+
+	// Order 1 is paid and the capture of order 2 fails so its payment is voided
+	if _, err := o.PayOrder(newOrderRequest("POST", "/api/orders/1/payment", "1"), "1"); err != nil {
+		t.Fatalf("PayOrder() error = %v", err)
+	}
+	if _, err := o.PayOrder(newOrderRequest("POST", "/api/orders/2/payment", "1"), "2"); err == nil {
+		t.Fatalf("PayOrder() of a failing capture succeeded")
+	}
+
+	// The authorization of order 3 is only reported by the provider
+	authorizedID, err := o.paymentProvider.Authorize("order-3", 1500)
+	if err != nil {
+		t.Fatalf("Authorize() error = %v", err)
+	}
+	fakeDB.CreatePayment(&entities.Payment{OrderID: 3, Provider: "fake", ProviderPaymentID: authorizedID, Status: payment.StatusAuthorized, Amount: 1500})
+
+	paidID, voidedID := fakeDB.payments[0].ProviderPaymentID, fakeDB.payments[1].ProviderPaymentID
+
+	tests := []struct {
+		name              string
+		body              string
+		secret            string
+		wantErr           bool
+		order             int
+		wantStatus        string
+		wantPaymentStatus string
+	}{
+		{
+			name:              "Repeated capture is applied once",
+			body:              paymentEvent(payment.EventCaptured, paidID, "10.00"),
+			secret:            "webhook-secret",
+			wantStatus:        StatusPaid,
+			wantPaymentStatus: payment.StatusCaptured,
+		},
+		{
+			name:              "Unsigned callback",
+			body:              paymentEvent(payment.EventRefunded, paidID, "10.00"),
+			secret:            "other-secret",
+			wantErr:           true,
+			wantStatus:        StatusPaid,
+			wantPaymentStatus: payment.StatusCaptured,
+		},
+		{
+			name:              "Unknown payment",
+			body:              paymentEvent(payment.EventRefunded, "fake_pay_unknown", "10.00"),
+			secret:            "webhook-secret",
+			wantErr:           true,
+			wantStatus:        StatusPaid,
+			wantPaymentStatus: payment.StatusCaptured,
+		},
+		{
+			name:              "Partial refund",
+			body:              paymentEvent(payment.EventRefunded, paidID, "4.00"),
+			secret:            "webhook-secret",
+			wantStatus:        StatusPaid,
+			wantPaymentStatus: payment.StatusCaptured,
+		},
+		{
+			name:              "Full refund refunds the order",
+			body:              paymentEvent(payment.EventRefunded, paidID, "10.00"),
+			secret:            "webhook-secret",
+			wantStatus:        StatusRefunded,
+			wantPaymentStatus: payment.StatusRefunded,
+		},
+		{
+			name:              "Capture of a refunded payment is ignored",
+			body:              paymentEvent(payment.EventCaptured, paidID, "10.00"),
+			secret:            "webhook-secret",
+			wantStatus:        StatusRefunded,
+			wantPaymentStatus: payment.StatusRefunded,
+		},
+		{
+			name:              "Capture of a voided payment is ignored",
+			body:              paymentEvent(payment.EventCaptured, voidedID, "10.52"),
+			secret:            "webhook-secret",
+			order:             1,
+			wantStatus:        StatusPendingPayment,
+			wantPaymentStatus: payment.StatusVoided,
+		},
+		{
+			name:              "Failed authorization",
+			body:              paymentEvent(payment.EventFailed, authorizedID, "15.00"),
+			secret:            "webhook-secret",
+			order:             2,
+			wantStatus:        StatusPendingPayment,
+			wantPaymentStatus: payment.StatusFailed,
+		},
+		{
+			name:              "Capture of a failed payment is ignored",
+			body:              paymentEvent(payment.EventCaptured, authorizedID, "15.00"),
+			secret:            "webhook-secret",
+			order:             2,
+			wantStatus:        StatusPendingPayment,
+			wantPaymentStatus: payment.StatusFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/payments/webhook", bytes.NewBufferString(tt.body))
+			r.Header.Set(payment.SignatureHeader, payment.Sign([]byte(tt.secret), []byte(tt.body), o.now()))
+
+			_, err := o.HandlePaymentWebhook(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HandlePaymentWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if fakeDB.orders[tt.order].Status != tt.wantStatus {
+				t.Errorf("HandlePaymentWebhook() order status = %v, want %v", fakeDB.orders[tt.order].Status, tt.wantStatus)
+			}
+
+			if fakeDB.payments[tt.order].Status != tt.wantPaymentStatus {
+				t.Errorf("HandlePaymentWebhook() payment status = %v, want %v", fakeDB.payments[tt.order].Status, tt.wantPaymentStatus)
+			}
+		})
+	}
+
+	if len(fakeDB.orders[0].Transitions) != 3 {
+		t.Errorf("HandlePaymentWebhook() history = %+v, want placed, paid and refunded", fakeDB.orders[0].Transitions)
+	}
+
+	if len(fakeDB.orders[1].Transitions) != 1 || len(fakeDB.orders[2].Transitions) != 1 {
+		t.Errorf("HandlePaymentWebhook() moved orders %+v and %+v, want them left placed", fakeDB.orders[1].Transitions, fakeDB.orders[2].Transitions)
+	}
+}
+
+func Test_orderHandler_UpdateStatus_refund(t *testing.T) {
+	tests := []struct {
+		name              string
+		transitionErr     error
+		wantErr           bool
+		wantStatus        string
+		wantPaymentStatus string
+	}{
+		{
+			name:              "Payment is refunded with the order",
+			wantStatus:        StatusRefunded,
+			wantPaymentStatus: payment.StatusRefunded,
+		},
+		{
+			name:              "Order that is not refunded keeps its payment",
+			transitionErr:     errors.New("Unable to update order with id:1"),
+			wantErr:           true,
+			wantStatus:        StatusPaid,
+			wantPaymentStatus: payment.StatusCaptured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDB := &fakeOrderDB{prices: map[uint]money.Cents{1: 1000}}
+			store := cart.NewMemoryStore(time.Hour, time.Hour)
+			o := newPaymentHandler(fakeDB, store)
+
+			store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+				return []cart.CartItem{{ProductID: 1, Quantity: 1}}, nil
+			})
+			if _, err := o.PlaceOrder(newOrderRequest("POST", "/api/orders", "1")); err != nil {
+				t.Fatalf("PlaceOrder() error = %v", err)
+			}
+			if _, err := o.PayOrder(newOrderRequest("POST", "/api/orders/1/payment", "1"), "1"); err != nil {
+				t.Fatalf("PayOrder() error = %v", err)
+			}
+			fakeDB.transitionErr = tt.transitionErr
+
+			_, err := o.UpdateStatus(newStatusRequest(`{"status":"refunded"}`, "7", auth.RoleStaff), "1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateStatus() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if fakeDB.orders[0].Status != tt.wantStatus || fakeDB.payments[0].Status != tt.wantPaymentStatus {
+				t.Errorf("UpdateStatus() order %v with payment %v, want %v with %v", fakeDB.orders[0].Status, fakeDB.payments[0].Status, tt.wantStatus, tt.wantPaymentStatus)
+			}
+
+			// The provider only refunds a payment that was not refunded yet
+			refundErr := o.paymentProvider.Refund(fakeDB.payments[0].ProviderPaymentID, 1000)
+			if (refundErr == nil) != (tt.wantPaymentStatus == payment.StatusCaptured) {
+				t.Errorf("Refund() at the provider error = %v, want the payment refunded only with the order", refundErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package order
+
+import "fmt"
+
+const (
+	StatusPendingPayment     = "pending_payment"
+	StatusPaid               = "paid"
+	StatusPicking            = "picking"
+	StatusReadyForCollection = "ready_for_collection"
+	StatusOutForDelivery     = "out_for_delivery"
+	StatusDelivered          = "delivered"
+	StatusCancelled          = "cancelled"
+	StatusRefunded           = "refunded"
+)
+
+// transitions lists the statuses each status can move to, orders are cancelled before
+// they are paid and refunded after. Cancelled and refunded orders are final.
+var transitions = map[string][]string{
+	StatusPendingPayment:     {StatusPaid, StatusCancelled},
+	StatusPaid:               {StatusPicking, StatusRefunded},
+	StatusPicking:            {StatusReadyForCollection, StatusOutForDelivery, StatusRefunded},
+	StatusReadyForCollection: {StatusDelivered, StatusRefunded},
+	StatusOutForDelivery:     {StatusDelivered, StatusRefunded},
+	StatusDelivered:          {StatusRefunded},
+	StatusCancelled:          {},
+	StatusRefunded:           {},
+}
+
+// IsValidStatus reports whether status is one of the order statuses
+func IsValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// CanTransition reports whether an order in status from can move to status to
+func CanTransition(from, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// restocks reports whether moving from to to puts the ordered quantities back in stock,
+// which is the case for orders stopped before they left the store. Transitions that keep
+// the status only add a note to the history.
+func restocks(from, to string) bool {
+	if from == to {
+		return false
+	}
+
+	switch to {
+	case StatusCancelled:
+		return true
+	case StatusRefunded:
+		return from == StatusPaid || from == StatusPicking || from == StatusReadyForCollection
+	default:
+		return false
+	}
+}
+
+func validateTransition(from, to string) error {
+	if !IsValidStatus(to) {
+		return fmt.Errorf("Unknown status:%v", to)
+	}
+
+	if !CanTransition(from, to) {
+		return fmt.Errorf("Order can not move from %v to %v", from, to)
+	}
+
+	return nil
+}
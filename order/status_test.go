@@ -0,0 +1,49 @@
+package order
+
+import "testing"
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		name string
+		from string
+		to   string
+		want bool
+	}{
+		{name: "Payment received", from: StatusPendingPayment, to: StatusPaid, want: true},
+		{name: "Cancelled before payment", from: StatusPendingPayment, to: StatusCancelled, want: true},
+		{name: "Paid orders are refunded instead of cancelled", from: StatusPaid, to: StatusCancelled},
+		{name: "Picking before payment", from: StatusPendingPayment, to: StatusPicking},
+		{name: "Collected", from: StatusReadyForCollection, to: StatusDelivered, want: true},
+		{name: "Delivered orders do not go back", from: StatusDelivered, to: StatusOutForDelivery},
+		{name: "Refund after delivery", from: StatusDelivered, to: StatusRefunded, want: true},
+		{name: "Cancelled is final", from: StatusCancelled, to: StatusPaid},
+		{name: "Refunded is final", from: StatusRefunded, to: StatusDelivered},
+		{name: "Unknown status", from: StatusPaid, to: "shipped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanTransition(tt.from, tt.to); got != tt.want {
+				t.Errorf("CanTransition(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_restocks(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{from: StatusPendingPayment, to: StatusCancelled, want: true},
+		{from: StatusPicking, to: StatusRefunded, want: true},
+		{from: StatusOutForDelivery, to: StatusRefunded},
+		{from: StatusDelivered, to: StatusRefunded},
+		{from: StatusPaid, to: StatusPicking},
+	}
+	for _, tt := range tests {
+		if got := restocks(tt.from, tt.to); got != tt.want {
+			t.Errorf("restocks(%v, %v) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
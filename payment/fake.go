@@ -0,0 +1,141 @@
+package payment
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/emanpicar/minimart-api/money"
+)
+
+type (
+	// fakeProvider is an in-process gateway with deterministic outcomes for tests and
+	// local development, no money is ever moved
+	fakeProvider struct {
+		mu       sync.Mutex
+		ids      *rand.Rand
+		payments map[string]*fakePayment
+	}
+
+	fakePayment struct {
+		status   string
+		amount   money.Cents
+		captured money.Cents
+		refunded money.Cents
+	}
+)
+
+const (
+	// Amounts ending in DeclineCents are declined and amounts ending in CaptureFailureCents
+	// are authorized but fail to capture, every other amount succeeds
+	DeclineCents        = 51
+	CaptureFailureCents = 52
+)
+
+// NewFakeProvider returns the fake gateway seeded by the start time so payment ids saved
+// before a restart are not handed out again
+func NewFakeProvider() Provider {
+	return NewSeededFakeProvider(time.Now().UnixNano())
+}
+
+// NewSeededFakeProvider returns the fake gateway, providers of the same seed hand out the
+// same payment ids in the same order
+func NewSeededFakeProvider(seed int64) Provider {
+	return &fakeProvider{
+		ids:      rand.New(rand.NewSource(seed)),
+		payments: make(map[string]*fakePayment),
+	}
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) Authorize(reference string, amount money.Cents) (string, error) {
+	if amount <= 0 {
+		return "", fmt.Errorf("Unable to authorize amount:%v for %v", amount, reference)
+	}
+
+	if amount%100 == DeclineCents {
+		return "", ErrDeclined
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	paymentID := fmt.Sprintf("fake_pay_%016x", f.ids.Uint64())
+	f.payments[paymentID] = &fakePayment{status: StatusAuthorized, amount: amount}
+
+	return paymentID, nil
+}
+
+func (f *fakeProvider) Capture(paymentID string, amount money.Cents) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, err := f.getPayment(paymentID, StatusAuthorized)
+	if err != nil {
+		return err
+	}
+
+	if amount <= 0 || amount > payment.amount {
+		return fmt.Errorf("Unable to capture amount:%v of payment:%v", amount, paymentID)
+	}
+
+	if payment.amount%100 == CaptureFailureCents {
+		return fmt.Errorf("Unable to capture payment:%v", paymentID)
+	}
+
+	payment.status, payment.captured = StatusCaptured, amount
+
+	return nil
+}
+
+func (f *fakeProvider) Void(paymentID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, err := f.getPayment(paymentID, StatusAuthorized)
+	if err != nil {
+		return err
+	}
+
+	payment.status = StatusVoided
+
+	return nil
+}
+
+func (f *fakeProvider) Refund(paymentID string, amount money.Cents) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, err := f.getPayment(paymentID, StatusCaptured)
+	if err != nil {
+		return err
+	}
+
+	if amount <= 0 || payment.refunded+amount > payment.captured {
+		return fmt.Errorf("Unable to refund amount:%v of payment:%v", amount, paymentID)
+	}
+
+	payment.refunded += amount
+	if payment.refunded == payment.captured {
+		payment.status = StatusRefunded
+	}
+
+	return nil
+}
+
+func (f *fakeProvider) getPayment(paymentID, status string) (*fakePayment, error) {
+	payment, ok := f.payments[paymentID]
+	if !ok {
+		return nil, fmt.Errorf("Payment:%v does not exist", paymentID)
+	}
+
+	if payment.status != status {
+		return nil, fmt.Errorf("Payment:%v is %v, not %v", paymentID, payment.status, status)
+	}
+
+	return payment, nil
+}
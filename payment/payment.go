@@ -0,0 +1,116 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emanpicar/minimart-api/money"
+)
+
+type (
+	// Provider moves the money of an order through a payment gateway: an authorization
+	// holds the amount, a capture takes it, a void releases an authorization that was
+	// not captured and a refund returns part or all of a captured amount
+	Provider interface {
+		Name() string
+		// Authorize holds amount for reference and returns the id of the payment at the provider
+		Authorize(reference string, amount money.Cents) (string, error)
+		Capture(paymentID string, amount money.Cents) error
+		Void(paymentID string) error
+		Refund(paymentID string, amount money.Cents) error
+	}
+
+	// Event is a payment status callback of a provider, Amount is the amount the status
+	// applies to and for refunds the total refunded so far
+	Event struct {
+		Type      string      `json:"type"`
+		PaymentID string      `json:"payment_id"`
+		Amount    money.Cents `json:"amount"`
+	}
+)
+
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusVoided     = "voided"
+	StatusRefunded   = "refunded"
+	StatusFailed     = "failed"
+
+	EventCaptured = "payment.captured"
+	EventFailed   = "payment.failed"
+	EventVoided   = "payment.voided"
+	EventRefunded = "payment.refunded"
+
+	// SignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">"
+	SignatureHeader = "X-Payment-Signature"
+	// signatureTolerance is how old a signed callback may be, older ones are taken as replays
+	signatureTolerance = 5 * time.Minute
+)
+
+var (
+	ErrDeclined         = errors.New("Payment was declined")
+	ErrInvalidSignature = errors.New("Invalid payment signature")
+)
+
+// Sign returns the SignatureHeader value of body sent at t
+func Sign(secret, body []byte, t time.Time) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%v,v1=%v", timestamp, computeSignature(secret, timestamp, body))
+}
+
+// ParseEvent verifies the signature of a status callback received at now and reads its
+// event, callbacks are rejected when no secret is configured
+func ParseEvent(secret, body []byte, signature string, now time.Time) (*Event, error) {
+	if len(secret) == 0 {
+		return nil, ErrInvalidSignature
+	}
+
+	var timestamp, expected string
+	for _, part := range strings.Split(signature, ",") {
+		switch {
+		case strings.HasPrefix(part, "t="):
+			timestamp = strings.TrimPrefix(part, "t=")
+		case strings.HasPrefix(part, "v1="):
+			expected = strings.TrimPrefix(part, "v1=")
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || expected == "" {
+		return nil, ErrInvalidSignature
+	}
+
+	if age := now.Sub(time.Unix(unix, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return nil, ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(computeSignature(secret, timestamp, body))) {
+		return nil, ErrInvalidSignature
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+
+	if event.PaymentID == "" {
+		return nil, errors.New("Payment id is required")
+	}
+
+	return &event, nil
+}
+
+func computeSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
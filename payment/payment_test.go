@@ -0,0 +1,173 @@
+package payment
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/money"
+)
+
+func Test_fakeProvider(t *testing.T) {
+	tests := []struct {
+		name           string
+		amount         money.Cents
+		wantAuthorized bool
+		wantCaptured   bool
+	}{
+		{
+			name:           "Captured",
+			amount:         1469,
+			wantAuthorized: true,
+			wantCaptured:   true,
+		},
+		{
+			name:   "Declined",
+			amount: 1000 + DeclineCents,
+		},
+		{
+			name:           "Capture fails",
+			amount:         1000 + CaptureFailureCents,
+			wantAuthorized: true,
+		},
+		{
+			name:   "Nothing to pay",
+			amount: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewSeededFakeProvider(1)
+			paymentID, err := f.Authorize("order-1", tt.amount)
+			if (err == nil) != tt.wantAuthorized {
+				t.Fatalf("Authorize() error = %v, wantAuthorized %v", err, tt.wantAuthorized)
+			}
+			if !tt.wantAuthorized {
+				return
+			}
+
+			err = f.Capture(paymentID, tt.amount)
+			if (err == nil) != tt.wantCaptured {
+				t.Fatalf("Capture() error = %v, wantCaptured %v", err, tt.wantCaptured)
+			}
+
+			if !tt.wantCaptured {
+				if err := f.Void(paymentID); err != nil {
+					t.Errorf("Void() error = %v", err)
+				}
+				return
+			}
+
+			if err := f.Void(paymentID); err == nil {
+				t.Errorf("Void() of a captured payment succeeded")
+			}
+			if err := f.Refund(paymentID, 400); err != nil {
+				t.Errorf("Refund() of part error = %v", err)
+			}
+			if err := f.Refund(paymentID, tt.amount); err == nil {
+				t.Errorf("Refund() of more than was captured succeeded")
+			}
+			if err := f.Refund(paymentID, tt.amount-400); err != nil {
+				t.Errorf("Refund() of the rest error = %v", err)
+			}
+		})
+	}
+}
+
+func Test_fakeProvider_ids(t *testing.T) {
+	authorize := func(f Provider) string {
+		paymentID, err := f.Authorize("order-1", 1469)
+		if err != nil {
+			t.Fatalf("Authorize() error = %v", err)
+		}
+		return paymentID
+	}
+
+	first, again, restarted := NewSeededFakeProvider(1), NewSeededFakeProvider(1), NewSeededFakeProvider(2)
+
+	firstID := authorize(first)
+	if againID := authorize(again); againID != firstID {
+		t.Errorf("Authorize() of the same seed = %v, want %v", againID, firstID)
+	}
+
+	if nextID := authorize(first); nextID == firstID {
+		t.Errorf("Authorize() handed out %v twice", nextID)
+	}
+
+	if restartedID := authorize(restarted); restartedID == firstID {
+		t.Errorf("Authorize() of another seed = %v, want a new payment id", restartedID)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	secret := []byte("webhook-secret")
+	now := time.Date(2019, 11, 20, 12, 0, 0, 0, time.UTC)
+	body := []byte(`{"type":"payment.captured","payment_id":"fake_pay_1","amount":14.69}`)
+
+	tests := []struct {
+		name      string
+		secret    []byte
+		body      []byte
+		signature string
+		wantErr   bool
+	}{
+		{
+			name:      "Signed event",
+			secret:    secret,
+			body:      body,
+			signature: Sign(secret, body, now.Add(-time.Minute)),
+		},
+		{
+			name:      "Tampered body",
+			secret:    secret,
+			body:      []byte(`{"type":"payment.captured","payment_id":"fake_pay_2","amount":14.69}`),
+			signature: Sign(secret, body, now),
+			wantErr:   true,
+		},
+		{
+			name:      "Other secret",
+			secret:    secret,
+			body:      body,
+			signature: Sign([]byte("other-secret"), body, now),
+			wantErr:   true,
+		},
+		{
+			name:      "Replayed event",
+			secret:    secret,
+			body:      body,
+			signature: Sign(secret, body, now.Add(-time.Hour)),
+			wantErr:   true,
+		},
+		{
+			name:      "No secret configured",
+			body:      body,
+			signature: Sign(nil, body, now),
+			wantErr:   true,
+		},
+		{
+			name:    "Missing signature",
+			secret:  secret,
+			body:    body,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEvent(tt.secret, tt.body, tt.signature, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				if err != ErrInvalidSignature {
+					t.Errorf("ParseEvent() error = %v, want %v", err, ErrInvalidSignature)
+				}
+				return
+			}
+
+			want := Event{Type: EventCaptured, PaymentID: "fake_pay_1", Amount: 1469}
+			if *got != want {
+				t.Errorf("ParseEvent() = %+v, want %+v", *got, want)
+			}
+		})
+	}
+}
@@ -3,10 +3,14 @@ package product
 import (
 	"encoding/json"
 	"io/ioutil"
+	"strings"
+	"time"
 
 	"github.com/emanpicar/minimart-api/db"
 	"github.com/emanpicar/minimart-api/db/entities"
 	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/settings"
 )
 
 type (
@@ -17,18 +21,109 @@ type (
 
 	productHandler struct {
 		dbManager db.Manager
+		now       func() time.Time
+		// location is the zone offer windows of the default data are given in
+		location *time.Location
 	}
 
 	ProductCollection struct {
 		entities.ProductCollection
-		Images     []string `json:"images,omitempty"`
-		Image      string   `json:"image"`
-		SalesPrice float32  `json:"sales_price"`
+		Images     []string    `json:"images,omitempty"`
+		Image      string      `json:"image"`
+		SalesPrice money.Cents `json:"sales_price"`
+		// Offers, StoreSpecificData, StockOverride and BulkOrderThreshold are only read from the default data
+		Offers             []Offer             `json:"offers,omitempty"`
+		StoreSpecificData  []StoreSpecificData `json:"storeSpecificData,omitempty"`
+		StockOverride      *StockOverride      `json:"stockOverride,omitempty"`
+		BulkOrderThreshold int                 `json:"bulkOrderThreshold,omitempty"`
+		SoldByWeight       int                 `json:"soldByWeight,omitempty"`
+		MetaData           *MetaData           `json:"metaData,omitempty"`
+	}
+
+	MetaData struct {
+		UnitOfWeight string `json:"Unit Of Weight"`
+	}
+
+	StockOverride struct {
+		MaxPurchasableStock *int `json:"maxPurchasableStock"`
+	}
+
+	Offer struct {
+		Price     float32 `json:"price"`
+		ValidFrom string  `json:"validFrom"`
+		ValidTill string  `json:"validTill"`
+	}
+
+	StoreSpecificData struct {
+		Mrp            string `json:"mrp"`
+		Stock          *int   `json:"stock"`
+		UnlimitedStock bool   `json:"unlimitedStock"`
 	}
 )
 
+const (
+	offerTimeLayout = "2006-01-02 15:04:05"
+
+	UnitKilogram = "kg"
+	UnitGram     = "g"
+	// defaultWeightIncrement is the step in grams of products sold by weight
+	defaultWeightIncrement = 100
+)
+
 func NewManager(dbManager db.Manager) Manager {
-	return &productHandler{dbManager}
+	return &productHandler{
+		dbManager: dbManager,
+		now:       time.Now,
+		location:  settings.GetTimeZone(),
+	}
+}
+
+// SalesPrice is the price a product is sold at, at now. It is the price of the
+// first offer valid at now that has one and the base price otherwise
+func SalesPrice(product *entities.ProductCollection, now time.Time) money.Cents {
+	for _, offer := range product.Offers {
+		if offer.Price > 0 && offer.ValidAt(now) {
+			return money.FromFloat(float64(offer.Price))
+		}
+	}
+
+	return product.BasePrice
+}
+
+// GramsPerUnit is the weight of one unit a product sold by weight is priced per, 0 for unknown units
+func GramsPerUnit(unit string) int {
+	switch unit {
+	case UnitKilogram:
+		return 1000
+	case UnitGram:
+		return 1
+	}
+
+	return 0
+}
+
+// WeightUnit is the unit a product sold by weight is priced per, products without a known unit are priced per kg
+func WeightUnit(product *entities.ProductCollection) string {
+	if GramsPerUnit(product.WeightUnit) == 0 {
+		return UnitKilogram
+	}
+
+	return product.WeightUnit
+}
+
+// ParseOfferTime reads a bound of an offer window such as "2019-11-01 04:01:00" in
+// location, an empty bound is open and returned as nil
+func ParseOfferTime(value string, location *time.Location) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.ParseInLocation(offerTimeLayout, value, location)
+	if err != nil {
+		return nil, err
+	}
+
+	return &t, nil
 }
 
 func (p *productHandler) PopulateDefaultData() {
@@ -59,18 +154,102 @@ func (p *productHandler) populateCollectionForModel(products *[]ProductCollectio
 	var dbEntity []entities.ProductCollection
 
 	for _, product := range *products {
-		dbEntity = append(dbEntity, entities.ProductCollection{
-			ID:     product.ID,
-			Images: p.populateArrayImgForModel(product.Images),
-			Name:   product.Name,
-			Offers: product.Offers,
-			Slug:   product.Slug,
-		})
+		productModel := entities.ProductCollection{
+			ID:                 product.ID,
+			Images:             p.populateArrayImgForModel(product.Images),
+			Name:               product.Name,
+			Offers:             p.populateOffersForModel(product),
+			Slug:               product.Slug,
+			BasePrice:          p.parseBasePrice(product),
+			BulkOrderThreshold: product.BulkOrderThreshold,
+		}
+
+		if len(product.StoreSpecificData) > 0 {
+			productModel.Stock = product.StoreSpecificData[0].Stock
+			productModel.UnlimitedStock = product.StoreSpecificData[0].UnlimitedStock
+		}
+		if product.StockOverride != nil && product.StockOverride.MaxPurchasableStock != nil {
+			productModel.MaxPurchasableStock = *product.StockOverride.MaxPurchasableStock
+		}
+		if product.SoldByWeight != 0 {
+			p.populateWeightForModel(product, &productModel)
+		}
+
+		dbEntity = append(dbEntity, productModel)
 	}
 
 	return &dbEntity
 }
 
+// parseBasePrice reads the mrp of the first store, products without one are logged and priced at 0
+func (p *productHandler) parseBasePrice(product ProductCollection) money.Cents {
+	if len(product.StoreSpecificData) == 0 {
+		logger.Log.Warnf("Product:%v has no base price", product.ID)
+		return 0
+	}
+
+	basePrice, err := money.Parse(product.StoreSpecificData[0].Mrp)
+	if err != nil {
+		logger.Log.Warnf("Product:%v has an invalid base price due to: %v", product.ID, err)
+		return 0
+	}
+
+	return basePrice
+}
+
+// populateOffersForModel skips offers with a window that cannot be read rather than selling them forever
+func (p *productHandler) populateOffersForModel(product ProductCollection) []entities.ProductOffers {
+	var offers []entities.ProductOffers
+
+	for _, offer := range product.Offers {
+		validFrom, err := ParseOfferTime(offer.ValidFrom, p.location)
+		if err != nil {
+			logger.Log.Warnf("Skipping offer of product:%v due to: %v", product.ID, err)
+			continue
+		}
+
+		validTill, err := ParseOfferTime(offer.ValidTill, p.location)
+		if err != nil {
+			logger.Log.Warnf("Skipping offer of product:%v due to: %v", product.ID, err)
+			continue
+		}
+
+		offers = append(offers, entities.ProductOffers{
+			Price:     offer.Price,
+			ValidFrom: validFrom,
+			ValidTill: validTill,
+			TimeZone:  p.location.String(),
+		})
+	}
+
+	return offers
+}
+
+// populateWeightForModel prices the product per kg unless its unit of weight is g and
+// converts its stock and limits, which are given in that unit, to grams
+func (p *productHandler) populateWeightForModel(product ProductCollection, productModel *entities.ProductCollection) {
+	productModel.SoldByWeight = true
+	productModel.WeightUnit = UnitKilogram
+	productModel.WeightIncrement = defaultWeightIncrement
+
+	if product.MetaData != nil {
+		switch unit := strings.ToLower(strings.TrimSpace(product.MetaData.UnitOfWeight)); unit {
+		case UnitKilogram, UnitGram:
+			productModel.WeightUnit = unit
+		default:
+			logger.Log.Warnf("Product:%v has unknown unit of weight:%v, pricing it per kg", product.ID, unit)
+		}
+	}
+
+	grams := GramsPerUnit(productModel.WeightUnit)
+	if productModel.Stock != nil {
+		stock := *productModel.Stock * grams
+		productModel.Stock = &stock
+	}
+	productModel.MaxPurchasableStock *= grams
+	productModel.BulkOrderThreshold *= grams
+}
+
 func (p *productHandler) populateArrayImgForModel(images []string) []entities.ProductImages {
 	var productImages []entities.ProductImages
 
@@ -85,6 +264,7 @@ func (p *productHandler) populateArrayImgForModel(images []string) []entities.Pr
 
 func (p *productHandler) populateCollectionForJSON(products *[]entities.ProductCollection) *[]ProductCollection {
 	var dbEntity []ProductCollection
+	now := p.now()
 
 	for _, product := range *products {
 		var img string
@@ -92,11 +272,6 @@ func (p *productHandler) populateCollectionForJSON(products *[]entities.ProductC
 			img = product.Images[0].Value
 		}
 
-		var salesPrice float32
-		if len(product.Offers) > 0 {
-			salesPrice = product.Offers[0].Price
-		}
-
 		dbEntity = append(dbEntity, ProductCollection{
 			ProductCollection: entities.ProductCollection{
 				ID:   product.ID,
@@ -104,7 +279,7 @@ func (p *productHandler) populateCollectionForJSON(products *[]entities.ProductC
 				Slug: product.Slug,
 			},
 			Image:      img,
-			SalesPrice: salesPrice,
+			SalesPrice: SalesPrice(&product, now),
 		})
 	}
 
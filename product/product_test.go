@@ -0,0 +1,100 @@
+package product
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/money"
+)
+
+type fakeProductDB struct {
+	db.Manager
+	products []entities.ProductCollection
+}
+
+func (f *fakeProductDB) GetProductCollection() *[]entities.ProductCollection {
+	return &f.products
+}
+
+func TestSalesPrice(t *testing.T) {
+	singapore := time.FixedZone("+08:00", 8*60*60)
+	validFrom, _ := ParseOfferTime("2019-11-01 04:01:00", singapore)
+	validTill, _ := ParseOfferTime("2019-12-01 04:00:00", singapore)
+
+	product := &entities.ProductCollection{
+		BasePrice: 635,
+		Offers:    []entities.ProductOffers{{Price: 5.8, ValidFrom: validFrom, ValidTill: validTill}},
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want money.Cents
+	}{
+		{
+			name: "Before the offer starts",
+			now:  time.Date(2019, 11, 1, 4, 0, 59, 0, singapore),
+			want: 635,
+		},
+		{
+			name: "Offer starts in its own time zone",
+			now:  time.Date(2019, 10, 31, 20, 1, 0, 0, time.UTC),
+			want: 580,
+		},
+		{
+			name: "Last second of the offer",
+			now:  time.Date(2019, 12, 1, 4, 0, 0, 0, singapore),
+			want: 580,
+		},
+		{
+			name: "Offer expired",
+			now:  time.Date(2019, 12, 1, 4, 0, 1, 0, singapore),
+			want: 635,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SalesPrice(product, tt.now); got != tt.want {
+				t.Errorf("SalesPrice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_productHandler_GetAllProducts(t *testing.T) {
+	singapore := time.FixedZone("+08:00", 8*60*60)
+	p := &productHandler{
+		now:      func() time.Time { return time.Date(2019, 11, 20, 12, 0, 0, 0, singapore) },
+		location: singapore,
+	}
+
+	stock, maxPurchasable := 441, 10
+	var products []ProductCollection
+	products = append(products, ProductCollection{
+		ProductCollection: entities.ProductCollection{ID: 198281},
+		Offers: []Offer{
+			{Price: 5.5, ValidFrom: "2019-11-01 00:00:00", ValidTill: "2019-11-15 23:59:59"},
+			{Price: 5.8, ValidFrom: "2019-11-01 04:01:00", ValidTill: "2019-12-01 04:00:00"},
+			{Price: 1, ValidFrom: "1st of November"},
+		},
+		StoreSpecificData:  []StoreSpecificData{{Mrp: "6.35", Stock: &stock}},
+		StockOverride:      &StockOverride{MaxPurchasableStock: &maxPurchasable},
+		BulkOrderThreshold: 30,
+	})
+
+	model := p.populateCollectionForModel(&products)
+	if got := (*model)[0]; *got.Stock != 441 || got.MaxPurchasableStock != 10 || got.BulkOrderThreshold != 30 {
+		t.Errorf("populateCollectionForModel() limits = %v, %v, %v", *got.Stock, got.MaxPurchasableStock, got.BulkOrderThreshold)
+	}
+	if offers := (*model)[0].Offers; len(offers) != 2 || offers[0].TimeZone != "+08:00" {
+		t.Fatalf("populateCollectionForModel() offers = %+v, want the two offers with a readable window", offers)
+	}
+
+	p.dbManager = &fakeProductDB{products: *model}
+	got := *p.GetAllProducts()
+	if got[0].SalesPrice != 580 {
+		t.Errorf("GetAllProducts() sales price = %v, want the price of the offer that did not expire", got[0].SalesPrice)
+	}
+}
@@ -0,0 +1,186 @@
+package promotions
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/money"
+	"github.com/emanpicar/minimart-api/product"
+	"github.com/emanpicar/minimart-api/settings"
+)
+
+type (
+	Manager interface {
+		PopulateDefaultData()
+		Apply(lines []Line) ([]Discount, error)
+	}
+
+	promotionHandler struct {
+		dbManager db.Manager
+		now       func() time.Time
+		// location is the zone offer windows of the default data are given in
+		location *time.Location
+	}
+
+	// Line is a product in the cart priced at its base price
+	Line struct {
+		ProductID uint
+		Quantity  int
+		UnitPrice money.Cents
+	}
+
+	// Discount explains how a promotion reduces the cart, Times is how often its rule matched
+	Discount struct {
+		PromotionID uint        `json:"promotion_id"`
+		Description string      `json:"description"`
+		Times       int         `json:"times"`
+		Amount      money.Cents `json:"amount"`
+		FreeItems   []FreeItem  `json:"free_items,omitempty"`
+	}
+
+	// FreeItem is a gift of a promotion that is not part of the cart, such as a voucher or a bag
+	FreeItem struct {
+		ProductID uint `json:"product_id"`
+		Quantity  int  `json:"quantity"`
+	}
+
+	productOffers struct {
+		ID     uint    `json:"id"`
+		Offers []offer `json:"offers"`
+	}
+
+	offer struct {
+		ID          uint            `json:"id"`
+		Type        string          `json:"type"`
+		Description string          `json:"description"`
+		Rule        json.RawMessage `json:"rule"`
+		ValidFrom   string          `json:"validFrom"`
+		ValidTill   string          `json:"validTill"`
+	}
+)
+
+func NewManager(dbManager db.Manager) Manager {
+	return &promotionHandler{
+		dbManager: dbManager,
+		now:       time.Now,
+		location:  settings.GetTimeZone(),
+	}
+}
+
+// PopulateDefaultData imports the offers of the default products, an offer
+// listed by several products is stored once and linked to each of them
+func (p *promotionHandler) PopulateDefaultData() {
+	var products []productOffers
+
+	bytesData, err := ioutil.ReadFile("./jsondata/products.json")
+	if err != nil {
+		logger.Log.Errorf("Unable to create default promotions due to: %v", err)
+		return
+	}
+
+	if err = json.Unmarshal(bytesData, &products); err != nil {
+		logger.Log.Errorf("Unable to create default promotions due to: %v", err)
+		return
+	}
+
+	p.dbManager.BatchSavePromotions(p.populatePromotionsForModel(&products))
+}
+
+// Apply evaluates the promotions of the products in lines that are valid now, promotions
+// can be combined and each one is applied as often as its rule and limit allow
+func (p *promotionHandler) Apply(lines []Line) ([]Discount, error) {
+	discounts := []Discount{}
+	now := p.now()
+
+	cart := make(map[uint]Line)
+	var productIDs []uint
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			continue
+		}
+		cart[line.ProductID] = line
+		productIDs = append(productIDs, line.ProductID)
+	}
+
+	promotions, err := p.dbManager.GetPromotionsByProductIDs(productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, promotion := range *promotions {
+		if !promotion.ValidAt(now) {
+			continue
+		}
+
+		var r rule
+		if err := json.Unmarshal([]byte(promotion.Rule), &r); err != nil {
+			logger.Log.Warnf("Skipping promotion:%v due to: %v", promotion.ID, err)
+			continue
+		}
+
+		if discount := evaluate(&promotion, &r, cart); discount != nil {
+			discounts = append(discounts, *discount)
+		}
+	}
+
+	return discounts, nil
+}
+
+func (p *promotionHandler) populatePromotionsForModel(products *[]productOffers) *[]entities.Promotion {
+	var promotions []entities.Promotion
+	index := make(map[uint]int)
+
+	for _, product := range *products {
+		for _, offer := range product.Offers {
+			i, ok := index[offer.ID]
+			if !ok {
+				if !IsSupportedType(offer.Type) {
+					logger.Log.Warnf("Promotion:%v has unsupported type:%v", offer.ID, offer.Type)
+				}
+
+				validFrom, validTill, err := p.parseWindow(offer)
+				if err != nil {
+					logger.Log.Warnf("Skipping promotion:%v due to: %v", offer.ID, err)
+					continue
+				}
+
+				i = len(promotions)
+				index[offer.ID] = i
+				promotions = append(promotions, entities.Promotion{
+					ID:          offer.ID,
+					Type:        offer.Type,
+					Description: offer.Description,
+					Rule:        string(offer.Rule),
+					ValidFrom:   validFrom,
+					ValidTill:   validTill,
+					TimeZone:    p.location.String(),
+				})
+			}
+
+			promotions[i].Products = append(promotions[i].Products, entities.PromotionProduct{
+				PromotionID: offer.ID,
+				ProductID:   product.ID,
+			})
+		}
+	}
+
+	return &promotions
+}
+
+func (p *promotionHandler) parseWindow(offer offer) (*time.Time, *time.Time, error) {
+	validFrom, err := product.ParseOfferTime(offer.ValidFrom, p.location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	validTill, err := product.ParseOfferTime(offer.ValidTill, p.location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return validFrom, validTill, nil
+}
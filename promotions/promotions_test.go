@@ -0,0 +1,120 @@
+package promotions
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+type fakePromotionDB struct {
+	db.Manager
+	promotions []entities.Promotion
+}
+
+func (f *fakePromotionDB) GetPromotionsByProductIDs(productIDs []uint) (*[]entities.Promotion, error) {
+	return &f.promotions, nil
+}
+
+func Test_promotionHandler_populatePromotionsForModel(t *testing.T) {
+	var products []productOffers
+	err := json.Unmarshal([]byte(`[
+		{"id":198282,"offers":[
+			{"id":275757,"type":"BANYGYD","description":"Buy 3 Free Voucher","rule":{"quantity":3},"validFrom":"2019-11-01 00:00:00","validTill":"2019-11-15 23:59:59"},
+			{"id":261604,"type":"BXATP","description":"Buy 1 Meiji Low Fat Fresh Milk - Regular @ $0.55 Off","rule":{"buy":{"198282":{"q":1}}}}
+		]},
+		{"id":1114804,"offers":[
+			{"id":275757,"type":"BANYGYD","description":"Buy 3 Free Voucher","rule":{"quantity":3}}
+		]},
+		{"id":193151,"offers":null}
+	]`), &products)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	singapore := time.FixedZone("+08:00", 8*60*60)
+	p := &promotionHandler{location: singapore}
+	got := *p.populatePromotionsForModel(&products)
+
+	if len(got) != 2 {
+		t.Fatalf("populatePromotionsForModel() = %+v, want 2 promotions", got)
+	}
+	if got[0].ID != 275757 || len(got[0].Products) != 2 || got[0].Products[1].ProductID != 1114804 {
+		t.Errorf("populatePromotionsForModel() did not link the shared offer to both products, got %+v", got[0])
+	}
+	if want := time.Date(2019, 11, 15, 23, 59, 59, 0, singapore); got[0].ValidTill == nil || !got[0].ValidTill.Equal(want) || got[0].ValidFrom == nil {
+		t.Errorf("populatePromotionsForModel() window = %v - %v, want until %v", got[0].ValidFrom, got[0].ValidTill, want)
+	}
+	if got[1].Rule != `{"buy":{"198282":{"q":1}}}` {
+		t.Errorf("populatePromotionsForModel() rule = %v", got[1].Rule)
+	}
+}
+
+func Test_promotionHandler_Apply(t *testing.T) {
+	fakeDB := &fakePromotionDB{promotions: []entities.Promotion{
+		{
+			ID:          261604,
+			Type:        TypeBuyXAtPrice,
+			Description: "Buy 1 Meiji Fresh Milk - Regular @ $0.55 Off",
+			Rule:        `{"buy":{"198281":{"q":1}},"total":{"t":"ABSOLUTE_OFF","v":0.55}}`,
+			Products:    []entities.PromotionProduct{{PromotionID: 261604, ProductID: 198281}},
+		},
+		{
+			ID:          1,
+			Type:        TypeBuyXAtPrice,
+			Description: "Broken rule",
+			Rule:        `{"buy":`,
+			Products:    []entities.PromotionProduct{{PromotionID: 1, ProductID: 198281}},
+		},
+	}}
+
+	got, err := NewManager(fakeDB).Apply([]Line{{ProductID: 198281, Quantity: 3, UnitPrice: 635}})
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	if len(got) != 1 || got[0].PromotionID != 261604 || got[0].Times != 3 || got[0].Amount != 165 {
+		t.Errorf("Apply() = %+v, want 3 times 0.55 off", got)
+	}
+}
+
+func Test_promotionHandler_Apply_validity(t *testing.T) {
+	singapore := time.FixedZone("+08:00", 8*60*60)
+	validFrom := time.Date(2019, 11, 1, 4, 1, 0, 0, singapore)
+	validTill := time.Date(2019, 12, 1, 4, 0, 0, 0, singapore)
+
+	fakeDB := &fakePromotionDB{promotions: []entities.Promotion{{
+		ID:          261604,
+		Type:        TypeBuyXAtPrice,
+		Description: "Buy 1 Meiji Fresh Milk - Regular @ $0.55 Off",
+		Rule:        `{"buy":{"198281":{"q":1}},"total":{"t":"ABSOLUTE_OFF","v":0.55}}`,
+		Products:    []entities.PromotionProduct{{PromotionID: 261604, ProductID: 198281}},
+		ValidFrom:   &validFrom,
+		ValidTill:   &validTill,
+	}}}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want int
+	}{
+		{name: "Not started", now: validFrom.Add(-time.Second), want: 0},
+		{name: "Within the window", now: validFrom, want: 1},
+		{name: "Expired", now: validTill.Add(time.Second), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &promotionHandler{dbManager: fakeDB, now: func() time.Time { return tt.now }, location: singapore}
+
+			got, err := p.Apply([]Line{{ProductID: 198281, Quantity: 1, UnitPrice: 635}})
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("Apply() = %+v, want %v discounts", got, tt.want)
+			}
+		})
+	}
+}
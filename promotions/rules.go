@@ -0,0 +1,283 @@
+package promotions
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/money"
+)
+
+type (
+	// rule is the rule payload of an offer, which members are used depends on the promotion type
+	rule struct {
+		Buy           map[string]ruleQuantity `json:"buy"`
+		Get           map[string]ruleQuantity `json:"get"`
+		Total         *ruleTotal              `json:"total"`
+		Limit         *int                    `json:"limit"`
+		Quantity      int                     `json:"quantity"`
+		Variants      []uint                  `json:"variants"`
+		ElementGroups []ruleElementGroup      `json:"elementGroups"`
+	}
+
+	ruleQuantity struct {
+		Q int `json:"q"`
+	}
+
+	// ruleTotal is the reduction, V is an amount for ABSOLUTE_OFF and a percentage for PERCENT_OFF
+	ruleTotal struct {
+		T string  `json:"t"`
+		V float64 `json:"v"`
+	}
+
+	ruleElementGroup struct {
+		Get       map[string]ruleQuantity `json:"get"`
+		MinAmount float64                 `json:"minAmount"`
+		Total     *ruleTotal              `json:"total"`
+	}
+)
+
+const (
+	// TypeBuyXAtPrice reduces the price of the buy products for every complete set of them
+	TypeBuyXAtPrice = "BXATP"
+	// TypeBuyAnyAtPrice reduces the price for every quantity items of any of the variants
+	TypeBuyAnyAtPrice = "BANYATP"
+	// TypeBuyAnyGetDiscount discounts the get products for every quantity items of the linked products
+	TypeBuyAnyGetDiscount = "BANYGYD"
+	// TypeMinAmountGetFree discounts the get products of every element group once the linked products reach minAmount
+	TypeMinAmountGetFree = "BMINXGFG"
+
+	absoluteOff = "ABSOLUTE_OFF"
+	percentOff  = "PERCENT_OFF"
+)
+
+// IsSupportedType reports whether promotions of promotionType are applied to carts
+func IsSupportedType(promotionType string) bool {
+	switch promotionType {
+	case TypeBuyXAtPrice, TypeBuyAnyAtPrice, TypeBuyAnyGetDiscount, TypeMinAmountGetFree:
+		return true
+	}
+
+	return false
+}
+
+// evaluate returns the discount of promotion for cart or nil when its rule does not match.
+// Categories are not part of the catalog, the products linked to the promotion stand in
+// for the category of BANYGYD and BMINXGFG rules.
+func evaluate(promotion *entities.Promotion, r *rule, cart map[uint]Line) *Discount {
+	discount := &Discount{PromotionID: promotion.ID, Description: promotion.Description}
+
+	switch promotion.Type {
+	case TypeBuyXAtPrice:
+		times, setValue := buySets(r.Buy, cart)
+		discount.Times = applyLimit(times, r.Limit)
+		discount.Amount = reduction(r.Total, setValue).Times(discount.Times)
+	case TypeBuyAnyAtPrice:
+		eligible := r.Variants
+		if len(eligible) == 0 {
+			eligible = linkedProducts(promotion)
+		}
+
+		lines := eligibleLines(eligible, cart)
+		if r.Quantity <= 0 {
+			return nil
+		}
+
+		discount.Times = applyLimit(countUnits(lines)/r.Quantity, r.Limit)
+		cheapest := cheapestUnitsValue(lines, discount.Times*r.Quantity)
+		if r.Total != nil && r.Total.T == absoluteOff {
+			discount.Amount = minCents(money.FromFloat(r.Total.V).Times(discount.Times), cheapest)
+		} else {
+			discount.Amount = reduction(r.Total, cheapest)
+		}
+	case TypeBuyAnyGetDiscount:
+		if r.Quantity <= 0 {
+			return nil
+		}
+
+		lines := eligibleLines(linkedProducts(promotion), cart)
+		discount.Times = applyLimit(countUnits(lines)/r.Quantity, r.Limit)
+		discount.Amount, discount.FreeItems = getItems(r.Get, r.Total, discount.Times, cart)
+	case TypeMinAmountGetFree:
+		var spent money.Cents
+		for _, line := range eligibleLines(linkedProducts(promotion), cart) {
+			spent += line.UnitPrice.Times(line.Quantity)
+		}
+
+		for _, group := range r.ElementGroups {
+			if spent < money.FromFloat(group.MinAmount) {
+				continue
+			}
+
+			amount, freeItems := getItems(group.Get, group.Total, 1, cart)
+			discount.Times = 1
+			discount.Amount += amount
+			discount.FreeItems = append(discount.FreeItems, freeItems...)
+		}
+	default:
+		return nil
+	}
+
+	if discount.Times <= 0 || (discount.Amount <= 0 && len(discount.FreeItems) == 0) {
+		return nil
+	}
+
+	return discount
+}
+
+// buySets returns how many complete sets of the buy products are in the cart and the value of one set
+func buySets(buy map[string]ruleQuantity, cart map[uint]Line) (int, money.Cents) {
+	if len(buy) == 0 {
+		return 0, 0
+	}
+
+	times := math.MaxInt32
+	var setValue money.Cents
+	for key, quantity := range buy {
+		productID, err := strconv.ParseUint(key, 10, 32)
+		if err != nil || quantity.Q <= 0 {
+			return 0, 0
+		}
+
+		line := cart[uint(productID)]
+		if sets := line.Quantity / quantity.Q; sets < times {
+			times = sets
+		}
+		setValue += line.UnitPrice.Times(quantity.Q)
+	}
+
+	return times, setValue
+}
+
+// getItems discounts the get products in the cart for times matches of the rule,
+// get products that are not in the cart are handed out as free items
+func getItems(get map[string]ruleQuantity, total *ruleTotal, times int, cart map[uint]Line) (money.Cents, []FreeItem) {
+	var amount money.Cents
+	var freeItems []FreeItem
+
+	for _, productID := range sortedProductIDs(get) {
+		quantity := get[strconv.FormatUint(uint64(productID), 10)].Q * times
+		if quantity <= 0 {
+			continue
+		}
+
+		line := cart[productID]
+		covered := line.Quantity
+		if covered > quantity {
+			covered = quantity
+		}
+		if covered > 0 {
+			amount += reduction(total, line.UnitPrice.Times(covered))
+		}
+
+		if quantity > covered && total != nil && total.T == percentOff && total.V >= 100 {
+			freeItems = append(freeItems, FreeItem{ProductID: productID, Quantity: quantity - covered})
+		}
+	}
+
+	return amount, freeItems
+}
+
+// reduction is the amount taken off value, it never exceeds value
+func reduction(total *ruleTotal, value money.Cents) money.Cents {
+	if total == nil || value <= 0 {
+		return 0
+	}
+
+	switch total.T {
+	case absoluteOff:
+		return minCents(money.FromFloat(total.V), value)
+	case percentOff:
+		return minCents(value.Percent(int64(math.Round(total.V*100))), value)
+	}
+
+	return 0
+}
+
+func applyLimit(times int, limit *int) int {
+	if limit != nil && *limit > 0 && times > *limit {
+		return *limit
+	}
+
+	return times
+}
+
+func linkedProducts(promotion *entities.Promotion) []uint {
+	var productIDs []uint
+	for _, product := range promotion.Products {
+		productIDs = append(productIDs, product.ProductID)
+	}
+
+	return productIDs
+}
+
+func eligibleLines(productIDs []uint, cart map[uint]Line) []Line {
+	var lines []Line
+	seen := make(map[uint]bool)
+	for _, productID := range productIDs {
+		if line, ok := cart[productID]; ok && !seen[productID] {
+			seen[productID] = true
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func countUnits(lines []Line) int {
+	var units int
+	for _, line := range lines {
+		units += line.Quantity
+	}
+
+	return units
+}
+
+// cheapestUnitsValue is the value of the units cheapest units of lines, the
+// reduction of a buy any rule is never worth more than the units it applies to
+func cheapestUnitsValue(lines []Line, units int) money.Cents {
+	sorted := append([]Line{}, lines...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].UnitPrice < sorted[j].UnitPrice
+	})
+
+	var value money.Cents
+	for _, line := range sorted {
+		if units <= 0 {
+			break
+		}
+
+		quantity := line.Quantity
+		if quantity > units {
+			quantity = units
+		}
+		value += line.UnitPrice.Times(quantity)
+		units -= quantity
+	}
+
+	return value
+}
+
+func sortedProductIDs(quantities map[string]ruleQuantity) []uint {
+	var productIDs []uint
+	for key := range quantities {
+		if productID, err := strconv.ParseUint(key, 10, 32); err == nil {
+			productIDs = append(productIDs, uint(productID))
+		}
+	}
+
+	sort.Slice(productIDs, func(i, j int) bool {
+		return productIDs[i] < productIDs[j]
+	})
+
+	return productIDs
+}
+
+func minCents(a, b money.Cents) money.Cents {
+	if a < b {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,103 @@
+package promotions
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+func Test_evaluate(t *testing.T) {
+	promotion := func(promotionType, description, rule string, productIDs ...uint) *entities.Promotion {
+		data := &entities.Promotion{ID: 1, Type: promotionType, Description: description, Rule: rule}
+		for _, productID := range productIDs {
+			data.Products = append(data.Products, entities.PromotionProduct{PromotionID: 1, ProductID: productID})
+		}
+		return data
+	}
+
+	tests := []struct {
+		name      string
+		promotion *entities.Promotion
+		cart      []Line
+		want      *Discount
+	}{
+		{
+			name: "BXATP absolute off for every set",
+			promotion: promotion(TypeBuyXAtPrice, "Buy 1 Meiji Fresh Milk - Regular @ $0.55 Off",
+				`{"buy":{"198281":{"q":1}},"total":{"t":"ABSOLUTE_OFF","v":0.55}}`, 198281),
+			cart: []Line{{ProductID: 198281, Quantity: 2, UnitPrice: 635}},
+			want: &Discount{PromotionID: 1, Description: "Buy 1 Meiji Fresh Milk - Regular @ $0.55 Off", Times: 2, Amount: 110},
+		},
+		{
+			name: "BXATP incomplete set",
+			promotion: promotion(TypeBuyXAtPrice, "Buy 2 Marigold 100% Fresh Milk @ $0.55 Off",
+				`{"buy":{"193183":{"q":2}},"total":{"t":"ABSOLUTE_OFF","v":0.55}}`, 193183),
+			cart: []Line{{ProductID: 193183, Quantity: 1, UnitPrice: 310}},
+		},
+		{
+			name: "BXATP percent off within the limit",
+			promotion: promotion(TypeBuyXAtPrice, "Buy 2 @ 10% Off",
+				`{"buy":{"1":{"q":2}},"total":{"t":"PERCENT_OFF","v":10},"limit":1}`, 1),
+			cart: []Line{{ProductID: 1, Quantity: 5, UnitPrice: 333}},
+			want: &Discount{PromotionID: 1, Description: "Buy 2 @ 10% Off", Times: 1, Amount: 67},
+		},
+		{
+			name: "BANYATP mixes variants",
+			promotion: promotion(TypeBuyAnyAtPrice, "Buy any 2 and get @ $0.75 Off",
+				`{"quantity":2,"variants":[193151,193156],"total":{"t":"ABSOLUTE_OFF","v":0.75}}`, 193151, 193156),
+			cart: []Line{{ProductID: 193151, Quantity: 1, UnitPrice: 330}, {ProductID: 193156, Quantity: 2, UnitPrice: 330}},
+			want: &Discount{PromotionID: 1, Description: "Buy any 2 and get @ $0.75 Off", Times: 1, Amount: 75},
+		},
+		{
+			name: "BANYATP percent off the cheapest units",
+			promotion: promotion(TypeBuyAnyAtPrice, "Buy any 2 @ 50% Off",
+				`{"quantity":2,"variants":[1,2],"total":{"t":"PERCENT_OFF","v":50}}`, 1, 2),
+			cart: []Line{{ProductID: 1, Quantity: 1, UnitPrice: 500}, {ProductID: 2, Quantity: 2, UnitPrice: 100}},
+			want: &Discount{PromotionID: 1, Description: "Buy any 2 @ 50% Off", Times: 1, Amount: 100},
+		},
+		{
+			name: "BANYGYD hands out the gift",
+			promotion: promotion(TypeBuyAnyGetDiscount, "Buy 3 Free Voucher",
+				`{"entity":{"CATEGORY":[1]},"get":{"1129580":{"q":1}},"limit":1,"quantity":3,"total":{"t":"PERCENT_OFF","v":100}}`, 198282, 1114804),
+			cart: []Line{{ProductID: 198282, Quantity: 2, UnitPrice: 635}, {ProductID: 1114804, Quantity: 5, UnitPrice: 320}},
+			want: &Discount{PromotionID: 1, Description: "Buy 3 Free Voucher", Times: 1, FreeItems: []FreeItem{{ProductID: 1129580, Quantity: 1}}},
+		},
+		{
+			name: "BMINXGFG discounts the gift in the cart",
+			promotion: promotion(TypeMinAmountGetFree, "Buy 2 Milk or Yoghurt Free Bag",
+				`{"elementGroups":[{"get":{"7":{"q":1}},"minAmount":5,"total":{"t":"PERCENT_OFF","v":100}}]}`, 1114860),
+			cart: []Line{{ProductID: 1114860, Quantity: 2, UnitPrice: 350}, {ProductID: 7, Quantity: 3, UnitPrice: 20}},
+			want: &Discount{PromotionID: 1, Description: "Buy 2 Milk or Yoghurt Free Bag", Times: 1, Amount: 20},
+		},
+		{
+			name: "BMINXGFG below the minimum amount",
+			promotion: promotion(TypeMinAmountGetFree, "Buy 2 Milk or Yoghurt Free Bag",
+				`{"elementGroups":[{"get":{"7":{"q":1}},"minAmount":5,"total":{"t":"PERCENT_OFF","v":100}}]}`, 1114860),
+			cart: []Line{{ProductID: 1114860, Quantity: 1, UnitPrice: 350}},
+		},
+		{
+			name:      "Unsupported type",
+			promotion: promotion("BXGY", "Buy 1 Get 1", `{"buy":{"1":{"q":1}}}`, 1),
+			cart:      []Line{{ProductID: 1, Quantity: 2, UnitPrice: 100}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r rule
+			if err := json.Unmarshal([]byte(tt.promotion.Rule), &r); err != nil {
+				t.Fatal(err)
+			}
+
+			cart := make(map[uint]Line)
+			for _, line := range tt.cart {
+				cart[line.ProductID] = line
+			}
+
+			if got := evaluate(tt.promotion, &r, cart); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,13 +2,19 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/emanpicar/minimart-api/auth"
 
 	"github.com/emanpicar/minimart-api/cart"
 	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/order"
+	"github.com/emanpicar/minimart-api/payment"
 	"github.com/emanpicar/minimart-api/product"
+	"github.com/emanpicar/minimart-api/user"
+	"github.com/emanpicar/minimart-api/wishlist"
 	"github.com/gorilla/mux"
 )
 
@@ -18,22 +24,34 @@ type (
 	}
 
 	routeHandler struct {
-		productManager product.Manager
-		cartManager    cart.Manager
-		authManager    auth.Manager
-		router         *mux.Router
+		productManager  product.Manager
+		cartManager     cart.Manager
+		authManager     auth.Manager
+		userManager     user.Manager
+		wishlistManager wishlist.Manager
+		orderManager    order.Manager
+		router          *mux.Router
 	}
 
 	JsonMessage struct {
 		Message string `json:"message"`
 	}
+
+	// QuantityMessage adds the product and the violated limit to the message of a rejected cart quantity
+	QuantityMessage struct {
+		Message string `json:"message"`
+		*cart.QuantityError
+	}
 )
 
-func NewRouter(productManager product.Manager, cartManager cart.Manager, authManager auth.Manager) Router {
+func NewRouter(productManager product.Manager, cartManager cart.Manager, authManager auth.Manager, userManager user.Manager, wishlistManager wishlist.Manager, orderManager order.Manager) Router {
 	routeHandler := &routeHandler{
-		productManager: productManager,
-		cartManager:    cartManager,
-		authManager:    authManager,
+		productManager:  productManager,
+		cartManager:     cartManager,
+		authManager:     authManager,
+		userManager:     userManager,
+		wishlistManager: wishlistManager,
+		orderManager:    orderManager,
 	}
 
 	return routeHandler.newRouter()
@@ -47,21 +65,102 @@ func (rh *routeHandler) newRouter() *mux.Router {
 }
 
 func (rh *routeHandler) registerRoutes(router *mux.Router) {
+	router.HandleFunc("/.well-known/jwks.json", rh.getJWKS).Methods("GET")
 	router.HandleFunc("/api/authenticate", rh.authenticate).Methods("POST")
-	router.HandleFunc("/api/products", rh.authMiddleware(rh.getAllProducts)).Methods("GET")
-	router.HandleFunc("/api/carts", rh.authMiddleware(rh.getAllCarts)).Methods("GET")
-	router.HandleFunc("/api/carts", rh.authMiddleware(rh.addToCart)).Methods("POST")
-	router.HandleFunc("/api/carts/{productId}", rh.authMiddleware(rh.updateCart)).Methods("PUT")
-	router.HandleFunc("/api/carts/{productId}", rh.authMiddleware(rh.deleteCart)).Methods("DELETE")
+	router.HandleFunc("/api/token/refresh", rh.refreshToken).Methods("POST")
+	router.HandleFunc("/api/logout", rh.authMiddleware(rh.logout)).Methods("POST")
+	router.HandleFunc("/api/users", rh.registerUser).Methods("POST")
+	router.HandleFunc("/api/users/me", rh.authMiddleware(rh.getProfile)).Methods("GET")
+	router.HandleFunc("/api/users/me", rh.authMiddleware(rh.updateProfile)).Methods("PUT")
+	router.HandleFunc("/api/users/me/password", rh.authMiddleware(rh.changePassword)).Methods("POST")
+	router.HandleFunc("/api/users/{userId}/role", rh.authMiddleware(rh.requireRole(rh.updateRole, auth.RoleAdmin))).Methods("PUT")
+	router.HandleFunc("/api/apikeys", rh.authMiddleware(rh.requireRole(rh.createAPIKey, auth.RoleAdmin))).Methods("POST")
+	router.HandleFunc("/api/apikeys", rh.authMiddleware(rh.requireRole(rh.getAllAPIKeys, auth.RoleAdmin))).Methods("GET")
+	router.HandleFunc("/api/apikeys/{keyId}", rh.authMiddleware(rh.requireRole(rh.revokeAPIKey, auth.RoleAdmin))).Methods("DELETE")
+	router.HandleFunc("/api/products", rh.authMiddleware(rh.requireScope(rh.getAllProducts, auth.ScopeProductsRead))).Methods("GET")
+	router.HandleFunc("/api/guest-carts", rh.createGuestCart).Methods("POST")
+	router.HandleFunc("/api/carts", rh.cartMiddleware(rh.getAllCarts, auth.ScopeCartsRead)).Methods("GET")
+	router.HandleFunc("/api/carts", rh.cartMiddleware(rh.addToCart, auth.ScopeCartsWrite)).Methods("POST")
+	router.HandleFunc("/api/carts", rh.cartMiddleware(rh.batchUpdateCart, auth.ScopeCartsWrite)).Methods("PATCH")
+	router.HandleFunc("/api/carts/{productId}", rh.cartMiddleware(rh.updateCart, auth.ScopeCartsWrite)).Methods("PUT")
+	router.HandleFunc("/api/carts/{productId}", rh.cartMiddleware(rh.deleteCart, auth.ScopeCartsWrite)).Methods("DELETE")
+	router.HandleFunc("/api/orders", rh.authMiddleware(rh.requireScope(rh.placeOrder, auth.ScopeOrdersWrite))).Methods("POST")
+	router.HandleFunc("/api/orders", rh.authMiddleware(rh.requireScope(rh.getAllOrders, auth.ScopeOrdersRead))).Methods("GET")
+	router.HandleFunc("/api/orders/{orderId}", rh.authMiddleware(rh.requireScope(rh.getOrder, auth.ScopeOrdersRead))).Methods("GET")
+	router.HandleFunc("/api/orders/{orderId}/status", rh.authMiddleware(rh.requireScope(rh.updateOrderStatus, auth.ScopeOrdersWrite))).Methods("PUT")
+	router.HandleFunc("/api/orders/{orderId}/payment", rh.authMiddleware(rh.requireScope(rh.payOrder, auth.ScopeOrdersWrite))).Methods("POST")
+	router.HandleFunc("/api/payments/webhook", rh.receivePaymentEvent).Methods("POST")
+	router.HandleFunc("/api/wishlists", rh.authMiddleware(rh.requireScope(rh.getAllWishlists, auth.ScopeWishlistsRead))).Methods("GET")
+	router.HandleFunc("/api/wishlists", rh.authMiddleware(rh.requireScope(rh.createWishlist, auth.ScopeWishlistsWrite))).Methods("POST")
+	router.HandleFunc("/api/wishlists/{wishlistId}", rh.authMiddleware(rh.requireScope(rh.getWishlist, auth.ScopeWishlistsRead))).Methods("GET")
+	router.HandleFunc("/api/wishlists/{wishlistId}", rh.authMiddleware(rh.requireScope(rh.renameWishlist, auth.ScopeWishlistsWrite))).Methods("PUT")
+	router.HandleFunc("/api/wishlists/{wishlistId}", rh.authMiddleware(rh.requireScope(rh.deleteWishlist, auth.ScopeWishlistsWrite))).Methods("DELETE")
+	router.HandleFunc("/api/wishlists/{wishlistId}/items", rh.authMiddleware(rh.requireScope(rh.addToWishlist, auth.ScopeWishlistsWrite))).Methods("POST")
+	router.HandleFunc("/api/wishlists/{wishlistId}/items/{productId}", rh.authMiddleware(rh.requireScope(rh.deleteFromWishlist, auth.ScopeWishlistsWrite))).Methods("DELETE")
+	// Moves change the cart as well as the wishlist
+	router.HandleFunc("/api/wishlists/{wishlistId}/items/{productId}/move-from-cart", rh.authMiddleware(rh.requireScope(rh.requireScope(rh.moveFromCart, auth.ScopeCartsWrite), auth.ScopeWishlistsWrite))).Methods("POST")
+	router.HandleFunc("/api/wishlists/{wishlistId}/items/{productId}/move-to-cart", rh.authMiddleware(rh.requireScope(rh.requireScope(rh.moveToCart, auth.ScopeCartsWrite), auth.ScopeWishlistsWrite))).Methods("POST")
 
 	rh.router = router
 }
 
+func (rh *routeHandler) getJWKS(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Getting JWKS")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	data := rh.authManager.JWKS()
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
 func (rh *routeHandler) authenticate(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Infoln("Authenticating user")
 
 	w.Header().Set("Content-Type", "application/json")
-	data, err := rh.authManager.Authenticate(r.Body)
+	data, err := rh.authManager.Authenticate(r)
+
+	var lockedErr *auth.LockedError
+	if errors.As(err, &lockedErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(lockedErr.RetryAfterSeconds()))
+		w.WriteHeader(http.StatusTooManyRequests)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		w.WriteHeader(http.StatusUnauthorized)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	if cartToken := r.Header.Get(cart.CartTokenHeader); cartToken != "" {
+		// The login succeeded, a guest cart that can not be merged stays available under its token
+		if err := rh.cartManager.MergeGuestCart(cartToken, data.Subject); err != nil {
+			logger.Log.Warnf("Unable to merge guest cart into cart of user:%v due to: %v", data.Subject, err)
+		}
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) refreshToken(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Refreshing token")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.authManager.Refresh(r.Body)
+	if errors.Is(err, auth.ErrInvalidRefreshToken) {
+		w.WriteHeader(http.StatusUnauthorized)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
@@ -71,6 +170,129 @@ func (rh *routeHandler) authenticate(w http.ResponseWriter, r *http.Request) {
 	rh.encodeError(json.NewEncoder(w).Encode(data), w)
 }
 
+func (rh *routeHandler) logout(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Logging out user")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.authManager.Logout(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) registerUser(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Registering user")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.userManager.Register(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) getProfile(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Getting user profile")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.userManager.GetProfile(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) updateProfile(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Updating user profile")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.userManager.UpdateProfile(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) changePassword(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Changing user password")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.userManager.ChangePassword(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) updateRole(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Updating role of user id:%v", mux.Vars(r)["userId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.userManager.UpdateRole(r, mux.Vars(r)["userId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Creating API key")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.authManager.CreateAPIKey(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) getAllAPIKeys(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Getting all API keys")
+
+	w.Header().Set("Content-Type", "application/json")
+	data := rh.authManager.GetAllAPIKeys()
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Revoking API key by id:%v", mux.Vars(r)["keyId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.authManager.RevokeAPIKey(mux.Vars(r)["keyId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
 func (rh *routeHandler) getAllProducts(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Infoln("Getting all products")
 
@@ -80,11 +302,31 @@ func (rh *routeHandler) getAllProducts(w http.ResponseWriter, r *http.Request) {
 	rh.encodeError(json.NewEncoder(w).Encode(data), w)
 }
 
+func (rh *routeHandler) createGuestCart(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Creating guest cart")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.cartManager.CreateGuestCart()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
 func (rh *routeHandler) getAllCarts(w http.ResponseWriter, r *http.Request) {
 	logger.Log.Infoln("Getting all carts")
 
 	w.Header().Set("Content-Type", "application/json")
-	data := rh.cartManager.GetAllCarts(r)
+	data, err := rh.cartManager.GetAllCarts(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
 
 	rh.encodeError(json.NewEncoder(w).Encode(data), w)
 }
@@ -94,6 +336,10 @@ func (rh *routeHandler) addToCart(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	data, err := rh.cartManager.AddToCart(r)
+	if rh.writeQuantityError(w, err) {
+		return
+	}
+
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
@@ -108,6 +354,28 @@ func (rh *routeHandler) updateCart(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	data, err := rh.cartManager.UpdateCart(r, mux.Vars(r)["productId"])
+	if rh.writeQuantityError(w, err) {
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) batchUpdateCart(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Updating cart in batch")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.cartManager.BatchUpdateCart(r)
+	if rh.writeQuantityError(w, err) {
+		return
+	}
+
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
@@ -131,16 +399,322 @@ func (rh *routeHandler) deleteCart(w http.ResponseWriter, r *http.Request) {
 	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
 }
 
+func (rh *routeHandler) placeOrder(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Placing order")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.orderManager.PlaceOrder(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) getAllOrders(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Getting all orders")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.orderManager.GetAllOrders(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) getOrder(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Getting order by id:%v", mux.Vars(r)["orderId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.orderManager.GetOrder(r, mux.Vars(r)["orderId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) updateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Updating status of order by id:%v", mux.Vars(r)["orderId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.orderManager.UpdateStatus(r, mux.Vars(r)["orderId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) payOrder(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Paying order by id:%v", mux.Vars(r)["orderId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.orderManager.PayOrder(r, mux.Vars(r)["orderId"])
+	if errors.Is(err, payment.ErrDeclined) {
+		w.WriteHeader(http.StatusPaymentRequired)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) receivePaymentEvent(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Receiving payment event")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.orderManager.HandlePaymentWebhook(r)
+	if errors.Is(err, payment.ErrInvalidSignature) {
+		w.WriteHeader(http.StatusUnauthorized)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) getAllWishlists(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Getting all wishlists")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.GetAllWishlists(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) createWishlist(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infoln("Creating wishlist")
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.CreateWishlist(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) getWishlist(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Getting wishlist by id:%v", mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.GetWishlist(r, mux.Vars(r)["wishlistId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) renameWishlist(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Renaming wishlist by id:%v", mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.RenameWishlist(r, mux.Vars(r)["wishlistId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(data), w)
+}
+
+func (rh *routeHandler) deleteWishlist(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Deleting wishlist by id:%v", mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.DeleteWishlist(r, mux.Vars(r)["wishlistId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) addToWishlist(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Adding to wishlist by id:%v", mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.AddToWishlist(r, mux.Vars(r)["wishlistId"])
+	if rh.writeQuantityError(w, err) {
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) deleteFromWishlist(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Deleting product:%v in wishlist by id:%v", mux.Vars(r)["productId"], mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.DeleteFromWishlist(r, mux.Vars(r)["wishlistId"], mux.Vars(r)["productId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) moveFromCart(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Moving product:%v from cart to wishlist by id:%v", mux.Vars(r)["productId"], mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.MoveFromCart(r, mux.Vars(r)["wishlistId"], mux.Vars(r)["productId"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+func (rh *routeHandler) moveToCart(w http.ResponseWriter, r *http.Request) {
+	logger.Log.Infof("Moving product:%v from wishlist by id:%v to cart", mux.Vars(r)["productId"], mux.Vars(r)["wishlistId"])
+
+	w.Header().Set("Content-Type", "application/json")
+	data, err := rh.wishlistManager.MoveToCart(r, mux.Vars(r)["wishlistId"], mux.Vars(r)["productId"])
+	if rh.writeQuantityError(w, err) {
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+		return
+	}
+
+	rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{data}), w)
+}
+
+// writeQuantityError responds with the product and limit when err is a rejected cart quantity
+func (rh *routeHandler) writeQuantityError(w http.ResponseWriter, err error) bool {
+	var quantityErr *cart.QuantityError
+	if !errors.As(err, &quantityErr) {
+		return false
+	}
+
+	w.WriteHeader(http.StatusBadRequest)
+	rh.encodeError(json.NewEncoder(w).Encode(&QuantityMessage{err.Error(), quantityErr}), w)
+
+	return true
+}
+
 func (rh *routeHandler) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := rh.authManager.ValidateRequest(r)
+		validated, err := rh.authManager.ValidateRequest(r)
 		if err != nil {
+			challenge := `Bearer realm="minimart-api"`
+			if r.Header.Get("Authorization") != "" || r.Header.Get(auth.APIKeyHeader) != "" {
+				challenge += `, error="invalid_token"`
+			}
+
+			w.Header().Set("WWW-Authenticate", challenge)
+			w.WriteHeader(http.StatusUnauthorized)
+			rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
+			return
+		}
+
+		next(w, validated)
+	})
+}
+
+// cartMiddleware lets anonymous shoppers use their guest cart with only a cart token,
+// requests with credentials are authenticated and need scope like any other route
+func (rh *routeHandler) cartMiddleware(next http.HandlerFunc, scope string) http.HandlerFunc {
+	authenticated := rh.authMiddleware(rh.requireScope(next, scope))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cartToken := r.Header.Get(cart.CartTokenHeader)
+		if cartToken == "" || r.Header.Get("Authorization") != "" || r.Header.Get(auth.APIKeyHeader) != "" {
+			authenticated(w, r)
+			return
+		}
+
+		principal, err := cart.GuestPrincipal(cartToken)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusBadRequest)
 			rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{err.Error()}), w)
 			return
 		}
 
-		next(w, r)
+		next(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// requireRole only lets the request through when the principal role is one of roles,
+// it has to be wrapped by authMiddleware so the principal is available
+func (rh *routeHandler) requireRole(next http.HandlerFunc, roles ...string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := auth.PrincipalFromRequest(r)
+		if err == nil && principal.HasRole(roles...) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{"Insufficient role to access this resource"}), w)
+	})
+}
+
+// requireScope rejects API keys without scope, user tokens are not restricted by scopes
+func (rh *routeHandler) requireScope(next http.HandlerFunc, scope string) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := auth.PrincipalFromRequest(r)
+		if err == nil && principal.HasScope(scope) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		rh.encodeError(json.NewEncoder(w).Encode(&JsonMessage{"API key is missing scope " + scope}), w)
 	})
 }
 
@@ -0,0 +1,286 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/cart"
+)
+
+// fakeAuth authenticates the bearer tokens and API keys in principals by their value
+type fakeAuth struct {
+	auth.Manager
+	principals map[string]*auth.Principal
+}
+
+func (f *fakeAuth) ValidateRequest(r *http.Request) (*http.Request, error) {
+	credential := r.Header.Get(auth.APIKeyHeader)
+	if credential == "" {
+		credential = r.Header.Get("Authorization")
+	}
+
+	principal, ok := f.principals[credential]
+	if !ok {
+		return nil, errors.New("Invalid authorization token")
+	}
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), principal)), nil
+}
+
+// Authenticate accepts the password "minimart123" of any username
+func (f *fakeAuth) Authenticate(r *http.Request) (*auth.TokenPair, error) {
+	var user auth.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		return nil, err
+	}
+
+	if user.Password != "minimart123" {
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	return &auth.TokenPair{AccessToken: "customer", TokenType: "Bearer", Subject: "1"}, nil
+}
+
+func (f *fakeAuth) GetAllAPIKeys() *[]auth.APIKeyInfo {
+	return &[]auth.APIKeyInfo{}
+}
+
+// fakeCart returns the principal of the request as the only line of the cart
+type fakeCart struct {
+	cart.Manager
+}
+
+func (f *fakeCart) GetAllCarts(r *http.Request) (*cart.CartSummary, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	line := cart.CartCollection{}
+	line.Name = principal.Subject
+
+	return &cart.CartSummary{Items: []cart.CartCollection{line}}, nil
+}
+
+func newTestRouter() Router {
+	authManager := &fakeAuth{principals: map[string]*auth.Principal{
+		"Bearer customer": {Subject: "1", Role: auth.RoleCustomer},
+		"Bearer admin":    {Subject: "2", Role: auth.RoleAdmin},
+		"products-key":    {Subject: "apikey:1", Role: auth.RoleStaff, APIKey: true, Scopes: []string{auth.ScopeProductsRead}},
+		"carts-key":       {Subject: "apikey:2", Role: auth.RoleCustomer, APIKey: true, Scopes: []string{auth.ScopeCartsRead}},
+		"wishlists-key":   {Subject: "apikey:3", Role: auth.RoleCustomer, APIKey: true, Scopes: []string{auth.ScopeWishlistsRead, auth.ScopeWishlistsWrite}},
+	}}
+
+	return NewRouter(nil, &fakeCart{}, authManager, nil, nil, nil)
+}
+
+func TestRouter_authorization(t *testing.T) {
+	guestToken := "AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA"
+	guest, err := cart.GuestPrincipal(guestToken)
+	if err != nil {
+		t.Fatalf("GuestPrincipal() error = %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		method        string
+		target        string
+		headers       map[string]string
+		wantCode      int
+		wantChallenge string
+		wantSubject   string
+	}{
+		{
+			name:        "Guest cart token",
+			method:      "GET",
+			target:      "/api/carts",
+			headers:     map[string]string{cart.CartTokenHeader: guestToken},
+			wantCode:    http.StatusOK,
+			wantSubject: guest.Subject,
+		},
+		{
+			name:     "Malformed cart token",
+			method:   "GET",
+			target:   "/api/carts",
+			headers:  map[string]string{cart.CartTokenHeader: "not-a-cart-token"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "Cart token with a bearer token uses the user cart",
+			method:      "GET",
+			target:      "/api/carts",
+			headers:     map[string]string{cart.CartTokenHeader: guestToken, "Authorization": "Bearer customer"},
+			wantCode:    http.StatusOK,
+			wantSubject: "1",
+		},
+		{
+			name:          "Cart token with an invalid bearer token",
+			method:        "GET",
+			target:        "/api/carts",
+			headers:       map[string]string{cart.CartTokenHeader: guestToken, "Authorization": "Bearer forged"},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="minimart-api", error="invalid_token"`,
+		},
+		{
+			name:          "No credentials",
+			method:        "GET",
+			target:        "/api/carts",
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: `Bearer realm="minimart-api"`,
+		},
+		{
+			name:        "API key with the scope",
+			method:      "GET",
+			target:      "/api/carts",
+			headers:     map[string]string{auth.APIKeyHeader: "carts-key"},
+			wantCode:    http.StatusOK,
+			wantSubject: "apikey:2",
+		},
+		{
+			name:     "API key without the scope",
+			method:   "GET",
+			target:   "/api/carts",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "API key without the scope and a cart token",
+			method:   "GET",
+			target:   "/api/carts",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key", cart.CartTokenHeader: guestToken},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Wishlists with a products only API key",
+			method:   "GET",
+			target:   "/api/wishlists",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Move to cart without carts:write",
+			method:   "POST",
+			target:   "/api/wishlists/saved-for-later/items/1/move-to-cart",
+			headers:  map[string]string{auth.APIKeyHeader: "wishlists-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Move from cart without wishlists:write",
+			method:   "POST",
+			target:   "/api/wishlists/saved-for-later/items/1/move-from-cart",
+			headers:  map[string]string{auth.APIKeyHeader: "carts-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Orders with a products only API key",
+			method:   "GET",
+			target:   "/api/orders/1",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Place order with a products only API key",
+			method:   "POST",
+			target:   "/api/orders",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Order status with a products only API key",
+			method:   "PUT",
+			target:   "/api/orders/1/status",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Pay order with a products only API key",
+			method:   "POST",
+			target:   "/api/orders/1/payment",
+			headers:  map[string]string{auth.APIKeyHeader: "products-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Role required",
+			method:   "GET",
+			target:   "/api/apikeys",
+			headers:  map[string]string{"Authorization": "Bearer customer"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Role granted",
+			method:   "GET",
+			target:   "/api/apikeys",
+			headers:  map[string]string{"Authorization": "Bearer admin"},
+			wantCode: http.StatusOK,
+		},
+	}
+	router := newTestRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			for header, value := range tt.headers {
+				r.Header.Set(header, value)
+			}
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("ServeHTTP() code = %v, want %v: %v", w.Code, tt.wantCode, w.Body.String())
+			}
+
+			if challenge := w.Header().Get("WWW-Authenticate"); challenge != tt.wantChallenge {
+				t.Errorf("ServeHTTP() WWW-Authenticate = %q, want %q", challenge, tt.wantChallenge)
+			}
+
+			if tt.wantSubject == "" {
+				return
+			}
+
+			var summary cart.CartSummary
+			if err := json.NewDecoder(w.Body).Decode(&summary); err != nil || len(summary.Items) != 1 || summary.Items[0].Name != tt.wantSubject {
+				t.Errorf("ServeHTTP() cart = %+v, %v, want the cart of %v", summary, err, tt.wantSubject)
+			}
+		})
+	}
+}
+
+func TestRouter_authenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "Correct password",
+			body:     `{"username":"juan","password":"minimart123"}`,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "Wrong password",
+			body:     `{"username":"juan","password":"wrong"}`,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Malformed body",
+			body:     `{"username":`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	router := newTestRouter()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("POST", "/api/authenticate", strings.NewReader(tt.body)))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %v, want %v: %v", w.Code, tt.wantCode, w.Body.String())
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package settings
 
 import (
+	"math"
 	"os"
+	"strconv"
+	"time"
 )
 
 func getEnv(envName, envDefault string) string {
@@ -48,6 +51,169 @@ func GetServerPrivateKey() string {
 	return getEnv("SERVER_PRIVATE_KEY", "./certs/key.pem")
 }
 
+// GetTokenSecret has no default, a guessable secret would let anyone sign tokens
 func GetTokenSecret() string {
-	return getEnv("TOKEN_SECRET", "notSoSecret")
+	return getEnv("TOKEN_SECRET", "")
+}
+
+// GetTokenSigningKey is the path of a PEM encoded RSA or Ed25519 private key, TOKEN_SECRET is used when it is empty
+func GetTokenSigningKey() string {
+	return getEnv("TOKEN_SIGNING_KEY", "")
+}
+
+func GetTokenSigningKeyID() string {
+	return getEnv("TOKEN_SIGNING_KEY_ID", "")
+}
+
+// GetTokenVerificationKeys are comma separated PEM public key paths, optionally as "kid=path", of keys rotated out of signing
+func GetTokenVerificationKeys() string {
+	return getEnv("TOKEN_VERIFICATION_KEYS", "")
+}
+
+func GetTokenIssuer() string {
+	return getEnv("TOKEN_ISSUER", "minimart-api")
+}
+
+func GetTokenAudience() string {
+	return getEnv("TOKEN_AUDIENCE", "minimart-api")
+}
+
+func GetTokenTTL() time.Duration {
+	return getDurationEnv("TOKEN_TTL", 15*time.Minute)
+}
+
+func GetRefreshTokenTTL() time.Duration {
+	return getDurationEnv("REFRESH_TOKEN_TTL", 7*24*time.Hour)
+}
+
+// GetAuthMode is either "local" for tokens issued by this API or "oidc" for tokens issued by an OpenID Connect provider
+func GetAuthMode() string {
+	return getEnv("AUTH_MODE", "local")
+}
+
+func GetOIDCIssuerURL() string {
+	return getEnv("OIDC_ISSUER_URL", "")
+}
+
+func GetOIDCJWKSFile() string {
+	return getEnv("OIDC_JWKS_FILE", "")
+}
+
+func GetOIDCAudience() string {
+	return getEnv("OIDC_AUDIENCE", "minimart-api")
+}
+
+// GetOIDCRoleMapping maps realm roles to API roles as comma separated "realmRole=role" pairs
+func GetOIDCRoleMapping() string {
+	return getEnv("OIDC_ROLE_MAPPING", "")
+}
+
+func GetAdminUsername() string {
+	return getEnv("ADMIN_USERNAME", "admin")
+}
+
+// GetAdminPassword has no default, the admin account is only created when it is set
+func GetAdminPassword() string {
+	return getEnv("ADMIN_PASSWORD", "")
+}
+
+// GetLoginMaxAttempts is the number of failed logins per username that starts the lockout
+func GetLoginMaxAttempts() int {
+	return getIntEnv("LOGIN_MAX_ATTEMPTS", 5)
+}
+
+// GetLoginMaxAttemptsPerIP is higher than per username since many shoppers can share an address
+func GetLoginMaxAttemptsPerIP() int {
+	return getIntEnv("LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+}
+
+func GetLoginBackoffBase() time.Duration {
+	return getDurationEnv("LOGIN_BACKOFF_BASE", time.Second)
+}
+
+func GetLoginBackoffMax() time.Duration {
+	return getDurationEnv("LOGIN_BACKOFF_MAX", 15*time.Minute)
+}
+
+// GetCartStore selects where carts are kept: "sql", "memory" or "redis"
+func GetCartStore() string {
+	return getEnv("CART_STORE", "sql")
+}
+
+// GetCartTTL is how long an idle cart is kept by the memory and redis stores
+func GetCartTTL() time.Duration {
+	return getDurationEnv("CART_TTL", time.Hour)
+}
+
+// GetCartMergeStrategy decides the quantity of a product in both the guest cart and the cart
+// of the shopper logging in: "sum" adds them up, "latest" keeps the quantity of the guest cart
+func GetCartMergeStrategy() string {
+	if strategy := os.Getenv("CART_MERGE_STRATEGY"); strategy == "latest" {
+		return strategy
+	}
+
+	return "sum"
+}
+
+// GetIdempotencyKeyTTL is how long an Idempotency-Key of a cart change is remembered
+func GetIdempotencyKeyTTL() time.Duration {
+	return getDurationEnv("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+}
+
+func GetRedisURL() string {
+	return getEnv("REDIS_URL", "redis://localhost:6379/0")
+}
+
+// GetTaxRate is TAX_RATE in basis points, TAX_RATE is a percentage such as "7" or "8.25"
+// added on top of the discounted cart subtotal
+func GetTaxRate() int64 {
+	rate, err := strconv.ParseFloat(os.Getenv("TAX_RATE"), 64)
+	if err != nil || rate < 0 || rate > 100 {
+		return 0
+	}
+
+	return int64(math.Round(rate * 100))
+}
+
+// GetTimeZone is the zone of TIME_ZONE that offer validity windows are given in, unknown
+// zones fall back to Asia/Singapore, the zone of the default data, or its fixed +08:00
+// offset when the zone database is not installed
+func GetTimeZone() *time.Location {
+	if location, err := time.LoadLocation(os.Getenv("TIME_ZONE")); err == nil && os.Getenv("TIME_ZONE") != "" {
+		return location
+	}
+
+	if location, err := time.LoadLocation("Asia/Singapore"); err == nil {
+		return location
+	}
+
+	return time.FixedZone("+08:00", 8*60*60)
+}
+
+func getIntEnv(envName string, envDefault int) int {
+	value, err := strconv.Atoi(os.Getenv(envName))
+	if err != nil || value < 0 {
+		return envDefault
+	}
+
+	return value
+}
+
+func getDurationEnv(envName string, envDefault time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(envName))
+	if err != nil || duration <= 0 {
+		return envDefault
+	}
+
+	return duration
+}
+
+// GetPaymentProvider names the payment gateway, only "fake" is built in
+func GetPaymentProvider() string {
+	return getEnv("PAYMENT_PROVIDER", "fake")
+}
+
+// GetPaymentWebhookSecret signs the payment status callbacks, callbacks are rejected when it is empty
+func GetPaymentWebhookSecret() string {
+	return getEnv("PAYMENT_WEBHOOK_SECRET", "")
 }
@@ -3,6 +3,7 @@ package settings
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestGetLogLevel(t *testing.T) {
@@ -64,3 +65,69 @@ func TestGetDBHost(t *testing.T) {
 		})
 	}
 }
+
+func TestGetTokenTTL(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{
+			name:  "TokenTTL from env",
+			value: "30m",
+			want:  30 * time.Minute,
+		},
+		{
+			name:  "TokenTTL invalid falls back to default",
+			value: "not-a-duration",
+			want:  15 * time.Minute,
+		},
+		{
+			name:  "TokenTTL negative falls back to default",
+			value: "-5m",
+			want:  15 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("TOKEN_TTL", tt.value)
+			if got := GetTokenTTL(); got != tt.want {
+				t.Errorf("GetTokenTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetTimeZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		offset int
+	}{
+		{
+			name:   "TimeZone from env",
+			value:  "UTC",
+			offset: 0,
+		},
+		{
+			name:   "TimeZone unknown falls back to Singapore",
+			value:  "Nowhere/Minimart",
+			offset: 8 * 60 * 60,
+		},
+		{
+			name:   "TimeZone defaults to Singapore",
+			value:  "",
+			offset: 8 * 60 * 60,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("TIME_ZONE", tt.value)
+			_, offset := time.Date(2019, 11, 1, 4, 1, 0, 0, GetTimeZone()).Zone()
+			if offset != tt.offset {
+				t.Errorf("GetTimeZone() offset = %v, want %v", offset, tt.offset)
+			}
+		})
+	}
+	os.Unsetenv("TIME_ZONE")
+}
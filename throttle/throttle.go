@@ -0,0 +1,197 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+type (
+	// Manager tracks failed attempts per key and backs off exponentially once
+	// MaxAttempts failures are reached
+	Manager interface {
+		// Check returns how long to wait before any of keys may be attempted again
+		Check(keys ...string) time.Duration
+		// Reserve counts an attempt for each key as failed unless one of keys is locked, then
+		// nothing is counted and it returns how long to wait. Checking and counting a key is
+		// one store update so parallel attempts can not all pass before the lockout starts.
+		Reserve(keys ...string) time.Duration
+		// Release takes back a reserved attempt of each key that did not fail
+		Release(keys ...string)
+		// Reset forgets the failed attempts of each key
+		Reset(keys ...string)
+	}
+
+	// Store holds the attempts per key
+	Store interface {
+		Get(key string) (Attempts, bool)
+		// Update replaces the attempts of key by the result of update in one atomic step,
+		// the entry expires after the returned ttl
+		Update(key string, update func(attempts Attempts) (Attempts, time.Duration))
+		Delete(key string)
+	}
+
+	Attempts struct {
+		Failures    int
+		LockedUntil time.Time
+	}
+
+	Config struct {
+		// MaxAttempts is the number of failures that starts the lockout
+		MaxAttempts int
+		// BaseDelay is the lockout after MaxAttempts failures, it doubles with every further failure
+		BaseDelay time.Duration
+		// MaxDelay caps the lockout
+		MaxDelay time.Duration
+		// ResetAfter forgets the failures of a key after this long without a new failure
+		ResetAfter time.Duration
+	}
+
+	throttleHandler struct {
+		store  Store
+		config Config
+		now    func() time.Time
+	}
+
+	memoryStore struct {
+		cache *gocache.Cache
+		mu    sync.Mutex
+	}
+)
+
+func NewManager(store Store, config Config) Manager {
+	return &throttleHandler{
+		store:  store,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// NewMemoryStore keeps attempts in process memory
+func NewMemoryStore() Store {
+	return &memoryStore{cache: gocache.New(gocache.NoExpiration, time.Minute*10)}
+}
+
+func (t *throttleHandler) Check(keys ...string) time.Duration {
+	var wait time.Duration
+	now := t.now()
+
+	for _, key := range keys {
+		if attempts, ok := t.store.Get(key); ok && attempts.LockedUntil.After(now) {
+			if remaining := attempts.LockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+
+	return wait
+}
+
+func (t *throttleHandler) Reserve(keys ...string) time.Duration {
+	for i, key := range keys {
+		if wait := t.reserve(key); wait > 0 {
+			t.Release(keys[:i]...)
+
+			if longest := t.Check(keys...); longest > wait {
+				return longest
+			}
+			return wait
+		}
+	}
+
+	return 0
+}
+
+func (t *throttleHandler) Release(keys ...string) {
+	for _, key := range keys {
+		t.store.Update(key, func(attempts Attempts) (Attempts, time.Duration) {
+			now := t.now()
+			if attempts.Failures > 0 {
+				attempts.Failures--
+			}
+
+			if t.delay(attempts.Failures) == 0 {
+				attempts.LockedUntil = time.Time{}
+			}
+
+			return attempts, t.ttl(attempts, now)
+		})
+	}
+}
+
+// reserve counts a failed attempt of key unless it is locked, it returns the wait of a locked key
+func (t *throttleHandler) reserve(key string) time.Duration {
+	var wait time.Duration
+
+	t.store.Update(key, func(attempts Attempts) (Attempts, time.Duration) {
+		now := t.now()
+		if attempts.LockedUntil.After(now) {
+			wait = attempts.LockedUntil.Sub(now)
+			return attempts, t.ttl(attempts, now)
+		}
+
+		attempts.Failures++
+		if delay := t.delay(attempts.Failures); delay > 0 {
+			attempts.LockedUntil = now.Add(delay)
+		}
+
+		return attempts, t.ttl(attempts, now)
+	})
+
+	return wait
+}
+
+// ttl keeps the attempts for ResetAfter and at least until the lockout ends
+func (t *throttleHandler) ttl(attempts Attempts, now time.Time) time.Duration {
+	if remaining := attempts.LockedUntil.Sub(now); remaining > t.config.ResetAfter {
+		return remaining
+	}
+
+	return t.config.ResetAfter
+}
+
+func (t *throttleHandler) Reset(keys ...string) {
+	for _, key := range keys {
+		t.store.Delete(key)
+	}
+}
+
+func (t *throttleHandler) delay(failures int) time.Duration {
+	excess := failures - t.config.MaxAttempts
+	if excess < 0 {
+		return 0
+	}
+
+	delay := t.config.BaseDelay
+	for i := 0; i < excess && delay < t.config.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > t.config.MaxDelay {
+		return t.config.MaxDelay
+	}
+
+	return delay
+}
+
+func (m *memoryStore) Get(key string) (Attempts, bool) {
+	if data, ok := m.cache.Get(key); ok {
+		return data.(Attempts), true
+	}
+
+	return Attempts{}, false
+}
+
+func (m *memoryStore) Update(key string, update func(attempts Attempts) (Attempts, time.Duration)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempts, _ := m.Get(key)
+	attempts, ttl := update(attempts)
+	m.cache.Set(key, attempts, ttl)
+}
+
+func (m *memoryStore) Delete(key string) {
+	m.cache.Delete(key)
+}
@@ -0,0 +1,126 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_throttleHandler_backoff(t *testing.T) {
+	now := time.Date(2019, 11, 1, 8, 0, 0, 0, time.UTC)
+	th := &throttleHandler{
+		store: NewMemoryStore(),
+		config: Config{
+			MaxAttempts: 3,
+			BaseDelay:   time.Second,
+			MaxDelay:    10 * time.Second,
+			ResetAfter:  15 * time.Minute,
+		},
+		now: func() time.Time { return now },
+	}
+
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{name: "Failures below max attempts are not delayed", failures: 2, want: 0},
+		{name: "Reaching max attempts waits the base delay", failures: 1, want: time.Second},
+		{name: "Delay doubles", failures: 1, want: 2 * time.Second},
+		{name: "Delay keeps doubling", failures: 1, want: 4 * time.Second},
+		{name: "Delay is capped", failures: 3, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < tt.failures; i++ {
+				// Attempts are refused while locked, wait for the lockout to end
+				now = now.Add(th.Check("user:juan"))
+				if wait := th.Reserve("user:juan"); wait != 0 {
+					t.Fatalf("Reserve() after the lockout = %v, want 0", wait)
+				}
+			}
+			if got := th.Check("user:juan"); got != tt.want {
+				t.Errorf("Check() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := th.Check("user:pedro"); got != 0 {
+		t.Errorf("Check() other key = %v, want 0", got)
+	}
+
+	if got := th.Check("user:pedro", "user:juan"); got != 10*time.Second {
+		t.Errorf("Check() of several keys = %v, want the longest wait", got)
+	}
+
+	now = now.Add(11 * time.Second)
+	if got := th.Check("user:juan"); got != 0 {
+		t.Errorf("Check() after lockout = %v, want 0", got)
+	}
+
+	th.Reset("user:juan")
+	th.Reserve("user:juan")
+	if got := th.Check("user:juan"); got != 0 {
+		t.Errorf("Check() after reset = %v, want 0", got)
+	}
+}
+
+func Test_throttleHandler_Reserve(t *testing.T) {
+	now := time.Date(2019, 11, 1, 8, 0, 0, 0, time.UTC)
+	th := &throttleHandler{
+		store:  NewMemoryStore(),
+		config: Config{MaxAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour},
+		now:    func() time.Time { return now },
+	}
+
+	if wait := th.Reserve("user:juan", "ip:10.0.0.1"); wait != 0 {
+		t.Fatalf("Reserve() = %v, want 0", wait)
+	}
+	th.Release("user:juan", "ip:10.0.0.1")
+
+	th.Reserve("user:juan")
+	if wait := th.Reserve("user:juan"); wait != 0 {
+		t.Fatalf("Reserve() of the last attempt = %v, want 0", wait)
+	}
+
+	if wait := th.Reserve("ip:10.0.0.1", "user:juan"); wait != time.Minute {
+		t.Errorf("Reserve() of a locked key = %v, want %v", wait, time.Minute)
+	}
+
+	if attempts, _ := th.store.Get("ip:10.0.0.1"); attempts.Failures != 0 {
+		t.Errorf("Reserve() of a locked key counted %v attempts of the other key, want 0", attempts.Failures)
+	}
+
+	if attempts, _ := th.store.Get("user:juan"); attempts.Failures != 2 {
+		t.Errorf("Reserve() of a locked key counted %v attempts, want 2", attempts.Failures)
+	}
+
+	th.Release("user:juan")
+	if wait := th.Check("user:juan"); wait != 0 {
+		t.Errorf("Check() after releasing the attempt that locked = %v, want 0", wait)
+	}
+}
+
+func Test_throttleHandler_Reserve_parallel(t *testing.T) {
+	th := NewManager(NewMemoryStore(), Config{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Hour, ResetAfter: time.Hour})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	passed := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if th.Reserve("user:juan") == 0 {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if passed != 3 {
+		t.Errorf("Reserve() let %v parallel attempts pass, want 3", passed)
+	}
+}
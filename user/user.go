@@ -0,0 +1,364 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/settings"
+)
+
+type (
+	Manager interface {
+		PopulateDefaultAdmin()
+		Register(body io.ReadCloser) (*Profile, error)
+		GetProfile(r *http.Request) (*Profile, error)
+		UpdateProfile(r *http.Request) (*Profile, error)
+		ChangePassword(r *http.Request) (string, error)
+		UpdateRole(r *http.Request, userID string) (*Profile, error)
+	}
+
+	userHandler struct {
+		dbManager db.Manager
+	}
+
+	Profile struct {
+		ID        uint      `json:"id"`
+		Username  string    `json:"username"`
+		Email     string    `json:"email"`
+		FullName  string    `json:"full_name"`
+		Role      string    `json:"role"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	RegisterReqBody struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email"`
+		FullName string `json:"full_name"`
+	}
+
+	ProfileReqBody struct {
+		Email    string `json:"email"`
+		FullName string `json:"full_name"`
+	}
+
+	PasswordReqBody struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	RoleReqBody struct {
+		Role string `json:"role"`
+	}
+)
+
+const (
+	minPasswordLength = 8
+	// bcrypt ignores everything after the first 72 bytes
+	maxPasswordLength = 72
+	maxEmailLength    = 254
+	maxFullNameLength = 100
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9._-]{3,40}$`)
+
+func NewManager(dbManager db.Manager) Manager {
+	return &userHandler{dbManager}
+}
+
+// PopulateDefaultAdmin makes sure the account configured by ADMIN_USERNAME and
+// ADMIN_PASSWORD exists with the admin role so roles can be assigned through the API.
+// An existing account is only promoted when its password is ADMIN_PASSWORD.
+func (u *userHandler) PopulateDefaultAdmin() {
+	username, password := auth.NormalizeUsername(settings.GetAdminUsername()), settings.GetAdminPassword()
+	if password == "" {
+		return
+	}
+
+	credential, err := u.dbManager.GetCredentialByUsername(username)
+	if err == nil {
+		if !auth.CheckPassword(credential.PasswordHash, password) {
+			logger.Log.Errorf("Unable to promote existing account:%v to admin, its password is not ADMIN_PASSWORD", credential.Username)
+			return
+		}
+
+		if credential.Role != auth.RoleAdmin {
+			credential.Role = auth.RoleAdmin
+			if err := u.dbManager.UpdateCredential(credential); err != nil {
+				logger.Log.Errorf("Unable to create default admin due to: %v", err)
+			}
+		}
+		return
+	}
+
+	if err := u.validatePassword(password, username); err != nil {
+		logger.Log.Errorf("Unable to create default admin due to: %v", err)
+		return
+	}
+
+	passwordHash, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Log.Errorf("Unable to create default admin due to: %v", err)
+		return
+	}
+
+	err = u.dbManager.CreateCredential(&entities.Credential{
+		Username:     username,
+		PasswordHash: passwordHash,
+		Role:         auth.RoleAdmin,
+	})
+	if err != nil {
+		logger.Log.Errorf("Unable to create default admin due to: %v", err)
+	}
+}
+
+func (u *userHandler) Register(body io.ReadCloser) (*Profile, error) {
+	var reqData RegisterReqBody
+	if err := json.NewDecoder(body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	reqData.Username = auth.NormalizeUsername(reqData.Username)
+	if !usernamePattern.MatchString(reqData.Username) {
+		return nil, errors.New("Username must be 3 to 40 characters of letters, digits, '.', '_' or '-'")
+	}
+
+	// The admin account is only created on startup, see PopulateDefaultAdmin
+	if reqData.Username == auth.NormalizeUsername(settings.GetAdminUsername()) {
+		return nil, fmt.Errorf("Username:%v is reserved", reqData.Username)
+	}
+
+	if _, err := u.dbManager.GetCredentialByUsername(reqData.Username); err == nil {
+		return nil, fmt.Errorf("Username:%v is already taken", reqData.Username)
+	}
+
+	if err := u.validatePassword(reqData.Password, reqData.Username); err != nil {
+		return nil, err
+	}
+
+	email, err := u.validateEmail(reqData.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	fullName, err := u.validateFullName(reqData.FullName)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := auth.HashPassword(reqData.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &entities.Credential{
+		Username:     reqData.Username,
+		PasswordHash: passwordHash,
+		Email:        email,
+		FullName:     fullName,
+		Role:         auth.RoleCustomer,
+	}
+
+	if err := u.dbManager.CreateCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return u.populateProfile(credential), nil
+}
+
+func (u *userHandler) GetProfile(r *http.Request) (*Profile, error) {
+	credential, err := u.getCredentialInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.populateProfile(credential), nil
+}
+
+func (u *userHandler) UpdateProfile(r *http.Request) (*Profile, error) {
+	var reqData ProfileReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	credential, err := u.getCredentialInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.Email, err = u.validateEmail(reqData.Email); err != nil {
+		return nil, err
+	}
+
+	if credential.FullName, err = u.validateFullName(reqData.FullName); err != nil {
+		return nil, err
+	}
+
+	if err := u.dbManager.UpdateCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return u.populateProfile(credential), nil
+}
+
+func (u *userHandler) ChangePassword(r *http.Request) (string, error) {
+	var reqData PasswordReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return "", err
+	}
+
+	credential, err := u.getCredentialInContext(r)
+	if err != nil {
+		return "", err
+	}
+
+	if !auth.CheckPassword(credential.PasswordHash, reqData.CurrentPassword) {
+		return "", errors.New("Current password is incorrect")
+	}
+
+	if err := u.validatePassword(reqData.NewPassword, credential.Username); err != nil {
+		return "", err
+	}
+
+	if credential.PasswordHash, err = auth.HashPassword(reqData.NewPassword); err != nil {
+		return "", err
+	}
+
+	if err := u.dbManager.UpdateCredential(credential); err != nil {
+		return "", err
+	}
+
+	// Sessions started with the old password must log in again
+	if err := u.dbManager.RevokeCredentialRefreshTokens(credential.ID); err != nil {
+		return "", err
+	}
+
+	return "Successfully changed password", nil
+}
+
+// UpdateRole changes the role of another user, the new role is carried by
+// tokens issued from the next login or refresh
+func (u *userHandler) UpdateRole(r *http.Request, userID string) (*Profile, error) {
+	var reqData RoleReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	if !auth.IsValidRole(reqData.Role) {
+		return nil, fmt.Errorf("Unknown role:%v", reqData.Role)
+	}
+
+	id, err := strconv.ParseUint(userID, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse userID:%v", userID)
+	}
+
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compare the parsed ids so "01" can not bypass the check
+	if subjectID, err := strconv.ParseUint(principal.Subject, 10, 32); err == nil && subjectID == id {
+		return nil, errors.New("Unable to change your own role")
+	}
+
+	credential, err := u.dbManager.GetCredentialByID(uint(id))
+	if err != nil {
+		return nil, err
+	}
+
+	credential.Role = reqData.Role
+	if err := u.dbManager.UpdateCredential(credential); err != nil {
+		return nil, err
+	}
+
+	return u.populateProfile(credential), nil
+}
+
+func (u *userHandler) getCredentialInContext(r *http.Request) (*entities.Credential, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := strconv.ParseUint(principal.Subject, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse user id:%v", principal.Subject)
+	}
+
+	return u.dbManager.GetCredentialByID(uint(id))
+}
+
+func (u *userHandler) validatePassword(password, username string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return fmt.Errorf("Password must be %v to %v characters long", minPasswordLength, maxPasswordLength)
+	}
+
+	var hasLetter, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			hasLetter = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+
+	if !hasLetter || !hasDigit {
+		return errors.New("Password must contain at least one letter and one digit")
+	}
+
+	if strings.EqualFold(password, username) {
+		return errors.New("Password must not be the same as the username")
+	}
+
+	return nil
+}
+
+func (u *userHandler) validateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" || len(email) > maxEmailLength {
+		return "", errors.New("A valid email address is required")
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", fmt.Errorf("Invalid email address:%v", email)
+	}
+
+	return email, nil
+}
+
+func (u *userHandler) validateFullName(fullName string) (string, error) {
+	fullName = strings.TrimSpace(fullName)
+	if len(fullName) > maxFullNameLength {
+		return "", fmt.Errorf("Full name must be at most %v characters long", maxFullNameLength)
+	}
+
+	return fullName, nil
+}
+
+func (u *userHandler) populateProfile(credential *entities.Credential) *Profile {
+	return &Profile{
+		ID:        credential.ID,
+		Username:  credential.Username,
+		Email:     credential.Email,
+		FullName:  credential.FullName,
+		Role:      credential.Role,
+		CreatedAt: credential.CreatedAt,
+	}
+}
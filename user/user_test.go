@@ -0,0 +1,315 @@
+package user
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_userHandler_validatePassword(t *testing.T) {
+	type args struct {
+		password string
+		username string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name:    "Valid password",
+			args:    args{password: "minimart123", username: "juan"},
+			wantErr: false,
+		},
+		{
+			name:    "Too short",
+			args:    args{password: "abc12", username: "juan"},
+			wantErr: true,
+		},
+		{
+			name:    "Letters only",
+			args:    args{password: "minimartpassword", username: "juan"},
+			wantErr: true,
+		},
+		{
+			name:    "Digits only",
+			args:    args{password: "1234567890", username: "juan"},
+			wantErr: true,
+		},
+		{
+			name:    "Same as username",
+			args:    args{password: "Juan12345", username: "juan12345"},
+			wantErr: true,
+		},
+		{
+			name:    "Longer than bcrypt limit",
+			args:    args{password: "a1234567890123456789012345678901234567890123456789012345678901234567890123", username: "juan"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &userHandler{}
+			if err := u.validatePassword(tt.args.password, tt.args.username); (err != nil) != tt.wantErr {
+				t.Errorf("validatePassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_userHandler_validateEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		email   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "Valid email",
+			email: "juan@example.com",
+			want:  "juan@example.com",
+		},
+		{
+			name:  "Surrounding spaces are trimmed",
+			email: "  juan@example.com ",
+			want:  "juan@example.com",
+		},
+		{
+			name:    "Empty email",
+			email:   "",
+			wantErr: true,
+		},
+		{
+			name:    "Missing domain",
+			email:   "juan@",
+			wantErr: true,
+		},
+		{
+			name:    "Display name is not accepted",
+			email:   "Juan <juan@example.com>",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := &userHandler{}
+			got, err := u.validateEmail(tt.email)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateEmail() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("validateEmail() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeUserDB struct {
+	db.Manager
+	credentials map[uint]*entities.Credential
+}
+
+func (f *fakeUserDB) GetCredentialByID(id uint) (*entities.Credential, error) {
+	credential, ok := f.credentials[id]
+	if !ok {
+		return nil, errors.New("Credential does not exist")
+	}
+	return credential, nil
+}
+
+// GetCredentialByUsername ignores case like the lookup of the database
+func (f *fakeUserDB) GetCredentialByUsername(username string) (*entities.Credential, error) {
+	for _, credential := range f.credentials {
+		if strings.EqualFold(credential.Username, username) {
+			return credential, nil
+		}
+	}
+	return nil, errors.New("Credential does not exist")
+}
+
+func (f *fakeUserDB) CreateCredential(credential *entities.Credential) error {
+	credential.ID = uint(len(f.credentials) + 1)
+	f.credentials[credential.ID] = credential
+	return nil
+}
+
+func (f *fakeUserDB) UpdateCredential(credential *entities.Credential) error {
+	f.credentials[credential.ID] = credential
+	return nil
+}
+
+func Test_userHandler_Register(t *testing.T) {
+	u := &userHandler{dbManager: &fakeUserDB{credentials: map[uint]*entities.Credential{
+		1: {Username: "Pedro", Role: auth.RoleCustomer},
+	}}}
+
+	tests := []struct {
+		name         string
+		username     string
+		wantUsername string
+		wantErr      bool
+	}{
+		{
+			name:         "Username is stored lowercased",
+			username:     " Alice ",
+			wantUsername: "alice",
+		},
+		{
+			name:     "Username taken in another case",
+			username: "ALICE",
+			wantErr:  true,
+		},
+		{
+			name:     "Username stored before lowercasing is taken",
+			username: "pedro",
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid username",
+			username: "al",
+			wantErr:  true,
+		},
+		{
+			name:     "Admin username is reserved",
+			username: "Admin",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"username":"` + tt.username + `","password":"minimart123","email":"shopper@example.com"}`
+
+			got, err := u.Register(ioutil.NopCloser(strings.NewReader(body)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (got.Username != tt.wantUsername || got.Role != auth.RoleCustomer) {
+				t.Errorf("Register() = %+v, want customer %v", got, tt.wantUsername)
+			}
+		})
+	}
+}
+
+func Test_userHandler_UpdateRole(t *testing.T) {
+	admin := &entities.Credential{Username: "admin", Role: auth.RoleAdmin}
+	admin.ID = 1
+	juan := &entities.Credential{Username: "juan", Role: auth.RoleCustomer}
+	juan.ID = 2
+	u := &userHandler{dbManager: &fakeUserDB{credentials: map[uint]*entities.Credential{1: admin, 2: juan}}}
+
+	tests := []struct {
+		name     string
+		userID   string
+		body     string
+		wantRole string
+		wantErr  bool
+	}{
+		{
+			name:     "Role of another user",
+			userID:   "2",
+			body:     `{"role":"store-staff"}`,
+			wantRole: auth.RoleStaff,
+		},
+		{
+			name:    "Unknown role",
+			userID:  "2",
+			body:    `{"role":"owner"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Own role",
+			userID:  "1",
+			body:    `{"role":"customer"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Own role with a leading zero",
+			userID:  "01",
+			body:    `{"role":"customer"}`,
+			wantErr: true,
+		},
+		{
+			name:    "Unknown user",
+			userID:  "3",
+			body:    `{"role":"customer"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/api/users/"+tt.userID+"/role", bytes.NewBufferString(tt.body))
+			r = r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: "1", Role: auth.RoleAdmin}))
+
+			got, err := u.UpdateRole(r, tt.userID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UpdateRole() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.Role != tt.wantRole {
+				t.Errorf("UpdateRole() role = %v, want %v", got.Role, tt.wantRole)
+			}
+		})
+	}
+
+	if admin.Role != auth.RoleAdmin {
+		t.Errorf("UpdateRole() changed the role of the admin to %v", admin.Role)
+	}
+}
+
+func Test_userHandler_PopulateDefaultAdmin(t *testing.T) {
+	os.Setenv("ADMIN_PASSWORD", "minimart-admin1")
+	defer os.Unsetenv("ADMIN_PASSWORD")
+
+	adminHash, _ := bcrypt.GenerateFromPassword([]byte("minimart-admin1"), bcrypt.MinCost)
+	otherHash, _ := bcrypt.GenerateFromPassword([]byte("registered123"), bcrypt.MinCost)
+
+	tests := []struct {
+		name     string
+		existing *entities.Credential
+		wantRole string
+	}{
+		{
+			name:     "Admin account is created",
+			wantRole: auth.RoleAdmin,
+		},
+		{
+			name:     "Account with ADMIN_PASSWORD is promoted",
+			existing: &entities.Credential{Username: "Admin", PasswordHash: string(adminHash), Role: auth.RoleCustomer},
+			wantRole: auth.RoleAdmin,
+		},
+		{
+			name:     "Account with another password keeps its role",
+			existing: &entities.Credential{Username: "admin", PasswordHash: string(otherHash), Role: auth.RoleCustomer},
+			wantRole: auth.RoleCustomer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeDB := &fakeUserDB{credentials: map[uint]*entities.Credential{}}
+			if tt.existing != nil {
+				tt.existing.ID = 1
+				fakeDB.credentials[1] = tt.existing
+			}
+			u := &userHandler{dbManager: fakeDB}
+
+			u.PopulateDefaultAdmin()
+
+			credential, err := fakeDB.GetCredentialByUsername("admin")
+			if err != nil {
+				t.Fatalf("PopulateDefaultAdmin() left no admin account: %v", err)
+			}
+			if len(fakeDB.credentials) != 1 || credential.Role != tt.wantRole {
+				t.Errorf("PopulateDefaultAdmin() admin account = %+v of %v accounts, want role %v", credential, len(fakeDB.credentials), tt.wantRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,377 @@
+package wishlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/cart"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+	"github.com/emanpicar/minimart-api/logger"
+	"github.com/emanpicar/minimart-api/product"
+)
+
+type (
+	Manager interface {
+		GetAllWishlists(r *http.Request) (*[]Wishlist, error)
+		GetWishlist(r *http.Request, wishlistID string) (*Wishlist, error)
+		CreateWishlist(r *http.Request) (*Wishlist, error)
+		RenameWishlist(r *http.Request, wishlistID string) (*Wishlist, error)
+		DeleteWishlist(r *http.Request, wishlistID string) (string, error)
+		AddToWishlist(r *http.Request, wishlistID string) (string, error)
+		DeleteFromWishlist(r *http.Request, wishlistID, productID string) (string, error)
+		MoveFromCart(r *http.Request, wishlistID, productID string) (string, error)
+		MoveToCart(r *http.Request, wishlistID, productID string) (string, error)
+	}
+
+	wishlistHandler struct {
+		dbManager   db.Manager
+		cartManager cart.Manager
+		now         func() time.Time
+	}
+
+	Wishlist struct {
+		ID            uint           `json:"id"`
+		Name          string         `json:"name"`
+		SavedForLater bool           `json:"saved_for_later"`
+		Items         []WishlistItem `json:"items"`
+	}
+
+	// WishlistItem has its Quantity in Unit g for products sold by weight
+	WishlistItem struct {
+		product.ProductCollection
+		Quantity int    `json:"quantity"`
+		Unit     string `json:"unit,omitempty"`
+	}
+
+	WishlistReqBody struct {
+		Name string `json:"name"`
+	}
+)
+
+const (
+	// SavedForLaterID can be used instead of the id of the saved for later list
+	SavedForLaterID = "saved-for-later"
+
+	maxNameLength = 100
+)
+
+func NewManager(dbManager db.Manager, cartManager cart.Manager) Manager {
+	return &wishlistHandler{
+		dbManager:   dbManager,
+		cartManager: cartManager,
+		now:         time.Now,
+	}
+}
+
+func (w *wishlistHandler) GetAllWishlists(r *http.Request) (*[]Wishlist, error) {
+	owner, err := w.getOwnerInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every owner sees the saved for later list, even before anything was saved to it
+	if _, err := w.dbManager.GetSavedForLater(owner); err != nil {
+		return nil, err
+	}
+
+	wishlists, err := w.dbManager.GetWishlists(owner)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []Wishlist{}
+	for i := range *wishlists {
+		data = append(data, w.populateWishlist(&(*wishlists)[i]))
+	}
+
+	return &data, nil
+}
+
+func (w *wishlistHandler) GetWishlist(r *http.Request, wishlistID string) (*Wishlist, error) {
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := w.populateWishlist(wishlist)
+
+	return &data, nil
+}
+
+func (w *wishlistHandler) CreateWishlist(r *http.Request) (*Wishlist, error) {
+	var reqData WishlistReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	owner, err := w.getOwnerInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := w.validateName(reqData.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	wishlist := &entities.Wishlist{Owner: owner, Name: name}
+	if err := w.dbManager.CreateWishlist(wishlist); err != nil {
+		return nil, err
+	}
+
+	data := w.populateWishlist(wishlist)
+
+	return &data, nil
+}
+
+func (w *wishlistHandler) RenameWishlist(r *http.Request, wishlistID string) (*Wishlist, error) {
+	var reqData WishlistReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return nil, err
+	}
+
+	name, err := w.validateName(reqData.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	if wishlist.SavedForLater {
+		return nil, errors.New("Saved for later list can not be renamed")
+	}
+
+	if err := w.dbManager.RenameWishlist(wishlist.Owner, wishlist.ID, name); err != nil {
+		return nil, err
+	}
+
+	wishlist.Name = name
+	data := w.populateWishlist(wishlist)
+
+	return &data, nil
+}
+
+func (w *wishlistHandler) DeleteWishlist(r *http.Request, wishlistID string) (string, error) {
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return "", err
+	}
+
+	if wishlist.SavedForLater {
+		return "", errors.New("Saved for later list can not be deleted")
+	}
+
+	if err := w.dbManager.DeleteWishlist(wishlist.Owner, wishlist.ID); err != nil {
+		return "", err
+	}
+
+	return "Successfully deleted wishlist", nil
+}
+
+// AddToWishlist adds a product to the wishlist or replaces its quantity, quantities are
+// read like cart quantities and default to one unit
+func (w *wishlistHandler) AddToWishlist(r *http.Request, wishlistID string) (string, error) {
+	var reqData cart.CartReqBody
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		return "", err
+	}
+
+	if reqData.Quantity == "" {
+		reqData.Quantity = "1"
+	}
+
+	quantity, err := w.cartManager.ParseQuantity(reqData.ID, reqData.Quantity, reqData.Unit)
+	if err != nil {
+		return "", err
+	}
+
+	if quantity < 1 {
+		return "", errors.New("Quantity must be at least 1")
+	}
+
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := w.dbManager.SaveWishlistItem(&entities.WishlistItem{WishlistID: wishlist.ID, ProductID: reqData.ID, Quantity: quantity}); err != nil {
+		return "", err
+	}
+
+	return "Successfully added to wishlist", nil
+}
+
+func (w *wishlistHandler) DeleteFromWishlist(r *http.Request, wishlistID, productID string) (string, error) {
+	pID, err := strconv.ParseUint(productID, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse productID:%v", productID)
+	}
+
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := w.dbManager.DeleteWishlistItem(wishlist.ID, uint(pID)); err != nil {
+		return "", err
+	}
+
+	return "Successfully deleted in wishlist", nil
+}
+
+// MoveFromCart takes a product out of the cart and saves it to the wishlist with its
+// cart quantity, the product is put back in the cart when it can not be saved
+func (w *wishlistHandler) MoveFromCart(r *http.Request, wishlistID, productID string) (string, error) {
+	pID, err := strconv.ParseUint(productID, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse productID:%v", productID)
+	}
+
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return "", err
+	}
+
+	quantity, err := w.cartManager.RemoveItem(wishlist.Owner, uint(pID))
+	if err != nil {
+		return "", err
+	}
+
+	err = w.dbManager.SaveWishlistItem(&entities.WishlistItem{WishlistID: wishlist.ID, ProductID: uint(pID), Quantity: quantity})
+	if err != nil {
+		if restoreErr := w.cartManager.AddItem(wishlist.Owner, uint(pID), quantity); restoreErr != nil {
+			logger.Log.Errorf("Unable to put product:%v back in cart of owner:%v due to: %v", pID, wishlist.Owner, restoreErr)
+		}
+		return "", err
+	}
+
+	return "Successfully moved to wishlist", nil
+}
+
+// MoveToCart adds a product of the wishlist to the cart, its quantity is added to the
+// one already in the cart and validated like any other cart change
+func (w *wishlistHandler) MoveToCart(r *http.Request, wishlistID, productID string) (string, error) {
+	pID, err := strconv.ParseUint(productID, 10, 32)
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse productID:%v", productID)
+	}
+
+	wishlist, err := w.getWishlistInContext(r, wishlistID)
+	if err != nil {
+		return "", err
+	}
+
+	item := w.findWishlistItem(wishlist.Items, uint(pID))
+	if item == nil {
+		return "", errors.New("Product does not exist in wishlist")
+	}
+
+	if err := w.cartManager.AddItem(wishlist.Owner, item.ProductID, item.Quantity); err != nil {
+		return "", err
+	}
+
+	// The product is in the cart, failing the request would have a retry add it twice
+	if err := w.dbManager.DeleteWishlistItem(wishlist.ID, item.ProductID); err != nil {
+		logger.Log.Warnf("Unable to remove product:%v from wishlist:%v due to: %v", item.ProductID, wishlist.ID, err)
+	}
+
+	return "Successfully moved to cart", nil
+}
+
+// getWishlistInContext returns the wishlist of wishlistID when it belongs to the principal,
+// SavedForLaterID is the saved for later list
+func (w *wishlistHandler) getWishlistInContext(r *http.Request, wishlistID string) (*entities.Wishlist, error) {
+	owner, err := w.getOwnerInContext(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if wishlistID == SavedForLaterID {
+		return w.dbManager.GetSavedForLater(owner)
+	}
+
+	id, err := strconv.ParseUint(wishlistID, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse wishlistID:%v", wishlistID)
+	}
+
+	return w.dbManager.GetWishlist(owner, uint(id))
+}
+
+func (w *wishlistHandler) getOwnerInContext(r *http.Request) (string, error) {
+	principal, err := auth.PrincipalFromRequest(r)
+	if err != nil {
+		return "", err
+	}
+
+	return principal.Subject, nil
+}
+
+func (w *wishlistHandler) validateName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxNameLength {
+		return "", fmt.Errorf("Name must be 1 to %v characters", maxNameLength)
+	}
+
+	if strings.EqualFold(name, entities.SavedForLaterName) {
+		return "", fmt.Errorf("Name %v is reserved", entities.SavedForLaterName)
+	}
+
+	return name, nil
+}
+
+func (w *wishlistHandler) findWishlistItem(items []entities.WishlistItem, pID uint) *entities.WishlistItem {
+	for i := range items {
+		if items[i].ProductID == pID {
+			return &items[i]
+		}
+	}
+
+	return nil
+}
+
+func (w *wishlistHandler) populateWishlist(wishlist *entities.Wishlist) Wishlist {
+	data := Wishlist{
+		ID:            wishlist.ID,
+		Name:          wishlist.Name,
+		SavedForLater: wishlist.SavedForLater,
+		Items:         []WishlistItem{},
+	}
+	now := w.now()
+
+	for _, item := range wishlist.Items {
+		productCol, err := w.dbManager.GetProductByID(item.ProductID)
+		if err != nil {
+			// The product was removed from the catalog after it was saved
+			continue
+		}
+
+		itemData := WishlistItem{Quantity: item.Quantity}
+		itemData.ID = productCol.ID
+		itemData.Name = productCol.Name
+		itemData.Slug = productCol.Slug
+		itemData.SalesPrice = product.SalesPrice(productCol, now)
+
+		if len(productCol.Images) > 0 {
+			itemData.Image = productCol.Images[0].Value
+		}
+
+		if productCol.SoldByWeight {
+			itemData.Unit = product.UnitGram
+		}
+
+		data.Items = append(data.Items, itemData)
+	}
+
+	return data
+}
@@ -0,0 +1,275 @@
+package wishlist
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/emanpicar/minimart-api/auth"
+	"github.com/emanpicar/minimart-api/cart"
+	"github.com/emanpicar/minimart-api/db"
+	"github.com/emanpicar/minimart-api/db/entities"
+)
+
+type fakeWishlistDB struct {
+	db.Manager
+	wishlists []entities.Wishlist
+	// failSave makes SaveWishlistItem fail like a lost connection
+	failSave bool
+}
+
+func (f *fakeWishlistDB) GetProductByID(pID uint) (*entities.ProductCollection, error) {
+	if pID == 0 || pID > 100 {
+		return nil, fmt.Errorf("Product with productID:%v does not exist", pID)
+	}
+
+	stock := 10
+	return &entities.ProductCollection{ID: pID, Name: fmt.Sprintf("Product %v", pID), BasePrice: 150, Stock: &stock}, nil
+}
+
+func (f *fakeWishlistDB) GetWishlists(owner string) (*[]entities.Wishlist, error) {
+	data := []entities.Wishlist{}
+	for _, wishlist := range f.wishlists {
+		if wishlist.Owner == owner {
+			data = append(data, wishlist)
+		}
+	}
+
+	return &data, nil
+}
+
+func (f *fakeWishlistDB) GetWishlist(owner string, id uint) (*entities.Wishlist, error) {
+	for _, wishlist := range f.wishlists {
+		if wishlist.Owner == owner && wishlist.ID == id {
+			wishlist.Items = append([]entities.WishlistItem{}, wishlist.Items...)
+			return &wishlist, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Wishlist with id:%v does not exist", id)
+}
+
+func (f *fakeWishlistDB) GetSavedForLater(owner string) (*entities.Wishlist, error) {
+	for _, wishlist := range f.wishlists {
+		if wishlist.Owner == owner && wishlist.SavedForLater {
+			return f.GetWishlist(owner, wishlist.ID)
+		}
+	}
+
+	wishlist := &entities.Wishlist{Owner: owner, Name: entities.SavedForLaterName, SavedForLater: true}
+	if err := f.CreateWishlist(wishlist); err != nil {
+		return nil, err
+	}
+
+	return wishlist, nil
+}
+
+func (f *fakeWishlistDB) CreateWishlist(wishlist *entities.Wishlist) error {
+	for _, existing := range f.wishlists {
+		if existing.Owner == wishlist.Owner && existing.Name == wishlist.Name {
+			return fmt.Errorf("Wishlist named %v already exists", wishlist.Name)
+		}
+	}
+
+	wishlist.ID = uint(len(f.wishlists) + 1)
+	f.wishlists = append(f.wishlists, *wishlist)
+
+	return nil
+}
+
+func (f *fakeWishlistDB) SaveWishlistItem(item *entities.WishlistItem) error {
+	if f.failSave {
+		return errors.New("Unable to save product in wishlist")
+	}
+
+	wishlist := &f.wishlists[item.WishlistID-1]
+	for i := range wishlist.Items {
+		if wishlist.Items[i].ProductID == item.ProductID {
+			wishlist.Items[i].Quantity = item.Quantity
+			return nil
+		}
+	}
+	wishlist.Items = append(wishlist.Items, *item)
+
+	return nil
+}
+
+func (f *fakeWishlistDB) DeleteWishlistItem(wishlistID, productID uint) error {
+	wishlist := &f.wishlists[wishlistID-1]
+	for i := range wishlist.Items {
+		if wishlist.Items[i].ProductID == productID {
+			wishlist.Items = append(wishlist.Items[:i], wishlist.Items[i+1:]...)
+			return nil
+		}
+	}
+
+	return errors.New("Product does not exist in wishlist")
+}
+
+func newWishlistRequest(method, target, body, owner string) *http.Request {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+
+	return r.WithContext(auth.WithPrincipal(r.Context(), &auth.Principal{Subject: owner, Role: auth.RoleCustomer}))
+}
+
+func newTestManager() (*fakeWishlistDB, cart.CartStore, Manager) {
+	fakeDB := &fakeWishlistDB{}
+	store := cart.NewMemoryStore(time.Hour, time.Hour)
+
+	return fakeDB, store, NewManager(fakeDB, cart.NewManager(fakeDB, store, nil))
+}
+
+func cartQuantities(t *testing.T, store cart.CartStore, owner string) map[uint]int {
+	items, err := store.Get(owner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	quantities := make(map[uint]int)
+	for _, item := range items {
+		quantities[item.ProductID] = item.Quantity
+	}
+
+	return quantities
+}
+
+func Test_wishlistHandler_saveForLater(t *testing.T) {
+	fakeDB, store, w := newTestManager()
+	store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+		return []cart.CartItem{{ProductID: 1, Quantity: 3}, {ProductID: 2, Quantity: 1}}, nil
+	})
+
+	if _, err := w.MoveFromCart(newWishlistRequest("POST", "/", "", "1"), SavedForLaterID, "1"); err != nil {
+		t.Fatalf("MoveFromCart() error = %v", err)
+	}
+	if _, err := w.MoveFromCart(newWishlistRequest("POST", "/", "", "1"), SavedForLaterID, "3"); err == nil {
+		t.Errorf("MoveFromCart() accepted a product that is not in the cart")
+	}
+
+	fakeDB.failSave = true
+	if _, err := w.MoveFromCart(newWishlistRequest("POST", "/", "", "1"), SavedForLaterID, "2"); err == nil {
+		t.Errorf("MoveFromCart() error = nil, want the failed save")
+	}
+	fakeDB.failSave = false
+
+	if got := cartQuantities(t, store, "1"); len(got) != 1 || got[2] != 1 {
+		t.Errorf("cart after MoveFromCart() = %v, want product 2 put back", got)
+	}
+
+	wishlists, err := w.GetAllWishlists(newWishlistRequest("GET", "/", "", "1"))
+	if err != nil {
+		t.Fatalf("GetAllWishlists() error = %v", err)
+	}
+	if got := *wishlists; len(got) != 1 || !got[0].SavedForLater || len(got[0].Items) != 1 || got[0].Items[0].Quantity != 3 {
+		t.Errorf("GetAllWishlists() = %+v, want product 1 saved for later with quantity 3", got)
+	}
+
+	if _, err := w.MoveToCart(newWishlistRequest("POST", "/", "", "1"), SavedForLaterID, "1"); err != nil {
+		t.Fatalf("MoveToCart() error = %v", err)
+	}
+	if got := cartQuantities(t, store, "1"); got[1] != 3 {
+		t.Errorf("cart after MoveToCart() = %v, want product 1 with quantity 3", got)
+	}
+	if saved, _ := w.GetWishlist(newWishlistRequest("GET", "/", "", "1"), SavedForLaterID); len(saved.Items) != 0 {
+		t.Errorf("GetWishlist() after MoveToCart() = %+v, want no items", saved.Items)
+	}
+}
+
+func Test_wishlistHandler_MoveToCart(t *testing.T) {
+	fakeDB, store, w := newTestManager()
+
+	created, err := w.CreateWishlist(newWishlistRequest("POST", "/", `{"name":"Birthday"}`, "1"))
+	if err != nil {
+		t.Fatalf("CreateWishlist() error = %v", err)
+	}
+	wishlistID := fmt.Sprint(created.ID)
+
+	for _, body := range []string{`{"id":1}`, `{"id":2,"quantity":8}`} {
+		if _, err := w.AddToWishlist(newWishlistRequest("POST", "/", body, "1"), wishlistID); err != nil {
+			t.Fatalf("AddToWishlist(%v) error = %v", body, err)
+		}
+	}
+	store.Update("1", func(items []cart.CartItem) ([]cart.CartItem, error) {
+		return []cart.CartItem{{ProductID: 1, Quantity: 2}, {ProductID: 2, Quantity: 5}}, nil
+	})
+
+	tests := []struct {
+		name         string
+		owner        string
+		productID    uint
+		wantErr      bool
+		wantLimit    string
+		wantQuantity int
+	}{
+		{
+			name:         "Quantity is added to the cart",
+			owner:        "1",
+			productID:    1,
+			wantQuantity: 3,
+		},
+		{
+			name:         "Cart validation rejects the quantity",
+			owner:        "1",
+			productID:    2,
+			wantErr:      true,
+			wantLimit:    cart.LimitStock,
+			wantQuantity: 5,
+		},
+		{
+			name:      "Wishlist of another owner",
+			owner:     "2",
+			productID: 2,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := w.MoveToCart(newWishlistRequest("POST", "/", "", tt.owner), wishlistID, fmt.Sprint(tt.productID))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MoveToCart() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var quantityErr *cart.QuantityError
+			if tt.wantLimit != "" && (!errors.As(err, &quantityErr) || quantityErr.Limit != tt.wantLimit) {
+				t.Errorf("MoveToCart() error = %v, want limit %v", err, tt.wantLimit)
+			}
+
+			if tt.wantQuantity > 0 {
+				if got := cartQuantities(t, store, tt.owner)[tt.productID]; got != tt.wantQuantity {
+					t.Errorf("cart quantity = %v, want %v", got, tt.wantQuantity)
+				}
+			}
+		})
+	}
+
+	if items := fakeDB.wishlists[created.ID-1].Items; len(items) != 1 || items[0].ProductID != 2 {
+		t.Errorf("wishlist items = %+v, want only the product that was not moved", items)
+	}
+}
+
+func Test_wishlistHandler_validateName(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Trimmed", value: "  Birthday ", want: "Birthday"},
+		{name: "Empty", value: "   ", wantErr: true},
+		{name: "Too long", value: string(bytes.Repeat([]byte("a"), maxNameLength+1)), wantErr: true},
+		{name: "Reserved", value: "saved for later", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &wishlistHandler{}
+			got, err := w.validateName(tt.value)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("validateName() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}